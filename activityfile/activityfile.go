@@ -0,0 +1,149 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+// Package activityfile parses FIT, GPX, and TCX activity files (optionally
+// gzipped), validating their structure and extracting a summary of the
+// activity.
+package activityfile
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Supported formats.
+const (
+	FIT = "fit"
+	GPX = "gpx"
+	TCX = "tcx"
+)
+
+// Summary summarizes an activity file.
+type Summary struct {
+	// Format is the format of the file: FIT, GPX, or TCX.
+	Format string
+	// Gzipped is true if the file was gzipped.
+	Gzipped bool
+	// Name is the activity name, if the file has one.
+	Name string
+	// Sport is the sport as recorded in the file, if any; for example,
+	// "running" or "Biking". The values depend on the format and device.
+	Sport string
+	// Device describes the device or application that created the file,
+	// if known.
+	Device string
+	// Start is the start time of the activity.
+	Start time.Time
+	// Duration is the elapsed time of the activity.
+	Duration time.Duration
+	// Distance is the distance covered, in meters.
+	Distance float64
+}
+
+// FileType returns the Strava data type for the file; for example, "gpx"
+// or "fit.gz".
+func (s *Summary) FileType() string {
+	if s.Gzipped {
+		return s.Format + ".gz"
+	}
+	return s.Format
+}
+
+// ParseFile parses the activity file at path.
+func ParseFile(path string) (*Summary, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Parse parses an activity file from r. The format (and whether it's
+// gzipped) is detected from the content.
+func Parse(r io.Reader) (*Summary, error) {
+	br := bufio.NewReader(r)
+	gzipped := false
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip data: %v", err)
+		}
+		defer gz.Close()
+		br = bufio.NewReader(gz)
+		gzipped = true
+	}
+	data, err := ioutil.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	format, err := detectFormat(data)
+	if err != nil {
+		return nil, err
+	}
+	var s *Summary
+	switch format {
+	case FIT:
+		s, err = parseFIT(data)
+	case GPX:
+		s, err = parseGPX(data)
+	case TCX:
+		s, err = parseTCX(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", format, err)
+	}
+	s.Format = format
+	s.Gzipped = gzipped
+	return s, nil
+}
+
+// detectFormat returns the format of data.
+func detectFormat(data []byte) (string, error) {
+	if len(data) >= 12 && string(data[8:12]) == ".FIT" {
+		return FIT, nil
+	}
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return "", errors.New("unrecognized file format (not FIT, GPX, or TCX)")
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			switch start.Name.Local {
+			case "gpx":
+				return GPX, nil
+			case "TrainingCenterDatabase":
+				return TCX, nil
+			}
+			return "", fmt.Errorf("unrecognized XML file with root element <%s>", start.Name.Local)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package activityfile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testGPX = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="Fake Device">
+  <metadata><name>Metadata Name</name></metadata>
+  <trk>
+    <name>Lunch Ride</name>
+    <type>cycling</type>
+    <trkseg>
+      <trkpt lat="0" lon="0"><time>2019-02-22T12:00:00Z</time></trkpt>
+      <trkpt lat="0" lon="0.01"><time>2019-02-22T12:05:00Z</time></trkpt>
+      <trkpt lat="0" lon="0.02"><time>2019-02-22T12:10:00Z</time></trkpt>
+    </trkseg>
+  </trk>
+</gpx>
+`
+
+const testTCX = `<?xml version="1.0" encoding="UTF-8"?>
+<TrainingCenterDatabase xmlns="http://www.garmin.com/xmlschemas/TrainingCenterDatabase/v2">
+  <Activities>
+    <Activity Sport="Running">
+      <Lap StartTime="2019-02-22T07:30:00Z">
+        <TotalTimeSeconds>600</TotalTimeSeconds>
+        <DistanceMeters>2000</DistanceMeters>
+      </Lap>
+      <Lap StartTime="2019-02-22T07:40:00Z">
+        <TotalTimeSeconds>300.5</TotalTimeSeconds>
+        <DistanceMeters>1000</DistanceMeters>
+      </Lap>
+      <Creator><Name>Fake Watch</Name></Creator>
+    </Activity>
+  </Activities>
+</TrainingCenterDatabase>
+`
+
+// fitField is a field in a FIT message built by testFIT.
+type fitField struct {
+	num      byte
+	baseType byte
+	value    []byte
+}
+
+// testFIT returns a FIT file with a file_id message from a Garmin "Edge",
+// and a session message for a ride starting at start.
+func testFIT(start time.Time, elapsed time.Duration, meters float64) []byte {
+	u16 := func(v uint16) []byte { return []byte{byte(v), byte(v >> 8)} }
+	u32 := func(v uint32) []byte {
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, v)
+		return b
+	}
+	var body bytes.Buffer
+	message := func(local byte, global uint16, fields []fitField) {
+		body.WriteByte(fitDefinitionMessage | local)
+		body.Write([]byte{0, 0}) // reserved, little-endian
+		body.Write(u16(global))
+		body.WriteByte(byte(len(fields)))
+		for _, f := range fields {
+			body.Write([]byte{f.num, byte(len(f.value)), f.baseType})
+		}
+		body.WriteByte(local)
+		for _, f := range fields {
+			body.Write(f.value)
+		}
+	}
+	message(0, fitMesgFileID, []fitField{
+		{fitFileIDManufacturer, 0x84, u16(1)},
+		{fitFileIDProductName, fitBaseTypeString, []byte("Edge\x00\x00\x00\x00")},
+	})
+	message(1, fitMesgSession, []fitField{
+		{fitSessionStartTime, 0x86, u32(uint32(start.Sub(fitEpoch) / time.Second))},
+		{fitSessionSport, 0x00, []byte{2}},
+		{fitSessionElapsedTime, 0x86, u32(uint32(elapsed / time.Millisecond))},
+		{fitSessionDistance, 0x86, u32(uint32(meters * 100))},
+	})
+
+	var file bytes.Buffer
+	file.WriteByte(14)
+	file.WriteByte(0x10)
+	file.Write(u16(2100))
+	file.Write(u32(uint32(body.Len())))
+	file.WriteString(".FIT")
+	file.Write(u16(0)) // header CRC; 0 means it isn't set
+	file.Write(body.Bytes())
+	var crc uint16
+	for _, b := range file.Bytes() {
+		crc = fitCRC(crc, b)
+	}
+	file.Write(u16(crc))
+	return file.Bytes()
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestParse(t *testing.T) {
+	fitStart := time.Date(2019, 2, 22, 18, 53, 0, 0, time.UTC)
+	fit := testFIT(fitStart, 90*time.Minute, 40000)
+	tests := []struct {
+		name string
+		data []byte
+		want Summary
+	}{
+		{
+			name: "gpx",
+			data: []byte(testGPX),
+			want: Summary{Format: GPX, Name: "Lunch Ride", Sport: "cycling", Device: "Fake Device", Start: time.Date(2019, 2, 22, 12, 0, 0, 0, time.UTC), Duration: 10 * time.Minute},
+		},
+		{
+			name: "tcx",
+			data: []byte(testTCX),
+			want: Summary{Format: TCX, Sport: "Running", Device: "Fake Watch", Start: time.Date(2019, 2, 22, 7, 30, 0, 0, time.UTC), Duration: 15*time.Minute + 500*time.Millisecond, Distance: 3000},
+		},
+		{
+			name: "fit",
+			data: fit,
+			want: Summary{Format: FIT, Sport: "cycling", Device: "Garmin Edge", Start: fitStart, Duration: 90 * time.Minute, Distance: 40000},
+		},
+		{
+			name: "gzipped fit",
+			data: gzipped(t, fit),
+			want: Summary{Format: FIT, Gzipped: true, Sport: "cycling", Device: "Garmin Edge", Start: fitStart, Duration: 90 * time.Minute, Distance: 40000},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Parse(bytes.NewReader(test.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.want.Format == GPX {
+				// Computed from the coordinates; just check that it's
+				// plausible (0.02 degrees of longitude at the equator).
+				if got.Distance < 2200 || got.Distance > 2250 {
+					t.Errorf("Distance = %v, want about 2224", got.Distance)
+				}
+				got.Distance = 0
+			}
+			if !got.Start.Equal(test.want.Start) {
+				t.Errorf("Start = %v, want %v", got.Start, test.want.Start)
+			}
+			got.Start = test.want.Start
+			if *got != test.want {
+				t.Errorf("got %+v, want %+v", *got, test.want)
+			}
+		})
+	}
+}
+
+func TestFileType(t *testing.T) {
+	s, err := Parse(bytes.NewReader(gzipped(t, []byte(testGPX))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.FileType(), "gpx.gz"; got != want {
+		t.Errorf("FileType() = %q, want %q", got, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	fit := testFIT(time.Date(2019, 2, 22, 18, 53, 0, 0, time.UTC), time.Hour, 1000)
+	badCRC := append([]byte(nil), fit...)
+	badCRC[len(badCRC)-1] ^= 0xff
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"empty", "", "unrecognized file format"},
+		{"text", "hello", "unrecognized file format"},
+		{"other XML", "<kml></kml>", "unrecognized XML file with root element <kml>"},
+		{"gpx without times", `<gpx><trk><trkseg><trkpt lat="0" lon="0"/></trkseg></trk></gpx>`, "no timestamped track points"},
+		{"tcx without activities", `<TrainingCenterDatabase></TrainingCenterDatabase>`, "no activities"},
+		{"truncated fit", string(fit[:len(fit)-10]), "file truncated"},
+		{"fit with bad CRC", string(badCRC), "CRC mismatch"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(test.data))
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got error %v, want one containing %q", err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,315 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package activityfile
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// FIT global message numbers and field numbers used below. See the FIT SDK
+// profile for details.
+const (
+	fitMesgFileID  = 0
+	fitMesgSession = 18
+	fitMesgRecord  = 20
+
+	fitFieldTimestamp = 253
+
+	fitFileIDManufacturer = 1
+	fitFileIDProduct      = 2
+	fitFileIDProductName  = 8
+
+	fitSessionStartTime    = 2
+	fitSessionSport        = 5
+	fitSessionElapsedTime  = 7 // scale 1000, seconds
+	fitSessionDistance     = 9 // scale 100, meters
+	fitRecordDistance      = 5 // scale 100, meters
+	fitBaseTypeString      = 0x07
+	fitCompressedTimestamp = 0x80
+	fitDefinitionMessage   = 0x40
+	fitDeveloperData       = 0x20
+)
+
+// fitEpoch is the zero time for FIT timestamps.
+var fitEpoch = time.Date(1989, 12, 31, 0, 0, 0, 0, time.UTC)
+
+// fitSports maps FIT sport enum values to names.
+var fitSports = map[uint64]string{
+	0: "generic", 1: "running", 2: "cycling", 3: "transition", 4: "fitness_equipment",
+	5: "swimming", 6: "basketball", 7: "soccer", 8: "tennis", 9: "american_football",
+	10: "training", 11: "walking", 12: "cross_country_skiing", 13: "alpine_skiing",
+	14: "snowboarding", 15: "rowing", 16: "mountaineering", 17: "hiking", 18: "multisport",
+	19: "paddling", 20: "flying", 21: "e_biking", 22: "motorcycling", 23: "boating",
+	24: "driving", 25: "golf", 26: "hang_gliding", 27: "horseback_riding", 28: "hunting",
+	29: "fishing", 30: "inline_skating", 31: "rock_climbing", 32: "sailing",
+	33: "ice_skating", 34: "sky_diving", 35: "snowshoeing", 36: "snowmobiling",
+	37: "stand_up_paddleboarding", 38: "surfing", 39: "wakeboarding", 40: "water_skiing",
+	41: "kayaking", 42: "rafting", 43: "windsurfing", 44: "kitesurfing",
+}
+
+// fitManufacturers maps common FIT manufacturer IDs to names.
+var fitManufacturers = map[uint64]string{
+	1: "Garmin", 23: "Suunto", 32: "Wahoo Fitness", 69: "Stages Cycling",
+	89: "Tacx", 255: "Development", 260: "Zwift", 265: "Strava", 289: "Hammerhead",
+	294: "Coros", 309: "Polar",
+}
+
+type fitFieldDef struct {
+	num, size, baseType byte
+}
+
+type fitMessageDef struct {
+	byteOrder binary.ByteOrder
+	global    uint16
+	fields    []fitFieldDef
+	devSize   int // total size of developer fields, which are skipped
+}
+
+// fitMessage holds the fields of interest from a single data message.
+type fitMessage struct {
+	global  uint16
+	uints   map[byte]uint64
+	strings map[byte]string
+}
+
+func parseFIT(data []byte) (*Summary, error) {
+	if len(data) < 12 {
+		return nil, errors.New("file too short")
+	}
+	headerSize := int(data[0])
+	if headerSize != 12 && headerSize != 14 {
+		return nil, fmt.Errorf("invalid header size %d", headerSize)
+	}
+	dataSize := int(binary.LittleEndian.Uint32(data[4:8]))
+	end := headerSize + dataSize
+	if len(data) < end+2 {
+		return nil, fmt.Errorf("file truncated (header says %d bytes, have %d)", end+2, len(data))
+	}
+	var crc uint16
+	for _, b := range data[:end] {
+		crc = fitCRC(crc, b)
+	}
+	if want := binary.LittleEndian.Uint16(data[end : end+2]); crc != want {
+		return nil, fmt.Errorf("CRC mismatch (got %#04x, want %#04x)", crc, want)
+	}
+
+	s := &Summary{}
+	var manufacturer, product string
+	var lastTimestamp uint32
+	var firstRecord, lastRecord time.Time
+	var recordDistance float64
+	var sessionEnd time.Time
+	defs := map[byte]*fitMessageDef{}
+	pos := headerSize
+	for pos < end {
+		header := data[pos]
+		pos++
+		var local byte
+		var timestamp uint32
+		compressed := header&fitCompressedTimestamp != 0
+		switch {
+		case compressed:
+			local = (header >> 5) & 0x3
+			offset := uint32(header & 0x1f)
+			timestamp = lastTimestamp + ((offset - lastTimestamp) & 0x1f)
+			lastTimestamp = timestamp
+		case header&fitDefinitionMessage != 0:
+			def, n, err := parseFITDefinition(data[pos:end], header&fitDeveloperData != 0)
+			if err != nil {
+				return nil, err
+			}
+			defs[header&0xf] = def
+			pos += n
+			continue
+		default:
+			local = header & 0xf
+		}
+		def := defs[local]
+		if def == nil {
+			return nil, fmt.Errorf("data message at offset %d uses undefined local message type %d", pos-1, local)
+		}
+		msg, n, err := parseFITData(data[pos:end], def)
+		if err != nil {
+			return nil, err
+		}
+		pos += n
+		if ts, ok := msg.uints[fitFieldTimestamp]; ok {
+			timestamp = uint32(ts)
+			lastTimestamp = timestamp
+		}
+
+		switch msg.global {
+		case fitMesgFileID:
+			if v, ok := msg.uints[fitFileIDManufacturer]; ok {
+				if manufacturer = fitManufacturers[v]; manufacturer == "" {
+					manufacturer = fmt.Sprintf("manufacturer %d", v)
+				}
+			}
+			if v, ok := msg.uints[fitFileIDProduct]; ok {
+				product = fmt.Sprintf("product %d", v)
+			}
+			if v := msg.strings[fitFileIDProductName]; v != "" {
+				product = v
+			}
+		case fitMesgSession:
+			start, ok := msg.uints[fitSessionStartTime]
+			if !ok {
+				continue
+			}
+			startTime := fitEpoch.Add(time.Duration(start) * time.Second)
+			if s.Start.IsZero() || startTime.Before(s.Start) {
+				s.Start = startTime
+			}
+			elapsed := time.Duration(msg.uints[fitSessionElapsedTime]) * time.Millisecond
+			if e := startTime.Add(elapsed); e.After(sessionEnd) {
+				sessionEnd = e
+			}
+			s.Distance += float64(msg.uints[fitSessionDistance]) / 100
+			if v, ok := msg.uints[fitSessionSport]; ok && s.Sport == "" {
+				s.Sport = fitSports[v]
+			}
+		case fitMesgRecord:
+			if timestamp != 0 {
+				t := fitEpoch.Add(time.Duration(timestamp) * time.Second)
+				if firstRecord.IsZero() {
+					firstRecord = t
+				}
+				lastRecord = t
+			}
+			if v, ok := msg.uints[fitRecordDistance]; ok {
+				recordDistance = float64(v) / 100
+			}
+		}
+	}
+
+	switch {
+	case !s.Start.IsZero():
+		s.Duration = sessionEnd.Sub(s.Start)
+	case !firstRecord.IsZero():
+		// No session message; fall back to the records.
+		s.Start = firstRecord
+		s.Duration = lastRecord.Sub(firstRecord)
+		s.Distance = recordDistance
+	default:
+		return nil, errors.New("no session or record messages")
+	}
+	s.Device = strings.TrimSpace(manufacturer + " " + product)
+	return s, nil
+}
+
+// parseFITDefinition parses a definition message from b (after the record
+// header), returning it and the # of bytes consumed.
+func parseFITDefinition(b []byte, hasDevFields bool) (*fitMessageDef, int, error) {
+	if len(b) < 5 {
+		return nil, 0, errors.New("truncated definition message")
+	}
+	def := &fitMessageDef{byteOrder: binary.LittleEndian}
+	if b[1] == 1 {
+		def.byteOrder = binary.BigEndian
+	}
+	def.global = def.byteOrder.Uint16(b[2:4])
+	numFields := int(b[4])
+	n := 5
+	if len(b) < n+3*numFields {
+		return nil, 0, errors.New("truncated definition message")
+	}
+	for i := 0; i < numFields; i++ {
+		def.fields = append(def.fields, fitFieldDef{num: b[n], size: b[n+1], baseType: b[n+2]})
+		n += 3
+	}
+	if hasDevFields {
+		if len(b) < n+1 {
+			return nil, 0, errors.New("truncated definition message")
+		}
+		numDevFields := int(b[n])
+		n++
+		if len(b) < n+3*numDevFields {
+			return nil, 0, errors.New("truncated definition message")
+		}
+		for i := 0; i < numDevFields; i++ {
+			def.devSize += int(b[n+1])
+			n += 3
+		}
+	}
+	return def, n, nil
+}
+
+// parseFITData parses a data message from b (after the record header)
+// using def, returning it and the # of bytes consumed.
+func parseFITData(b []byte, def *fitMessageDef) (*fitMessage, int, error) {
+	msg := &fitMessage{global: def.global, uints: map[byte]uint64{}, strings: map[byte]string{}}
+	n := 0
+	for _, f := range def.fields {
+		size := int(f.size)
+		if len(b) < n+size {
+			return nil, 0, fmt.Errorf("truncated data message for global message %d", def.global)
+		}
+		v := b[n : n+size]
+		n += size
+		if f.baseType&0x1f == fitBaseTypeString {
+			if i := strings.IndexByte(string(v), 0); i >= 0 {
+				v = v[:i]
+			}
+			msg.strings[f.num] = string(v)
+			continue
+		}
+		var u uint64
+		var invalid uint64
+		switch size {
+		case 1:
+			u, invalid = uint64(v[0]), 0xff
+		case 2:
+			u, invalid = uint64(def.byteOrder.Uint16(v)), 0xffff
+		case 4:
+			u, invalid = uint64(def.byteOrder.Uint32(v)), 0xffffffff
+		default:
+			continue
+		}
+		if u != invalid {
+			msg.uints[f.num] = u
+		}
+	}
+	if len(b) < n+def.devSize {
+		return nil, 0, fmt.Errorf("truncated data message for global message %d", def.global)
+	}
+	return msg, n + def.devSize, nil
+}
+
+var fitCRCTable = [16]uint16{
+	0x0000, 0xCC01, 0xD801, 0x1400, 0xF001, 0x3C00, 0x2800, 0xE401,
+	0xA001, 0x6C00, 0x7800, 0xB401, 0x5000, 0x9C01, 0x8801, 0x4400,
+}
+
+// fitCRC updates crc with b, using the FIT CRC-16 algorithm.
+func fitCRC(crc uint16, b byte) uint16 {
+	tmp := fitCRCTable[crc&0xf]
+	crc = (crc >> 4) & 0x0fff
+	crc = crc ^ tmp ^ fitCRCTable[b&0xf]
+	tmp = fitCRCTable[crc&0xf]
+	crc = (crc >> 4) & 0x0fff
+	return crc ^ tmp ^ fitCRCTable[(b>>4)&0xf]
+}
@@ -0,0 +1,107 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package activityfile
+
+import (
+	"encoding/xml"
+	"errors"
+	"math"
+	"strings"
+	"time"
+)
+
+type gpxFile struct {
+	Creator  string `xml:"creator,attr"`
+	Metadata struct {
+		Name string `xml:"name"`
+	} `xml:"metadata"`
+	Tracks []struct {
+		Name     string `xml:"name"`
+		Type     string `xml:"type"`
+		Segments []struct {
+			Points []gpxPoint `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+}
+
+type gpxPoint struct {
+	Lat  float64 `xml:"lat,attr"`
+	Lon  float64 `xml:"lon,attr"`
+	Time string  `xml:"time"`
+}
+
+func parseGPX(data []byte) (*Summary, error) {
+	var f gpxFile
+	if err := xml.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	s := &Summary{Device: f.Creator}
+	var first, last time.Time
+	for _, trk := range f.Tracks {
+		if name := strings.TrimSpace(trk.Name); name != "" && s.Name == "" {
+			s.Name = name
+		}
+		if s.Sport == "" {
+			s.Sport = strings.TrimSpace(trk.Type)
+		}
+		for _, seg := range trk.Segments {
+			var prev *gpxPoint
+			for i := range seg.Points {
+				p := &seg.Points[i]
+				if p.Time != "" {
+					t, err := time.Parse(time.RFC3339, strings.TrimSpace(p.Time))
+					if err != nil {
+						return nil, err
+					}
+					if first.IsZero() {
+						first = t
+					}
+					last = t
+				}
+				if prev != nil {
+					s.Distance += haversine(prev.Lat, prev.Lon, p.Lat, p.Lon)
+				}
+				prev = p
+			}
+		}
+	}
+	if first.IsZero() {
+		return nil, errors.New("no timestamped track points")
+	}
+	if s.Name == "" {
+		s.Name = strings.TrimSpace(f.Metadata.Name)
+	}
+	s.Start = first
+	s.Duration = last.Sub(first)
+	return s, nil
+}
+
+// haversine returns the distance in meters between two points.
+func haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadius = 6371000 // meters
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := toRad(lat2 - lat1)
+	dLon := toRad(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
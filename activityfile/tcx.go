@@ -0,0 +1,81 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package activityfile
+
+import (
+	"encoding/xml"
+	"errors"
+	"strings"
+	"time"
+)
+
+type tcxFile struct {
+	Activities []struct {
+		Sport string `xml:"Sport,attr"`
+		Laps  []struct {
+			StartTime        string  `xml:"StartTime,attr"`
+			TotalTimeSeconds float64 `xml:"TotalTimeSeconds"`
+			DistanceMeters   float64 `xml:"DistanceMeters"`
+		} `xml:"Lap"`
+		Creator struct {
+			Name string `xml:"Name"`
+		} `xml:"Creator"`
+	} `xml:"Activities>Activity"`
+	Author struct {
+		Name string `xml:"Name"`
+	} `xml:"Author"`
+}
+
+func parseTCX(data []byte) (*Summary, error) {
+	var f tcxFile
+	if err := xml.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	if len(f.Activities) == 0 {
+		return nil, errors.New("no activities")
+	}
+	a := f.Activities[0]
+	if len(a.Laps) == 0 {
+		return nil, errors.New("no laps")
+	}
+	s := &Summary{Sport: a.Sport, Device: strings.TrimSpace(a.Creator.Name)}
+	if s.Device == "" {
+		s.Device = strings.TrimSpace(f.Author.Name)
+	}
+	var end time.Time
+	for _, lap := range a.Laps {
+		start, err := time.Parse(time.RFC3339, strings.TrimSpace(lap.StartTime))
+		if err != nil {
+			return nil, err
+		}
+		if s.Start.IsZero() {
+			s.Start = start
+		}
+		if lapEnd := start.Add(time.Duration(lap.TotalTimeSeconds * float64(time.Second))); lapEnd.After(end) {
+			end = lapEnd
+		}
+		s.Distance += lap.DistanceMeters
+	}
+	s.Duration = end.Sub(s.Start)
+	return s, nil
+}
@@ -0,0 +1,196 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+// Package bulk implements bulk operations on Strava activities: downloading
+// activities for editing, applying the edits, and uploading manual
+// activities and activity files.
+//
+// Activities are read from and written to .csv files with ReadActivities,
+// ReadManualActivities, ReadUploadActivities, and WriteCSV. Bulk operations
+// report the outcome of each row as a RowResult, both as they happen (via
+// a Progress callback) and in the returned slice.
+package bulk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/antihax/optional"
+	"github.com/vangent/strava"
+)
+
+// DefaultBaseURL is the base URL for Strava.
+const DefaultBaseURL = "https://www.strava.com"
+
+// pageSize is the # of activities to list per page.
+const pageSize = 25
+
+// ClientOptions holds options for NewClient.
+type ClientOptions struct {
+	// BaseURL is the base URL for the Strava API and activity links.
+	// Defaults to DefaultBaseURL.
+	BaseURL string
+	// HTTPClient is used for all requests. Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// Client performs bulk operations using the Strava API.
+type Client struct {
+	accessToken string
+	baseURL     string
+	cfg         *strava.Configuration
+	api         *strava.APIClient
+}
+
+// NewClient returns a Client that authenticates with accessToken. opts may
+// be nil.
+func NewClient(accessToken string, opts *ClientOptions) *Client {
+	if opts == nil {
+		opts = &ClientOptions{}
+	}
+	baseURL := strings.TrimSuffix(opts.BaseURL, "/")
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	cfg := strava.NewConfiguration()
+	cfg.BasePath = baseURL + "/api/v3"
+	cfg.HTTPClient = opts.HTTPClient
+	return &Client{
+		accessToken: accessToken,
+		baseURL:     baseURL,
+		cfg:         cfg,
+		api:         strava.NewAPIClient(cfg),
+	}
+}
+
+// API returns the underlying Strava API client. Requests made with it must
+// use a context from Context.
+func (c *Client) API() *strava.APIClient {
+	return c.api
+}
+
+// Context returns a copy of ctx that carries c's access token, for use with
+// API.
+func (c *Client) Context(ctx context.Context) context.Context {
+	return context.WithValue(ctx, strava.ContextAccessToken, c.accessToken)
+}
+
+// ActivityURL returns the web URL for the activity id.
+func (c *Client) ActivityURL(id int64) string {
+	return fmt.Sprintf("%s/activities/%d", c.baseURL, id)
+}
+
+// ListOptions holds options for ListActivities and Download.
+type ListOptions struct {
+	// Before and After limit the activities by start time; zero values mean
+	// no limit.
+	Before, After time.Time
+	// Max is the maximum # of activities; 0 means no limit.
+	Max int
+	// Progress, if not nil, is called with the # of activities so far
+	// before fetching each page after the first.
+	Progress func(n int)
+}
+
+// ListActivities pages through the logged-in athlete's activities, calling
+// f for each one. opts may be nil.
+func (c *Client) ListActivities(ctx context.Context, opts *ListOptions, f func(*strava.SummaryActivity)) error {
+	if opts == nil {
+		opts = &ListOptions{}
+	}
+	ctx = c.Context(ctx)
+	page := int32(1)
+	n := 0
+	for {
+		req := &strava.GetLoggedInAthleteActivitiesOpts{
+			Page:    optional.NewInt32(page),
+			PerPage: optional.NewInt32(pageSize),
+		}
+		if !opts.Before.IsZero() {
+			req.Before = optional.NewInt32(int32(opts.Before.Unix()))
+		}
+		if !opts.After.IsZero() {
+			req.After = optional.NewInt32(int32(opts.After.Unix()))
+		}
+		summaries, _, err := c.api.ActivitiesApi.GetLoggedInAthleteActivities(ctx, req)
+		if err != nil {
+			return fmt.Errorf("failed ListActivities call (page %d, per page %d): %v", page, pageSize, err)
+		}
+		for i := range summaries {
+			f(&summaries[i])
+			n++
+			if opts.Max > 0 && n == opts.Max {
+				return nil
+			}
+		}
+		if len(summaries) < pageSize {
+			return nil
+		}
+		if opts.Progress != nil {
+			opts.Progress(n)
+		}
+		page++
+	}
+}
+
+// SetSportType sets the Sport Type for an existing activity. The client
+// library's UpdatableActivity model doesn't have sport_type, so this makes
+// the request directly.
+func (c *Client) SetSportType(ctx context.Context, id int64, sportType string) error {
+	body, err := json.Marshal(map[string]string{"sport_type": sportType})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("%s/activities/%d", c.cfg.BasePath, id), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.accessToken)
+	resp, err := c.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to set Sport Type: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("failed to set Sport Type: %s %s", resp.Status, string(msg))
+	}
+	return nil
+}
+
+// apiError adds the response body from a failed API call to err.
+func apiError(err error, resp *http.Response) error {
+	var msg string
+	if resp != nil {
+		body, _ := ioutil.ReadAll(resp.Body)
+		msg = string(body)
+	}
+	return fmt.Errorf("%v %s", err, msg)
+}
@@ -0,0 +1,85 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package bulk
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/vangent/strava"
+	"github.com/vangent/stravacli/fakestrava"
+)
+
+// testEnd is the end of the fake athlete's seeded activities.
+var testEnd = time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC)
+
+// newFakeClient returns a fake Strava server seeded with n activities (see
+// fakestrava.Server.Seed), and a Client for it. Call the returned function
+// to shut the server down.
+func newFakeClient(n int) (*fakestrava.Server, *Client, func()) {
+	fake := fakestrava.New()
+	fake.Seed(n, testEnd)
+	ts := httptest.NewServer(fake)
+	return fake, NewClient(fakestrava.AccessToken, &ClientOptions{BaseURL: ts.URL}), ts.Close
+}
+
+func TestListActivities(t *testing.T) {
+	_, client, done := newFakeClient(30)
+	defer done()
+	ctx := context.Background()
+
+	var ids []int64
+	var progress []int
+	opts := &ListOptions{Progress: func(n int) { progress = append(progress, n) }}
+	if err := client.ListActivities(ctx, opts, func(a *strava.SummaryActivity) { ids = append(ids, a.Id) }); err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 30 {
+		t.Errorf("got %d activities, want 30", len(ids))
+	}
+	if len(progress) != 1 || progress[0] != pageSize {
+		t.Errorf("got progress %v, want [%d]", progress, pageSize)
+	}
+
+	// The seeded activities are one per day, so this is the last 5 days.
+	ids = nil
+	opts = &ListOptions{After: testEnd.AddDate(0, 0, -5), Max: 3}
+	if err := client.ListActivities(ctx, opts, func(a *strava.SummaryActivity) { ids = append(ids, a.Id) }); err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 3 {
+		t.Errorf("with Max 3, got %d activities", len(ids))
+	}
+}
+
+func TestBadAccessToken(t *testing.T) {
+	fake := fakestrava.New()
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+	client := NewClient("bad-token", &ClientOptions{BaseURL: ts.URL})
+	if _, err := client.Download(context.Background(), nil); err == nil {
+		t.Error("got nil error, want one for a bad access token")
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package bulk
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Required columns for each kind of .csv; each entry lists alternatives,
+// at least one of which must be present. Other columns are optional.
+var (
+	activityRequiredColumns = [][]string{{"ID"}}
+	manualRequiredColumns   = [][]string{{"Start"}, {"Name"}, {"Activity Type", "Sport Type"}}
+	uploadRequiredColumns   = [][]string{{"Filename"}, {"File Type"}, {"Name"}, {"Activity Type", "Sport Type"}}
+)
+
+// csvColumns returns the columns for rowType, a struct type, from the csv
+// tags on its fields.
+func csvColumns(rowType reflect.Type) []string {
+	var columns []string
+	for i := 0; i < rowType.NumField(); i++ {
+		f := rowType.Field(i)
+		if f.PkgPath != "" {
+			continue // unexported
+		}
+		if tag := f.Tag.Get("csv"); tag != "" && tag != "-" {
+			columns = append(columns, tag)
+		}
+	}
+	return columns
+}
+
+// rowTypeOf returns the struct type T for out, a *[]*T.
+func rowTypeOf(out interface{}) reflect.Type {
+	return reflect.TypeOf(out).Elem().Elem().Elem()
+}
+
+// missingColumns returns an error describing the required columns that
+// aren't in header, or nil if there aren't any.
+func missingColumns(header []string, required [][]string) error {
+	present := map[string]bool{}
+	for _, column := range header {
+		present[column] = true
+	}
+	var missing []string
+	for _, alternatives := range required {
+		found := false
+		for _, column := range alternatives {
+			found = found || present[column]
+		}
+		if !found {
+			missing = append(missing, fmt.Sprintf("%q", alternatives[0]))
+			if len(alternatives) > 1 {
+				missing[len(missing)-1] = "one of " + strings.Join(quoteAll(alternatives), " or ")
+			}
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return fmt.Errorf("missing required columns: %s", strings.Join(missing, ", "))
+}
+
+// quoteAll returns ss, each quoted.
+func quoteAll(ss []string) []string {
+	var quoted []string
+	for _, s := range ss {
+		quoted = append(quoted, fmt.Sprintf("%q", s))
+	}
+	return quoted
+}
+
+// columnProblems checks header, the header row of a .csv of rowType: it's
+// an error if required columns are missing, and a warning if there are
+// unknown or duplicate columns, which are ignored.
+func columnProblems(header []string, rowType reflect.Type, required [][]string) []*Problem {
+	var problems []*Problem
+	if err := missingColumns(header, required); err != nil {
+		problems = append(problems, &Problem{Err: err})
+	}
+	known := map[string]bool{}
+	for _, column := range csvColumns(rowType) {
+		known[column] = true
+	}
+	seen := map[string]bool{}
+	for _, column := range header {
+		switch {
+		case !known[column]:
+			problems = append(problems, &Problem{Column: column, Err: errors.New("unknown column; it will be ignored"), Warning: true})
+		case seen[column]:
+			problems = append(problems, &Problem{Column: column, Err: errors.New("duplicate column; only the first one is used"), Warning: true})
+		}
+		seen[column] = true
+	}
+	return problems
+}
+
+// absentColumns returns the columns of rowType that aren't in header.
+func absentColumns(header []string, rowType reflect.Type) map[string]bool {
+	present := map[string]bool{}
+	for _, column := range header {
+		present[column] = true
+	}
+	absent := map[string]bool{}
+	for _, column := range csvColumns(rowType) {
+		if !present[column] {
+			absent[column] = true
+		}
+	}
+	return absent
+}
+
+// markAbsent records the columns missing from header in out, if it's a
+// *[]*Activity, so that Update leaves them unchanged.
+func markAbsent(out interface{}, header []string) {
+	activities, ok := out.(*[]*Activity)
+	if !ok {
+		return
+	}
+	absent := absentColumns(header, rowTypeOf(out))
+	if len(absent) == 0 {
+		return
+	}
+	for _, a := range *activities {
+		a.absent = absent
+	}
+}
@@ -0,0 +1,78 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package bulk
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io"
+
+	"github.com/gocarina/gocsv"
+)
+
+// ReadActivities reads activities in the format written by Download from r.
+// Only the ID column is required; columns that are missing are left
+// unchanged by Update. d may be nil; see Dialect.
+func ReadActivities(r io.Reader, d *Dialect) ([]*Activity, error) {
+	var activities []*Activity
+	if err := d.unmarshal(r, &activities, true, activityRequiredColumns); err != nil {
+		return nil, err
+	}
+	return activities, nil
+}
+
+// ReadManualActivities reads manual activities to upload from r. d may be
+// nil; see Dialect.
+func ReadManualActivities(r io.Reader, d *Dialect) ([]*ManualActivity, error) {
+	var activities []*ManualActivity
+	if err := d.unmarshal(r, &activities, false, manualRequiredColumns); err != nil {
+		return nil, err
+	}
+	return activities, nil
+}
+
+// ReadUploadActivities reads activity files to upload from r. d may be nil;
+// see Dialect.
+func ReadUploadActivities(r io.Reader, d *Dialect) ([]*UploadActivity, error) {
+	var activities []*UploadActivity
+	if err := d.unmarshal(r, &activities, false, uploadRequiredColumns); err != nil {
+		return nil, err
+	}
+	return activities, nil
+}
+
+// WriteCSV writes rows, a slice of *Activity, *ManualActivity, or
+// *UploadActivity (or of other structs with csv tags), to w as a .csv in
+// dialect d, including the header row. d may be nil; see Dialect.
+func WriteCSV(w io.Writer, rows interface{}, d *Dialect) error {
+	var buf bytes.Buffer
+	if err := gocsv.Marshal(rows, &buf); err != nil {
+		return err
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		return err
+	}
+	_, startUTC := rows.([]*Activity)
+	return d.marshal(w, records, startUTC)
+}
@@ -0,0 +1,355 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package bulk
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gocarina/gocsv"
+)
+
+// Dialect describes how a .csv is formatted, to handle files that were
+// exported from spreadsheet applications. The zero value (or a nil
+// *Dialect) detects the delimiter and decimal separator when reading, and
+// writes standard .csv files.
+//
+// When reading, a UTF-8 byte order mark is ignored, booleans may be spelled
+// true/false, yes/no, y/n, 1/0, or like True/False, in any case, and Start
+// may also be in TimeLayout or a common spreadsheet format like
+// "2/22/2019 18:53" (month first) or "22.02.2019 18:53".
+type Dialect struct {
+	// Comma is the field delimiter. When reading, 0 means detect it from
+	// the header row (',', ';', or tab); when writing, 0 means ','.
+	Comma rune
+	// Decimal is the decimal separator for numbers, like Distance; 0 means
+	// ',' if Comma is ';' (as in European versions of Excel), or '.'
+	// otherwise. The other one of ',' and '.' is only accepted as a
+	// thousands separator between groups of three digits, like "1,000.5";
+	// other uses, like "10,5" with '.', are reported as ambiguous.
+	Decimal rune
+	// True and False are how booleans are written; they default to "true"
+	// and "false".
+	True, False string
+	// TimeLayout, if not empty, is the Go time layout for the Start column
+	// (e.g., "1/2/2006 15:04"); it is tried first when reading, and used
+	// for writing the Start times from Download. Start times read without
+	// a UTC offset are in UTC for update, and in the manual activity's
+	// Location otherwise.
+	TimeLayout string
+	// BOM writes a UTF-8 byte order mark, which Excel needs to recognize
+	// UTF-8.
+	BOM bool
+}
+
+// utf8BOM is the UTF-8 encoding of the byte order mark.
+const utf8BOM = "\ufeff"
+
+// spreadsheetTimeLayouts are the layouts tried for Start after RFC 3339 and
+// Dialect.TimeLayout.
+var spreadsheetTimeLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"1/2/2006 15:04:05",
+	"1/2/2006 15:04",
+	"1/2/2006 3:04:05 PM",
+	"1/2/2006 3:04 PM",
+	"02.01.2006 15:04:05",
+	"02.01.2006 15:04",
+}
+
+// comma returns the field delimiter for writing.
+func (d *Dialect) comma() rune {
+	if d == nil || d.Comma == 0 {
+		return ','
+	}
+	return d.Comma
+}
+
+// decimal returns the decimal separator for a .csv delimited by comma.
+func (d *Dialect) decimal(comma rune) rune {
+	if d != nil && d.Decimal != 0 {
+		return d.Decimal
+	}
+	if comma == ';' {
+		return ','
+	}
+	return '.'
+}
+
+// detectComma returns the field delimiter for a .csv with the header row
+// header: whichever of ',', ';', or tab splits it into the most columns.
+func detectComma(header string) rune {
+	best, bestN := ',', 0
+	for _, c := range []rune{',', ';', '\t'} {
+		if n := strings.Count(header, string(c)); n > bestN {
+			best, bestN = c, n
+		}
+	}
+	return best
+}
+
+// readRecords reads the records of a .csv in dialect d from r, including
+// the header row, normalizing the values in known columns to the standard
+// formats: booleans to "true" or "false", numbers to use '.' as the decimal
+// separator, and Start to RFC 3339 in UTC if startUTC is true, or a local
+// time otherwise. Values that can't be normalized are left alone, except
+// for ambiguous numbers, which are blanked out and returned as problems. If
+// strict is true, all records must have the same number of fields.
+func (d *Dialect) readRecords(r io.Reader, startUTC, strict bool) ([][]string, []*Problem, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	b = bytes.TrimPrefix(b, []byte(utf8BOM))
+	comma := rune(0)
+	if d != nil {
+		comma = d.Comma
+	}
+	if comma == 0 {
+		header := b
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			header = b[:i]
+		}
+		comma = detectComma(string(header))
+	}
+	cr := csv.NewReader(bytes.NewReader(b))
+	cr.Comma = comma
+	if !strict {
+		cr.FieldsPerRecord = -1
+	}
+	records, err := cr.ReadAll()
+	if err != nil || len(records) == 0 {
+		return records, nil, err
+	}
+	decimal := d.decimal(comma)
+	var problems []*Problem
+	for col, name := range records[0] {
+		var normalize func(string) (string, error)
+		switch name {
+		case "Commute?", "Trainer?":
+			normalize = func(s string) (string, error) { return d.normalizeBool(s), nil }
+		case "Distance", "Duration":
+			normalize = func(s string) (string, error) { return normalizeNumber(s, decimal) }
+		case "Start":
+			normalize = func(s string) (string, error) { return d.normalizeStart(s, startUTC), nil }
+		default:
+			continue
+		}
+		for i, record := range records[1:] {
+			if col >= len(record) {
+				continue
+			}
+			v, err := normalize(record[col])
+			if err != nil {
+				problems = append(problems, &Problem{Row: i + 1, Column: name, Err: fmt.Errorf("%v; set --csv_decimal", err)})
+			}
+			record[col] = v
+		}
+	}
+	return records, problems, nil
+}
+
+// Header returns the header row of a .csv in dialect d read from r.
+func (d *Dialect) Header(r io.Reader) ([]string, error) {
+	records, _, err := d.readRecords(r, false, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("empty .csv; it should at least have a header row")
+	}
+	return records[0], nil
+}
+
+// unmarshal reads a .csv in dialect d from r into out, a pointer to a slice
+// of struct pointers; see readRecords. Columns are matched by name, so they
+// may be in any order, and unknown columns are ignored; it fails if any of
+// the required columns are missing (see missingColumns).
+func (d *Dialect) unmarshal(r io.Reader, out interface{}, startUTC bool, required [][]string) error {
+	records, problems, err := d.readRecords(r, startUTC, true)
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return problems[0]
+	}
+	if len(records) > 0 {
+		if err := missingColumns(records[0], required); err != nil {
+			return err
+		}
+	}
+	var buf bytes.Buffer
+	if err := csv.NewWriter(&buf).WriteAll(records); err != nil {
+		return err
+	}
+	if err := gocsv.Unmarshal(&buf, out); err != nil {
+		return err
+	}
+	if len(records) > 0 {
+		markAbsent(out, records[0])
+	}
+	return nil
+}
+
+// normalizeBool returns s as "true" or "false"; it may also be spelled like
+// d.True or d.False.
+func (d *Dialect) normalizeBool(s string) string {
+	v := strings.TrimSpace(s)
+	switch {
+	case d != nil && d.True != "" && strings.EqualFold(v, d.True):
+		return "true"
+	case d != nil && d.False != "" && strings.EqualFold(v, d.False):
+		return "false"
+	}
+	switch strings.ToLower(v) {
+	case "true", "yes", "y", "1":
+		return "true"
+	case "false", "no", "n", "0":
+		return "false"
+	}
+	return s
+}
+
+// numberRun matches a run of digits with separators, like "1,000.5" in
+// "1,000.5km".
+var numberRun = regexp.MustCompile(`[0-9]+(?:[.,][0-9]+)*`)
+
+// thousandsGroups matches the integer part of a number with thousands
+// separators, like "1,000,000".
+var thousandsGroups = regexp.MustCompile(`^[0-9]{1,3}(?:[.,][0-9]{3})+$`)
+
+// normalizeNumber returns s with the numbers in it using '.' as the decimal
+// separator and no thousands separators. decimal is the decimal separator
+// in s, '.' or ','; the other one is only accepted as a thousands
+// separator between groups of three digits, and is an error otherwise.
+func normalizeNumber(s string, decimal rune) (string, error) {
+	dec, thousands := ".", ","
+	if decimal == ',' {
+		dec, thousands = ",", "."
+	}
+	var err error
+	normalized := numberRun.ReplaceAllStringFunc(s, func(run string) string {
+		intPart, frac, hasFrac := run, "", false
+		if i := strings.LastIndex(run, dec); i >= 0 {
+			intPart, frac, hasFrac = run[:i], run[i+1:], true
+		}
+		if strings.Contains(intPart, dec) || strings.Contains(frac, thousands) ||
+			(strings.Contains(intPart, thousands) && !thousandsGroups.MatchString(intPart)) {
+			if err == nil {
+				err = fmt.Errorf("ambiguous number %q (is %q a decimal or a thousands separator?)", s, thousands)
+			}
+			return run
+		}
+		intPart = strings.Replace(intPart, thousands, "", -1)
+		if hasFrac {
+			return intPart + "." + frac
+		}
+		return intPart
+	})
+	if err != nil {
+		return "", err
+	}
+	return normalized, nil
+}
+
+// normalizeStart returns s in RFC 3339 in UTC if utc is true and s doesn't
+// have a UTC offset, or as a local time otherwise.
+func (d *Dialect) normalizeStart(s string, utc bool) string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return s
+	}
+	if _, err := time.Parse(time.RFC3339, s); err == nil {
+		return s
+	}
+	layouts := spreadsheetTimeLayouts
+	if d != nil && d.TimeLayout != "" {
+		layouts = append([]string{d.TimeLayout}, layouts...)
+	}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			if utc {
+				return t.Format(time.RFC3339)
+			}
+			return t.Format("2006-01-02 15:04:05")
+		}
+	}
+	return s
+}
+
+// decimalNumber matches numbers with a fractional part, which are written
+// with Dialect.Decimal.
+var decimalNumber = regexp.MustCompile(`^-?[0-9]+\.[0-9]+$`)
+
+// marshal writes records, including the header row, to w in dialect d.
+// Values are in the standard formats described in readRecords; Start is
+// only written with TimeLayout if startUTC is true, since otherwise it
+// would be read back as a local time.
+func (d *Dialect) marshal(w io.Writer, records [][]string, startUTC bool) error {
+	comma := d.comma()
+	decimal := d.decimal(comma)
+	trueStr, falseStr := "true", "false"
+	if d != nil && d.True != "" {
+		trueStr = d.True
+	}
+	if d != nil && d.False != "" {
+		falseStr = d.False
+	}
+	if len(records) > 0 {
+		for col, name := range records[0] {
+			for _, record := range records[1:] {
+				v := record[col]
+				switch {
+				case strings.HasSuffix(name, "?") && v == "true":
+					record[col] = trueStr
+				case strings.HasSuffix(name, "?") && v == "false":
+					record[col] = falseStr
+				case name == "Start" && startUTC && d != nil && d.TimeLayout != "":
+					if t, err := time.Parse(time.RFC3339, v); err == nil && t.Location() == time.UTC {
+						record[col] = t.Format(d.TimeLayout)
+					}
+				case decimal == ',' && decimalNumber.MatchString(v):
+					record[col] = strings.Replace(v, ".", ",", 1)
+				}
+			}
+		}
+	}
+	if d != nil && d.BOM {
+		if _, err := io.WriteString(w, utf8BOM); err != nil {
+			return err
+		}
+	}
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	return cw.WriteAll(records)
+}
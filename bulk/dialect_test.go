@@ -0,0 +1,175 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package bulk
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizeNumber(t *testing.T) {
+	tests := []struct {
+		s       string
+		decimal rune
+		want    string
+		wantErr bool
+	}{
+		{s: "", decimal: '.', want: ""},
+		{s: "10", decimal: '.', want: "10"},
+		{s: "10.5km", decimal: '.', want: "10.5km"},
+		{s: "1,000", decimal: '.', want: "1000"},
+		{s: "1,234,567.89", decimal: '.', want: "1234567.89"},
+		{s: "1:23:45.5", decimal: '.', want: "1:23:45.5"},
+		{s: "10,5", decimal: '.', wantErr: true},
+		{s: "1,2345", decimal: '.', wantErr: true},
+		{s: "1.5.2", decimal: '.', wantErr: true},
+		{s: "10,5", decimal: ',', want: "10.5"},
+		{s: "10,5 km", decimal: ',', want: "10.5 km"},
+		{s: "1.000", decimal: ',', want: "1000"},
+		{s: "1.000,5", decimal: ',', want: "1000.5"},
+		{s: "1:23:45,5", decimal: ',', want: "1:23:45.5"},
+		{s: "10.5km", decimal: ',', wantErr: true},
+		{s: "1,5,2", decimal: ',', wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := normalizeNumber(test.s, test.decimal)
+		if (err != nil) != test.wantErr {
+			t.Errorf("normalizeNumber(%q, %q) got error %v, want error %v", test.s, test.decimal, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("normalizeNumber(%q, %q) = %q, want %q", test.s, test.decimal, got, test.want)
+		}
+	}
+}
+
+func TestDetectComma(t *testing.T) {
+	tests := []struct {
+		header string
+		want   rune
+	}{
+		{"Start,Name,Activity Type", ','},
+		{"Start;Name;Activity Type", ';'},
+		{"Start\tName\tActivity Type", '\t'},
+		{"Name", ','},
+	}
+	for _, test := range tests {
+		if got := detectComma(test.header); got != test.want {
+			t.Errorf("detectComma(%q) = %q, want %q", test.header, got, test.want)
+		}
+	}
+}
+
+func TestReadManualActivitiesDialects(t *testing.T) {
+	want := []*ManualActivity{
+		{Start: "2019-02-22 18:53:00", ActivityType: "Run", Name: "Evening Run", Distance: "10.5", Commute: true},
+		{Start: "2019-02-23 07:30:00", ActivityType: "Ride", Name: "Morning Ride", Distance: "1000.5km", Trainer: true},
+	}
+	tests := []struct {
+		name    string
+		dialect *Dialect
+		csv     string
+	}{
+		{
+			name: "standard",
+			csv: "Start,Activity Type,Name,Distance,Commute?,Trainer?\n" +
+				"2019-02-22 18:53,Run,Evening Run,10.5,true,false\n" +
+				"2019-02-23 07:30,Ride,Morning Ride,\"1,000.5km\",false,true\n",
+		},
+		{
+			name: "European Excel",
+			csv: utf8BOM + "Start;Activity Type;Name;Distance;Commute?;Trainer?\n" +
+				"22.02.2019 18:53;Run;Evening Run;10,5;Ja;Nein\n" +
+				"23.02.2019 07:30;Ride;Morning Ride;1.000,5km;nein;ja\n",
+			dialect: &Dialect{True: "Ja", False: "Nein"},
+		},
+		{
+			name: "US spreadsheet",
+			csv: "Start\tActivity Type\tName\tDistance\tCommute?\tTrainer?\n" +
+				"2/22/2019 6:53 PM\tRun\tEvening Run\t10.5\tyes\tno\n" +
+				"2/23/2019 7:30 AM\tRide\tMorning Ride\t1000.5km\tN\tY\n",
+		},
+		{
+			name: "custom time layout",
+			csv: "Start,Activity Type,Name,Distance,Commute?,Trainer?\n" +
+				"22 Feb 2019 18:53,Run,Evening Run,10.5,1,0\n" +
+				"23 Feb 2019 07:30,Ride,Morning Ride,1000.5km,0,1\n",
+			dialect: &Dialect{TimeLayout: "2 Jan 2006 15:04"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ReadManualActivities(strings.NewReader(test.csv), test.dialect)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestReadAmbiguousNumber(t *testing.T) {
+	csv := "Start,Activity Type,Name,Distance\n2019-02-22 18:53,Run,Evening Run,\"10,5\"\n"
+	_, err := ReadManualActivities(strings.NewReader(csv), nil)
+	if err == nil || !strings.Contains(err.Error(), "ambiguous number") {
+		t.Errorf("got error %v, want an ambiguous number error", err)
+	}
+	// It's fine once the decimal separator is set.
+	got, err := ReadManualActivities(strings.NewReader(csv), &Dialect{Decimal: ','})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0].Distance != "10.5" {
+		t.Errorf("got Distance %q, want %q", got[0].Distance, "10.5")
+	}
+}
+
+func TestWriteCSVDialect(t *testing.T) {
+	activities := []*Activity{
+		{ID: 1, Start: time.Date(2019, 2, 22, 18, 53, 46, 0, time.UTC), ActivityType: "Run", Name: "Evening Run", Commute: true},
+	}
+	d := &Dialect{Comma: ';', True: "Ja", False: "Nein", TimeLayout: "02.01.2006 15:04:05", BOM: true}
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, activities, d); err != nil {
+		t.Fatal(err)
+	}
+	const want = utf8BOM + "ID;Start;Activity Type;Sport Type;Name;Workout Type;Gear ID;Commute?;Trainer?\n" +
+		"1;22.02.2019 18:53:46;Run;;Evening Run;;;Ja;Nein\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	// It reads back the same.
+	got, err := ReadActivities(&buf, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, activities) {
+		t.Errorf("read back %+v, want %+v", got[0], activities[0])
+	}
+}
@@ -0,0 +1,122 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package bulk
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vangent/strava"
+)
+
+const (
+	// Allowed differences between an activity file and an existing activity
+	// for them to be considered duplicates.
+	duplicateStartSlop    = 5 * time.Minute
+	duplicateDurationSlop = 0.1 // as a fraction of the duration
+	duplicateMinSlop      = time.Minute
+)
+
+// OnDuplicate is what Upload does with activity files that look like the
+// same activity as an existing one.
+type OnDuplicate string
+
+const (
+	// OnDuplicateSkip skips them.
+	OnDuplicateSkip OnDuplicate = "skip"
+	// OnDuplicateFail stops with an error.
+	OnDuplicateFail OnDuplicate = "fail"
+	// OnDuplicateUpload uploads them anyway.
+	OnDuplicateUpload OnDuplicate = "upload"
+)
+
+// findDuplicates compares the start time and duration of each activity's
+// file against the athlete's existing activities, and returns the IDs of the
+// matching existing activities for each activity that has any. Activities
+// whose files can't be parsed are ignored.
+func (c *Client) findDuplicates(ctx context.Context, activities []*UploadActivity) (map[*UploadActivity][]int64, error) {
+	var first, last time.Time
+	for _, a := range activities {
+		s, err := a.Summarize()
+		if err != nil {
+			continue
+		}
+		if first.IsZero() || s.Start.Before(first) {
+			first = s.Start
+		}
+		if end := s.Start.Add(s.Duration); end.After(last) {
+			last = end
+		}
+	}
+	dups := map[*UploadActivity][]int64{}
+	if first.IsZero() {
+		return dups, nil
+	}
+
+	var existing []strava.SummaryActivity
+	opts := &ListOptions{Before: last.Add(duplicateStartSlop), After: first.Add(-duplicateStartSlop)}
+	err := c.ListActivities(ctx, opts, func(sa *strava.SummaryActivity) {
+		existing = append(existing, *sa)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list existing activities: %v", err)
+	}
+	for _, a := range activities {
+		s, err := a.Summarize()
+		if err != nil {
+			continue
+		}
+		for _, e := range existing {
+			if isDuplicate(s.Start, s.Duration, e.StartDate, time.Duration(e.ElapsedTime)*time.Second) {
+				dups[a] = append(dups[a], e.Id)
+			}
+		}
+	}
+	return dups, nil
+}
+
+// isDuplicate returns true if an activity starting at start and lasting
+// duration looks like the same activity as one starting at otherStart
+// and lasting otherDuration.
+func isDuplicate(start time.Time, duration time.Duration, otherStart time.Time, otherDuration time.Duration) bool {
+	if d := start.Sub(otherStart); d > duplicateStartSlop || d < -duplicateStartSlop {
+		return false
+	}
+	slop := time.Duration(float64(duration) * duplicateDurationSlop)
+	if slop < duplicateMinSlop {
+		slop = duplicateMinSlop
+	}
+	d := duration - otherDuration
+	return d <= slop && d >= -slop
+}
+
+// activityURLs returns the web URLs for ids.
+func (c *Client) activityURLs(ids []int64) string {
+	var urls []string
+	for _, id := range ids {
+		urls = append(urls, c.ActivityURL(id))
+	}
+	return strings.Join(urls, ", ")
+}
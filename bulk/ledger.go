@@ -0,0 +1,146 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package bulk
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// LedgerEntry records the upload of a single activity file.
+type LedgerEntry struct {
+	Hash       string `json:"hash"`
+	Filename   string `json:"filename"`
+	ExternalID string `json:"external_id,omitempty"`
+	UploadID   int64  `json:"upload_id,omitempty"`
+	ActivityID int64  `json:"activity_id,omitempty"`
+	// SportType is a Sport Type to set on the activity once Strava has
+	// processed the upload, since uploads can only set the Activity Type.
+	// It's cleared once it has been set.
+	SportType string    `json:"sport_type,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Updated   time.Time `json:"updated"`
+}
+
+// Ledger is a local record of uploaded activity files, keyed by a hash of
+// their contents (see UploadActivity.ContentHash), so that uploads can be
+// safely re-run.
+type Ledger struct {
+	path    string
+	Entries map[string]*LedgerEntry `json:"entries"`
+}
+
+// LoadLedger reads the ledger at path. A missing file results in an empty
+// ledger. If path is empty, the ledger is not persisted.
+func LoadLedger(path string) (*Ledger, error) {
+	l := &Ledger{path: path, Entries: map[string]*LedgerEntry{}}
+	if path == "" {
+		return l, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ledger %q: %v", path, err)
+	}
+	if err := json.Unmarshal(b, l); err != nil {
+		return nil, fmt.Errorf("failed to parse ledger %q: %v", path, err)
+	}
+	if l.Entries == nil {
+		l.Entries = map[string]*LedgerEntry{}
+	}
+	return l, nil
+}
+
+// Uploaded returns the entry for hash if it was successfully uploaded,
+// or nil.
+func (l *Ledger) Uploaded(hash string) *LedgerEntry {
+	if e := l.Entries[hash]; e != nil && e.ActivityID != 0 {
+		return e
+	}
+	return nil
+}
+
+// Record adds or replaces the entry for e.Hash, and saves the ledger.
+func (l *Ledger) Record(e *LedgerEntry) error {
+	e.Updated = time.Now().UTC()
+	l.Entries[e.Hash] = e
+	return l.save()
+}
+
+// save writes the ledger to disk. It writes to a temporary file first so
+// that an interrupted save doesn't corrupt the ledger.
+func (l *Ledger) save() error {
+	if l.path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return fmt.Errorf("failed to save ledger: %v", err)
+	}
+	tmp := l.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return fmt.Errorf("failed to save ledger: %v", err)
+	}
+	if err := os.Rename(tmp, l.path); err != nil {
+		return fmt.Errorf("failed to save ledger: %v", err)
+	}
+	return nil
+}
+
+// IsPending returns true if e was submitted to Strava, but hasn't finished
+// processing (as far as we know), or its Sport Type hasn't been set yet.
+func (e *LedgerEntry) IsPending() bool {
+	return e.UploadID != 0 && e.Error == "" && (e.ActivityID == 0 || e.SportType != "")
+}
+
+// Pending returns the entries that are still pending, sorted by upload ID.
+func (l *Ledger) Pending() []*LedgerEntry {
+	var entries []*LedgerEntry
+	for _, e := range l.Entries {
+		if e.IsPending() {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].UploadID < entries[j].UploadID })
+	return entries
+}
+
+// ByUploadID returns the entry with the given upload ID, or nil.
+func (l *Ledger) ByUploadID(id int64) *LedgerEntry {
+	for _, e := range l.Entries {
+		if e.UploadID == id {
+			return e
+		}
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package bulk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/antihax/optional"
+	"github.com/vangent/strava"
+)
+
+// ManualActivity is a manual activity (one without an activity file) to
+// upload.
+type ManualActivity struct {
+	Start        ManualStart    `csv:"Start"`
+	ActivityType string         `csv:"Activity Type"`
+	SportType    string         `csv:"Sport Type"`
+	Name         string         `csv:"Name"`
+	Description  string         `csv:"Description"`
+	WorkoutType  WorkoutType    `csv:"Workout Type"`
+	GearID       string         `csv:"Gear ID"`
+	Duration     ManualDuration `csv:"Duration"`
+	Distance     ManualDistance `csv:"Distance"`
+	Commute      bool           `csv:"Commute?"`
+	Trainer      bool           `csv:"Trainer?"`
+
+	// Location is the time zone for Start times without a UTC offset; nil
+	// means the local time zone.
+	Location *time.Location `csv:"-"`
+}
+
+// ManualStart is the Start column. It may be an RFC 3339 time, or a local
+// date-time like "2019-02-22 18:53" with an optional UTC offset; use Value to
+// interpret it.
+type ManualStart string
+
+// Value returns s as a time; s is in loc if it doesn't have a UTC offset.
+func (s ManualStart) Value(loc *time.Location) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := ParseLocalTime(string(s), loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid Start: %v", err)
+	}
+	return t, nil
+}
+
+// ManualDuration is the Duration column. It may be a number of seconds, or
+// a duration like "1:23:45" or "45m".
+type ManualDuration string
+
+// Value returns d as a duration.
+func (d ManualDuration) Value() (time.Duration, error) {
+	v, err := ParseDuration(string(d), "s")
+	if err != nil {
+		return 0, fmt.Errorf("invalid Duration: %v", err)
+	}
+	if v < 0 {
+		return 0, fmt.Errorf("invalid Duration %q: must not be negative", string(d))
+	}
+	return v, nil
+}
+
+// ManualDistance is the Distance column. It may be a number of meters, or a
+// distance like "10km", "6.2mi", or "400yd".
+type ManualDistance string
+
+// Value returns d in meters.
+func (d ManualDistance) Value() (float64, error) {
+	v, err := ParseDistance(string(d), "m")
+	if err != nil {
+		return 0, fmt.Errorf("invalid Distance: %v", err)
+	}
+	if v < 0 {
+		return 0, fmt.Errorf("invalid Distance %q: must not be negative", string(d))
+	}
+	return v, nil
+}
+
+func (a *ManualActivity) String() string {
+	day := string(a.Start)
+	if start, err := a.Start.Value(a.Location); err == nil && !start.IsZero() {
+		day = start.In(a.location()).Format(dayFormat)
+	}
+	return fmt.Sprintf("[%s on %s]", a.Name, day)
+}
+
+// location returns the time zone for a's Start.
+func (a *ManualActivity) location() *time.Location {
+	if a.Location == nil {
+		return time.Local
+	}
+	return a.Location
+}
+
+// Details returns a description of a's normalized values.
+func (a *ManualActivity) Details() string {
+	start, _ := a.Start.Value(a.Location)
+	duration, _ := a.Duration.Value()
+	distance, _ := a.Distance.Value()
+	details := []string{
+		EffectiveActivityType(a.ActivityType, a.SportType),
+		start.In(a.location()).Format("2006-01-02 15:04 MST"),
+		duration.String(),
+		fmt.Sprintf("%.2f km", distance/1000),
+	}
+	return " (" + strings.Join(details, ", ") + ")"
+}
+
+// Verify checks to see that a looks like it can be uploaded. It also
+// normalizes Start to RFC 3339 in UTC, Duration to seconds, and Distance to
+// meters.
+func (a *ManualActivity) Verify() error {
+	if err := firstError(a.check(validateDefaults(nil))); err != nil {
+		return err
+	}
+	start, _ := a.Start.Value(a.Location)
+	duration, _ := a.Duration.Value()
+	distance, _ := a.Distance.Value()
+	a.Start = ManualStart(start.UTC().Format(time.RFC3339))
+	a.Duration = ManualDuration(strconv.Itoa(int(duration / time.Second)))
+	a.Distance = ManualDistance(strconv.FormatFloat(distance, 'f', -1, 32))
+	return nil
+}
+
+// check returns the problems with a.
+func (a *ManualActivity) check(opts *ValidateOptions) []*Problem {
+	var problems []*Problem
+	if start, err := a.Start.Value(a.Location); err != nil {
+		problems = append(problems, &Problem{Column: "Start", Err: err})
+	} else if start.IsZero() {
+		problems = append(problems, &Problem{Column: "Start", Err: errors.New("missing Start")})
+	} else if start.After(opts.Now) {
+		problems = append(problems, &Problem{Column: "Start", Err: fmt.Errorf("Start %s is in the future", start.In(a.location()).Format("2006-01-02 15:04 MST")), Warning: true})
+	}
+	problems = append(problems, typeProblems(a.ActivityType, a.SportType, a.WorkoutType)...)
+	if a.Name == "" {
+		problems = append(problems, &Problem{Column: "Name", Err: errors.New("missing Name")})
+	}
+	problems = append(problems, gearProblems(a.GearID, opts)...)
+	if duration, err := a.Duration.Value(); err != nil {
+		problems = append(problems, &Problem{Column: "Duration", Err: err})
+	} else if duration == 0 {
+		problems = append(problems, &Problem{Column: "Duration", Err: errors.New("Duration is zero"), Warning: true})
+	}
+	if _, err := a.Distance.Value(); err != nil {
+		problems = append(problems, &Problem{Column: "Distance", Err: err})
+	}
+	return problems
+}
+
+// UploadManualOptions holds options for UploadManual.
+type UploadManualOptions struct {
+	// StartRow skips rows before it; row 0 is the header row.
+	StartRow int
+	// DryRun verifies the rows without uploading anything.
+	DryRun bool
+	// Progress, if not nil, is called with StatusStarted before each row is
+	// sent to Strava, and again with the outcome of each row.
+	Progress func(*RowResult)
+}
+
+// UploadManual creates a Strava activity for each of activities. If a row
+// fails, UploadManual stops and returns a *RowError; its Err is a
+// *ValidationError if the row failed verification. If ctx is canceled
+// between rows, UploadManual stops and returns a *RowError for the next row
+// with Err set to ctx.Err(). opts may be nil.
+func (c *Client) UploadManual(ctx context.Context, activities []*ManualActivity, opts *UploadManualOptions) ([]*RowResult, error) {
+	if opts == nil {
+		opts = &UploadManualOptions{}
+	}
+	ctx = c.Context(ctx)
+	var results []*RowResult
+	for i, a := range activities {
+		row := i + 1 // row 0 is the header row
+		if row < opts.StartRow {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return results, &RowError{row, a, ActionUpload, err}
+		}
+		r := &RowResult{Row: row, Activity: a, Action: ActionUpload}
+		if err := c.uploadManualOne(ctx, a, opts, r); err != nil {
+			r.Status, r.Err = failedStatus(ctx), err
+			progress(opts.Progress, r)
+			return append(results, r), &RowError{row, a, ActionUpload, err}
+		}
+		progress(opts.Progress, r)
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// uploadManualOne uploads a, filling in r.
+func (c *Client) uploadManualOne(ctx context.Context, a *ManualActivity, opts *UploadManualOptions, r *RowResult) error {
+	if err := a.Verify(); err != nil {
+		return &ValidationError{err}
+	}
+	r.Details = a.Details()
+	if opts.DryRun {
+		r.Status = StatusDryRun
+		return nil
+	}
+	r.Status = StatusStarted
+	progress(opts.Progress, r)
+	activityType := EffectiveActivityType(a.ActivityType, a.SportType)
+	// These were checked by Verify.
+	start, _ := a.Start.Value(a.Location)
+	duration, _ := a.Duration.Value()
+	distance, _ := a.Distance.Value()
+	createOpts := strava.CreateActivityOpts{}
+	if a.Description != "" {
+		createOpts.Description = optional.NewString(a.Description)
+	}
+	if distance != 0 {
+		createOpts.Distance = optional.NewFloat32(float32(distance))
+	}
+	if a.Trainer {
+		createOpts.Trainer = optional.NewInt32(1)
+	}
+	if a.Commute {
+		createOpts.Commute = optional.NewInt32(1)
+	}
+	if wt, _ := a.WorkoutType.Value(activityType); wt != 0 {
+		createOpts.WorkoutType = optional.NewInt32(int32(wt))
+	}
+	if a.GearID != "" {
+		createOpts.GearId = optional.NewString(a.GearID)
+	}
+	detailedActivity, resp, err := c.api.ActivitiesApi.CreateActivity(ctx, a.Name, activityType, start, int32(duration/time.Second), &createOpts)
+	if err != nil {
+		return apiError(err, resp)
+	}
+	if a.SportType != "" && a.SportType != activityType && detailedActivity.Id != 0 {
+		if err := c.SetSportType(ctx, detailedActivity.Id, a.SportType); err != nil {
+			return err
+		}
+	}
+	r.Status = StatusDone
+	if detailedActivity.Id != 0 {
+		r.ActivityID = detailedActivity.Id
+		r.URL = c.ActivityURL(detailedActivity.Id)
+	}
+	return nil
+}
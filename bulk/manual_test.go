@@ -0,0 +1,110 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package bulk
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUploadManual(t *testing.T) {
+	fake, client, done := newFakeClient(0)
+	defer done()
+	ctx := context.Background()
+
+	const csv = `Start,Activity Type,Sport Type,Name,Duration,Distance,Gear ID,Commute?
+2019-02-22 18:53,Run,,Treadmill,45:00,10km,g1,false
+2019-02-23 08:00,,MountainBikeRide,Trails,1h30m,20.5 mi,b1,true
+`
+	activities, err := ReadManualActivities(strings.NewReader(csv), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range activities {
+		a.Location = time.UTC
+	}
+	results, err := client.UploadManual(ctx, activities, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		name, activityType, sportType string
+		start                         time.Time
+		elapsed                       int32
+		distance                      float32
+		commute                       bool
+	}{
+		{"Treadmill", "Run", "Run", time.Date(2019, 2, 22, 18, 53, 0, 0, time.UTC), 2700, 10000, false},
+		{"Trails", "Ride", "MountainBikeRide", time.Date(2019, 2, 23, 8, 0, 0, 0, time.UTC), 5400, 32991.552, true},
+	}
+	for i, r := range results {
+		if r.Status != StatusDone {
+			t.Errorf("row %d: got status %q, want done", r.Row, r.Status)
+			continue
+		}
+		a, sportType, ok := fake.Activity(r.ActivityID)
+		if !ok {
+			t.Errorf("row %d: activity %d not found", r.Row, r.ActivityID)
+			continue
+		}
+		w := want[i]
+		if a.Name != w.name || string(*a.Type_) != w.activityType || sportType != w.sportType {
+			t.Errorf("row %d: got %q (%s, %s), want %q (%s, %s)", r.Row, a.Name, *a.Type_, sportType, w.name, w.activityType, w.sportType)
+		}
+		if !a.StartDate.Equal(w.start) || a.ElapsedTime != w.elapsed || a.Distance != w.distance || a.Commute != w.commute {
+			t.Errorf("row %d: got start %v, elapsed %d, distance %v, commute %v; want %v, %d, %v, %v", r.Row, a.StartDate, a.ElapsedTime, a.Distance, a.Commute, w.start, w.elapsed, w.distance, w.commute)
+		}
+	}
+}
+
+func TestUploadManualStopsAtBadRow(t *testing.T) {
+	fake, client, done := newFakeClient(0)
+	defer done()
+
+	const csv = `Start,Activity Type,Name,Duration
+2019-02-22T18:53:00Z,Run,Good,45:00
+2019-02-23T08:00:00Z,Ride,Bad,forever
+2019-02-24T08:00:00Z,Ride,Never,1h
+`
+	activities, err := ReadManualActivities(strings.NewReader(csv), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := client.UploadManual(context.Background(), activities, nil)
+	rowErr, ok := err.(*RowError)
+	if !ok {
+		t.Fatalf("got error %v, want a *RowError", err)
+	}
+	if _, ok := rowErr.Err.(*ValidationError); !ok || rowErr.Row != 2 {
+		t.Errorf("got %T for row %d, want a *ValidationError for row 2", rowErr.Err, rowErr.Row)
+	}
+	if len(results) != 2 || results[0].Status != StatusDone || results[1].Status != StatusFailed {
+		t.Errorf("got results %+v, want row 1 done and row 2 failed", results)
+	}
+	if n := len(fake.Activities()); n != 1 {
+		t.Errorf("got %d activities, want 1", n)
+	}
+}
@@ -0,0 +1,137 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package bulk
+
+import (
+	"context"
+	"fmt"
+)
+
+// Action is what a bulk operation does with a row.
+type Action string
+
+const (
+	ActionUpdate Action = "update"
+	ActionUpload Action = "upload"
+)
+
+// Status is the state of a row in a bulk operation.
+type Status string
+
+const (
+	// StatusStarted means that the row is about to be sent to Strava.
+	StatusStarted Status = "started"
+	// StatusDryRun means that the row would have been sent to Strava, but
+	// this is a dry run.
+	StatusDryRun Status = "dryrun"
+	// StatusSubmitted means that an activity file was uploaded, but Strava
+	// may still be processing it.
+	StatusSubmitted Status = "submitted"
+	// StatusDone means that Strava accepted the row.
+	StatusDone Status = "done"
+	// StatusUnchanged means that the row had no changes, so it was skipped.
+	StatusUnchanged Status = "unchanged"
+	// StatusSkipped means that the row was skipped for another reason,
+	// like already having been uploaded; see Details.
+	StatusSkipped Status = "skipped"
+	// StatusCanceled means that the context was canceled while the row was
+	// being processed, so the change may or may not have been made.
+	StatusCanceled Status = "canceled"
+	// StatusFailed means that the row couldn't be processed; see Err.
+	StatusFailed Status = "failed"
+)
+
+// RowResult describes what happened to a single input row.
+type RowResult struct {
+	// Row is the row in the input; row 0 is the header row.
+	Row      int
+	Activity fmt.Stringer
+	Action   Action
+	Status   Status
+	// Details is a human-readable description of the activity data, if
+	// available.
+	Details string
+	// ActivityID and URL are set for StatusDone when the Strava activity
+	// is known.
+	ActivityID int64
+	URL        string
+	// UploadID is set for file uploads that were submitted.
+	UploadID int64
+	// Duplicates has the IDs of existing activities that look like the same
+	// activity as an uploaded file.
+	Duplicates []int64
+	// Err is set for StatusFailed and StatusCanceled, and for an upload with
+	// StatusDone if its Sport Type couldn't be set.
+	Err error
+}
+
+// RowError is returned by bulk operations that stop because of a problem
+// with a row.
+type RowError struct {
+	// Row is the row in the input; row 0 is the header row.
+	Row      int
+	Activity fmt.Stringer
+	Action   Action
+	Err      error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("failed to %s activity %v: %v", e.Action, e.Activity, e.Err)
+}
+
+// ValidationError is returned when an activity fails verification; nothing
+// was sent to Strava for it.
+type ValidationError struct {
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	return e.Err.Error()
+}
+
+// UploadError is returned when Strava reports that it couldn't process an
+// uploaded file; e.g., because the file is corrupt, or it's a duplicate of
+// an existing activity.
+type UploadError struct {
+	Msg string
+}
+
+func (e *UploadError) Error() string {
+	return e.Msg
+}
+
+// failedStatus returns the status for a row that failed: StatusCanceled if
+// ctx was canceled, StatusFailed otherwise.
+func failedStatus(ctx context.Context) Status {
+	if ctx.Err() != nil {
+		return StatusCanceled
+	}
+	return StatusFailed
+}
+
+// progress calls f with r if f isn't nil.
+func progress(f func(*RowResult), r *RowResult) {
+	if f != nil {
+		f(r)
+	}
+}
@@ -0,0 +1,233 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package bulk
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/vangent/strava"
+)
+
+// activityTypes lists the ActivityTypes in the client library's model. It
+// is maintained by hand: using the model's constants means that this won't
+// build if the model drops a type, but types added to the model must be
+// added here too.
+var activityTypes = []strava.ActivityType{
+	strava.ALPINE_SKI_ActivityType,
+	strava.BACKCOUNTRY_SKI_ActivityType,
+	strava.CANOEING_ActivityType,
+	strava.CROSSFIT_ActivityType,
+	strava.E_BIKE_RIDE_ActivityType,
+	strava.ELLIPTICAL_ActivityType,
+	strava.GOLF_ActivityType,
+	strava.HANDCYCLE_ActivityType,
+	strava.HIKE_ActivityType,
+	strava.ICE_SKATE_ActivityType,
+	strava.INLINE_SKATE_ActivityType,
+	strava.KAYAKING_ActivityType,
+	strava.KITESURF_ActivityType,
+	strava.NORDIC_SKI_ActivityType,
+	strava.RIDE_ActivityType,
+	strava.ROCK_CLIMBING_ActivityType,
+	strava.ROLLER_SKI_ActivityType,
+	strava.ROWING_ActivityType,
+	strava.RUN_ActivityType,
+	strava.SAIL_ActivityType,
+	strava.SKATEBOARD_ActivityType,
+	strava.SNOWBOARD_ActivityType,
+	strava.SNOWSHOE_ActivityType,
+	strava.SOCCER_ActivityType,
+	strava.STAIR_STEPPER_ActivityType,
+	strava.STAND_UP_PADDLING_ActivityType,
+	strava.SURFING_ActivityType,
+	strava.SWIM_ActivityType,
+	strava.VELOMOBILE_ActivityType,
+	strava.VIRTUAL_RIDE_ActivityType,
+	strava.VIRTUAL_RUN_ActivityType,
+	strava.WALK_ActivityType,
+	strava.WEIGHT_TRAINING_ActivityType,
+	strava.WHEELCHAIR_ActivityType,
+	strava.WINDSURF_ActivityType,
+	strava.WORKOUT_ActivityType,
+	strava.YOGA_ActivityType,
+}
+
+// validActivityType is the set of valid Activity Types, derived from
+// activityTypes.
+var validActivityType = map[string]bool{}
+
+// newSportTypes lists the Sport Types that Strava added after the
+// ActivityType model was frozen, mapped to the closest Activity Type. It is
+// also maintained by hand, from Strava's API documentation.
+var newSportTypes = map[string]string{
+	"Badminton":                     "Workout",
+	"EMountainBikeRide":             "EBikeRide",
+	"GravelRide":                    "Ride",
+	"HighIntensityIntervalTraining": "Workout",
+	"MountainBikeRide":              "Ride",
+	"Pickleball":                    "Workout",
+	"Pilates":                       "Workout",
+	"Racquetball":                   "Workout",
+	"Squash":                        "Workout",
+	"TableTennis":                   "Workout",
+	"Tennis":                        "Workout",
+	"TrailRun":                      "Run",
+	"VirtualRow":                    "Rowing",
+}
+
+// sportTypes maps each valid Sport Type to its Activity Type. Every Activity
+// Type is also a Sport Type, mapping to itself.
+var sportTypes = map[string]string{}
+
+func init() {
+	for _, t := range activityTypes {
+		validActivityType[string(t)] = true
+		sportTypes[string(t)] = string(t)
+	}
+	for sportType, activityType := range newSportTypes {
+		if !validActivityType[activityType] {
+			panic(fmt.Sprintf("Sport Type %q maps to unknown Activity Type %q", sportType, activityType))
+		}
+		sportTypes[sportType] = activityType
+	}
+}
+
+// IsActivityType returns true if t is a valid Activity Type.
+func IsActivityType(t string) bool {
+	return validActivityType[t]
+}
+
+// SportTypes returns a map from each valid Sport Type to its Activity Type.
+// Every Activity Type is also a Sport Type, mapping to itself.
+func SportTypes() map[string]string {
+	m := make(map[string]string, len(sportTypes))
+	for sportType, activityType := range sportTypes {
+		m[sportType] = activityType
+	}
+	return m
+}
+
+// EffectiveActivityType returns activityType, or the Activity Type for
+// sportType if activityType is empty.
+func EffectiveActivityType(activityType, sportType string) string {
+	if activityType == "" {
+		return sportTypes[sportType]
+	}
+	return activityType
+}
+
+// ParseActivityTypeName maps a human-readable activity type, like "Weight
+// Training" or "Mountain Bike Ride", to an Activity Type or Sport Type. If
+// it can't be mapped, both are empty.
+func ParseActivityTypeName(s string) (activityType, sportType string) {
+	key := strings.NewReplacer(" ", "", "-", "", "_", "").Replace(s)
+	for t := range sportTypes {
+		if !strings.EqualFold(t, key) {
+			continue
+		}
+		if validActivityType[t] {
+			return t, ""
+		}
+		return "", t
+	}
+	return "", ""
+}
+
+// VerifyActivityType checks that activityType and sportType are valid, and
+// that they're consistent with each other. Either may be empty, but not both.
+func VerifyActivityType(activityType, sportType string) error {
+	if activityType == "" && sportType == "" {
+		return errors.New("missing Activity Type")
+	}
+	if activityType != "" && !validActivityType[activityType] {
+		return fmt.Errorf("invalid Activity Type %q; see \"stravacli types\"", activityType)
+	}
+	if sportType == "" {
+		return nil
+	}
+	sportActivityType, ok := sportTypes[sportType]
+	if !ok {
+		return fmt.Errorf("invalid Sport Type %q; see \"stravacli types\"", sportType)
+	}
+	if activityType != "" && activityType != sportActivityType {
+		return fmt.Errorf("Sport Type %q is not valid for Activity Type %q (should be %q)", sportType, activityType, sportActivityType)
+	}
+	return nil
+}
+
+// SportToActivityType maps a sport name as recorded by a device (e.g.,
+// "running" or "Biking") to a Strava Activity Type, or returns "" if
+// there's no good match.
+func SportToActivityType(sport string) string {
+	s := strings.ToLower(strings.Replace(strings.Replace(sport, "_", "", -1), " ", "", -1))
+	for t := range validActivityType {
+		if strings.ToLower(t) == s {
+			return t
+		}
+	}
+	switch s {
+	case "running", "trailrunning", "treadmillrunning":
+		return "Run"
+	case "biking", "cycling", "roadbiking", "mountainbiking", "gravelcycling", "indoorcycling":
+		return "Ride"
+	case "ebiking":
+		return "EBikeRide"
+	case "walking":
+		return "Walk"
+	case "hiking":
+		return "Hike"
+	case "swimming", "poolswimming", "openwaterswimming", "lapswimming":
+		return "Swim"
+	case "rowing", "indoorrowing":
+		return "Rowing"
+	case "crosscountryskiing":
+		return "NordicSki"
+	case "alpineskiing":
+		return "AlpineSki"
+	case "snowboarding":
+		return "Snowboard"
+	case "paddling", "standuppaddleboarding":
+		return "StandUpPaddling"
+	case "kayaking":
+		return "Kayaking"
+	case "inlineskating":
+		return "InlineSkate"
+	case "iceskating":
+		return "IceSkate"
+	case "rockclimbing":
+		return "RockClimbing"
+	case "snowshoeing":
+		return "Snowshoe"
+	case "sailing":
+		return "Sail"
+	case "windsurfing":
+		return "Windsurf"
+	case "kitesurfing":
+		return "Kitesurf"
+	case "training", "fitnessequipment":
+		return "Workout"
+	}
+	return ""
+}
@@ -0,0 +1,155 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package bulk
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// durationUnits maps the units accepted by ParseDuration to their length.
+var durationUnits = map[string]time.Duration{
+	"s":   time.Second,
+	"sec": time.Second,
+	"m":   time.Minute,
+	"min": time.Minute,
+	"h":   time.Hour,
+	"hr":  time.Hour,
+}
+
+// ParseDuration parses a duration like "1:23:45", "45:00", "45m", "1h30m",
+// or "90s". A plain number is in units of unit (see durationUnits).
+func ParseDuration(s, unit string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	if strings.Contains(s, ":") {
+		return parseClockDuration(s)
+	}
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		u, ok := durationUnits[unit]
+		if !ok {
+			return 0, fmt.Errorf("invalid duration unit %q", unit)
+		}
+		return time.Duration(n * float64(u)), nil
+	}
+	d, err := time.ParseDuration(strings.NewReplacer("hr", "h", "min", "m", "sec", "s", " ", "").Replace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q (should be like 1:23:45, 45m, or 1h30m)", s)
+	}
+	return d, nil
+}
+
+// parseClockDuration parses a duration like "1:23:45" or "23:45.5".
+func parseClockDuration(s string) (time.Duration, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid duration %q (should be like 1:23:45)", s)
+	}
+	var d time.Duration
+	for i, part := range parts {
+		n, err := strconv.ParseFloat(part, 64)
+		last := i == len(parts)-1
+		if err != nil || n < 0 || (i > 0 && n >= 60) || (!last && n != float64(int64(n))) {
+			return 0, fmt.Errorf("invalid duration %q (should be like 1:23:45)", s)
+		}
+		d = d*60 + time.Duration(n*float64(time.Second))
+	}
+	return d, nil
+}
+
+// distanceUnits maps the units accepted by ParseDistance to their length in
+// meters.
+var distanceUnits = map[string]float64{
+	"m":  1,
+	"km": 1000,
+	"mi": 1609.344,
+	"yd": 0.9144,
+	"ft": 0.3048,
+}
+
+// ParseDistance parses a distance like "10km", "6.2mi", or "400yd", and
+// returns it in meters. A plain number is in units of unit (see
+// distanceUnits). The decimal separator is '.'; commas are only accepted as
+// thousands separators between groups of three digits, like "1,000m".
+func ParseDistance(s, unit string) (float64, error) {
+	orig := strings.TrimSpace(s)
+	s, err := normalizeNumber(orig, '.')
+	if err != nil {
+		return 0, err
+	}
+	if s == "" {
+		return 0, nil
+	}
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.' && r != '-'
+	})
+	if i >= 0 {
+		s, unit = strings.TrimSpace(s[:i]), strings.ToLower(strings.TrimSpace(s[i:]))
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid distance %q (should be like 10km, 6.2mi, or 400yd)", orig)
+	}
+	u, ok := distanceUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid distance unit %q (should be m, km, mi, yd, or ft)", unit)
+	}
+	return n * u, nil
+}
+
+// localTimeLayouts are the layouts accepted by ParseLocalTime in addition
+// to RFC 3339. Each may be followed by a UTC offset like "-08:00".
+var localTimeLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+}
+
+// ParseLocalTime parses an RFC 3339 time like "2019-02-22T18:53:46Z", or a
+// date-time like "2019-02-22 18:53" with an optional UTC offset. Times
+// without an offset are in loc, or the local time zone if loc is nil.
+func ParseLocalTime(s string, loc *time.Location) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if loc == nil {
+		loc = time.Local
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range localTimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+		for _, offset := range []string{"Z07:00", " Z07:00"} {
+			if t, err := time.Parse(layout+offset, s); err == nil {
+				return t, nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q (should be like 2019-02-22T18:53:46Z or 2019-02-22 18:53)", s)
+}
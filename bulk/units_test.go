@@ -0,0 +1,123 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package bulk
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		s, unit string
+		want    time.Duration
+		wantErr bool
+	}{
+		{s: "", unit: "s", want: 0},
+		{s: "90", unit: "s", want: 90 * time.Second},
+		{s: "1.5", unit: "h", want: 90 * time.Minute},
+		{s: "45m", unit: "s", want: 45 * time.Minute},
+		{s: "1h30m", unit: "s", want: 90 * time.Minute},
+		{s: "1 hr 30 min", unit: "s", want: 90 * time.Minute},
+		{s: "45:00", unit: "s", want: 45 * time.Minute},
+		{s: "1:23:45", unit: "s", want: time.Hour + 23*time.Minute + 45*time.Second},
+		{s: "23:45.5", unit: "s", want: 23*time.Minute + 45500*time.Millisecond},
+		{s: "10", unit: "fortnights", wantErr: true},
+		{s: "1:60", unit: "s", wantErr: true},
+		{s: "1:2:3:4", unit: "s", wantErr: true},
+		{s: "1.5:00", unit: "s", wantErr: true},
+		{s: "soon", unit: "s", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := ParseDuration(test.s, test.unit)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseDuration(%q, %q) got error %v, want error %v", test.s, test.unit, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseDuration(%q, %q) = %v, want %v", test.s, test.unit, got, test.want)
+		}
+	}
+}
+
+func TestParseDistance(t *testing.T) {
+	tests := []struct {
+		s, unit string
+		want    float64
+		wantErr bool
+	}{
+		{s: "", unit: "m", want: 0},
+		{s: "400", unit: "m", want: 400},
+		{s: "10", unit: "km", want: 10000},
+		{s: "10km", unit: "m", want: 10000},
+		{s: "10.5 km", unit: "m", want: 10500},
+		{s: "2mi", unit: "m", want: 3218.688},
+		{s: "100YD", unit: "m", want: 91.44},
+		{s: "1,000m", unit: "m", want: 1000},
+		{s: "1,000.5", unit: "m", want: 1000.5},
+		{s: "1,000,000", unit: "m", want: 1000000},
+		// Ambiguous: is ',' a decimal separator or a thousands separator?
+		{s: "10,5", unit: "m", wantErr: true},
+		{s: "1,0000", unit: "m", wantErr: true},
+		{s: "1.000.5", unit: "m", wantErr: true},
+		{s: "10 leagues", unit: "m", wantErr: true},
+		{s: "far", unit: "m", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := ParseDistance(test.s, test.unit)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseDistance(%q, %q) got error %v, want error %v", test.s, test.unit, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseDistance(%q, %q) = %v, want %v", test.s, test.unit, got, test.want)
+		}
+	}
+}
+
+func TestParseLocalTime(t *testing.T) {
+	loc := time.FixedZone("UTC-8", -8*60*60)
+	tests := []struct {
+		s       string
+		want    time.Time
+		wantErr bool
+	}{
+		{s: "2019-02-22T18:53:46Z", want: time.Date(2019, 2, 22, 18, 53, 46, 0, time.UTC)},
+		{s: "2019-02-22 18:53", want: time.Date(2019, 2, 22, 18, 53, 0, 0, loc)},
+		{s: "2019-02-22T18:53:46", want: time.Date(2019, 2, 22, 18, 53, 46, 0, loc)},
+		{s: "2019-02-22 18:53 +01:00", want: time.Date(2019, 2, 22, 17, 53, 0, 0, time.UTC)},
+		{s: "2019-02-22 18:53+01:00", want: time.Date(2019, 2, 22, 17, 53, 0, 0, time.UTC)},
+		{s: "2/22/2019 18:53", wantErr: true},
+		{s: "yesterday", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := ParseLocalTime(test.s, loc)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseLocalTime(%q) got error %v, want error %v", test.s, err, test.wantErr)
+			continue
+		}
+		if !got.Equal(test.want) {
+			t.Errorf("ParseLocalTime(%q) = %v, want %v", test.s, got, test.want)
+		}
+	}
+}
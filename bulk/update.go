@@ -0,0 +1,323 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package bulk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/antihax/optional"
+	"github.com/vangent/strava"
+)
+
+// dayFormat is the format for days in activity descriptions.
+const dayFormat = "2006-01-02"
+
+// Activity represents a single existing Strava activity, as downloaded by
+// Download and updated by Update.
+type Activity struct {
+	// Read-only fields.
+	ID    int64     `csv:"ID"`
+	Start time.Time `csv:"Start"`
+
+	// Editable fields.
+	ActivityType string      `csv:"Activity Type"`
+	SportType    string      `csv:"Sport Type"`
+	Name         string      `csv:"Name"`
+	WorkoutType  WorkoutType `csv:"Workout Type"`
+	GearID       string      `csv:"Gear ID"`
+	Commute      bool        `csv:"Commute?"`
+	Trainer      bool        `csv:"Trainer?"`
+
+	// absent holds the columns that weren't in the .csv that a was read
+	// from; they are left unchanged by Update.
+	absent map[string]bool
+}
+
+func (a *Activity) String() string {
+	return fmt.Sprintf("[%s on %s (ID %d)]", a.Name, a.Start.Format(dayFormat), a.ID)
+}
+
+// fillAbsent sets the fields of a for columns that weren't in its .csv to
+// their values in prev, and the Start to prev's if only its seconds were
+// dropped.
+func (a *Activity) fillAbsent(prev *Activity) {
+	for column := range a.absent {
+		switch column {
+		case "Start":
+			a.Start = prev.Start
+		case "Activity Type":
+			a.ActivityType = prev.ActivityType
+		case "Sport Type":
+			a.SportType = prev.SportType
+		case "Name":
+			a.Name = prev.Name
+		case "Workout Type":
+			a.WorkoutType = prev.WorkoutType
+		case "Gear ID":
+			a.GearID = prev.GearID
+		case "Commute?":
+			a.Commute = prev.Commute
+		case "Trainer?":
+			a.Trainer = prev.Trainer
+		}
+	}
+	a.absent = nil
+	if a.Start.Second() == 0 && a.Start.Equal(prev.Start.Truncate(time.Minute)) {
+		a.Start = prev.Start
+	}
+}
+
+// unchanged returns true if a has the same values as prev.
+func (a *Activity) unchanged(prev *Activity) bool {
+	return a.ID == prev.ID &&
+		a.Start.Equal(prev.Start) &&
+		a.ActivityType == prev.ActivityType &&
+		a.SportType == prev.SportType &&
+		a.Name == prev.Name &&
+		a.WorkoutType == prev.WorkoutType &&
+		a.GearID == prev.GearID &&
+		a.Commute == prev.Commute &&
+		a.Trainer == prev.Trainer
+}
+
+// unchangedWorkoutType returns true if a has the same Workout Type and
+// Activity Type as prev.
+func (a *Activity) unchangedWorkoutType(prev *Activity) bool {
+	return a.WorkoutType == prev.WorkoutType &&
+		EffectiveActivityType(a.ActivityType, a.SportType) == EffectiveActivityType(prev.ActivityType, prev.SportType)
+}
+
+// workoutTypeValue returns the Strava number to send for a's Workout Type.
+// An unchanged number is sent back as-is.
+func (a *Activity) workoutTypeValue(prev *Activity, activityType string) (int, error) {
+	if a.unchangedWorkoutType(prev) {
+		if n, err := strconv.Atoi(strings.TrimSpace(string(a.WorkoutType))); err == nil {
+			return n, nil
+		}
+	}
+	return a.WorkoutType.Value(activityType)
+}
+
+// Change is a change to a single column of an Activity.
+type Change struct {
+	Column   string
+	Old, New string
+}
+
+// Changes returns the changes from prev to a, in column order.
+func (a *Activity) Changes(prev *Activity) []*Change {
+	var changes []*Change
+	add := func(column, old, new string) {
+		if old != new {
+			changes = append(changes, &Change{Column: column, Old: old, New: new})
+		}
+	}
+	if !a.Start.Equal(prev.Start) {
+		add("Start", prev.Start.Format(time.RFC3339), a.Start.Format(time.RFC3339))
+	}
+	add("Activity Type", prev.ActivityType, a.ActivityType)
+	add("Sport Type", prev.SportType, a.SportType)
+	add("Name", prev.Name, a.Name)
+	add("Workout Type", string(prev.WorkoutType), string(a.WorkoutType))
+	add("Gear ID", prev.GearID, a.GearID)
+	add("Commute?", strconv.FormatBool(prev.Commute), strconv.FormatBool(a.Commute))
+	add("Trainer?", strconv.FormatBool(prev.Trainer), strconv.FormatBool(a.Trainer))
+	return changes
+}
+
+// Problems returns the problems with a as an update to prev, including
+// warnings, like ValidateActivities does for a row. opts may be nil.
+func (a *Activity) Problems(prev *Activity, opts *ValidateOptions) []*Problem {
+	return a.check(prev, validateDefaults(opts))
+}
+
+// Verify checks to see that a looks like it can be uploaded as an update to prev.
+func (a *Activity) Verify(prev *Activity) error {
+	return firstError(a.check(prev, validateDefaults(nil)))
+}
+
+// check returns the problems with a as an update to prev.
+func (a *Activity) check(prev *Activity, opts *ValidateOptions) []*Problem {
+	var problems []*Problem
+	// Spreadsheets may drop the seconds when they reformat Start.
+	droppedSeconds := a.Start.Second() == 0 && a.Start.Equal(prev.Start.Truncate(time.Minute))
+	if !a.Start.Equal(prev.Start) && !droppedSeconds {
+		problems = append(problems, &Problem{Column: "Start", Err: errors.New("sorry, can't modify Start")})
+	}
+	workoutType := a.WorkoutType
+	if a.unchangedWorkoutType(prev) {
+		// It's what Strava has, even if it's a number Value would reject.
+		workoutType = ""
+	}
+	problems = append(problems, typeProblems(a.ActivityType, a.SportType, workoutType)...)
+	if a.GearID != prev.GearID {
+		problems = append(problems, gearProblems(a.GearID, opts)...)
+	}
+	return problems
+}
+
+// Download returns the logged-in athlete's activities. opts may be nil.
+func (c *Client) Download(ctx context.Context, opts *ListOptions) ([]*Activity, error) {
+	var activities []*Activity
+	err := c.ListActivities(ctx, opts, func(a *strava.SummaryActivity) {
+		activity := &Activity{
+			ID:           a.Id,
+			Start:        a.StartDate,
+			ActivityType: string(*a.Type_),
+			Name:         a.Name,
+			WorkoutType:  WorkoutTypeFor(string(*a.Type_), int(a.WorkoutType)),
+			GearID:       a.GearId,
+			Commute:      a.Commute,
+			Trainer:      a.Trainer,
+		}
+		activities = append(activities, activity)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return activities, nil
+}
+
+// GetActivity returns the logged-in athlete's activity with the given ID.
+func (c *Client) GetActivity(ctx context.Context, id int64) (*Activity, error) {
+	a, resp, err := c.api.ActivitiesApi.GetActivityById(c.Context(ctx), id, nil)
+	if err != nil {
+		return nil, apiError(err, resp)
+	}
+	activityType := ""
+	if a.Type_ != nil {
+		activityType = string(*a.Type_)
+	}
+	return &Activity{
+		ID:           a.Id,
+		Start:        a.StartDate,
+		ActivityType: activityType,
+		Name:         a.Name,
+		WorkoutType:  WorkoutTypeFor(activityType, int(a.WorkoutType)),
+		GearID:       a.GearId,
+		Commute:      a.Commute,
+		Trainer:      a.Trainer,
+	}, nil
+}
+
+// UpdateOptions holds options for Update.
+type UpdateOptions struct {
+	// StartRow skips rows before it; row 0 is the header row.
+	StartRow int
+	// DryRun verifies the rows without updating anything.
+	DryRun bool
+	// Progress, if not nil, is called with StatusStarted before each row is
+	// sent to Strava, and again with the outcome of each row.
+	Progress func(*RowResult)
+}
+
+// Update applies the changes in updated, relative to orig, to Strava.
+// Activities are matched by ID, and unchanged activities are skipped.
+// Columns that were missing from the .csv updated was read from are left
+// unchanged. If a row fails, Update stops and returns a *RowError; its Err
+// is a *ValidationError if the row failed verification. If ctx is canceled
+// between rows, Update stops and returns a *RowError for the next row with
+// Err set to ctx.Err(). opts may be nil.
+func (c *Client) Update(ctx context.Context, orig, updated []*Activity, opts *UpdateOptions) ([]*RowResult, error) {
+	if opts == nil {
+		opts = &UpdateOptions{}
+	}
+	prevByID := map[int64]*Activity{}
+	for _, a := range orig {
+		prevByID[a.ID] = a
+	}
+	if len(updated) != len(prevByID) {
+		return nil, fmt.Errorf("original has %d activities, but updated has %d; for update, they should be the same", len(prevByID), len(updated))
+	}
+	ctx = c.Context(ctx)
+	var results []*RowResult
+	for i, a := range updated {
+		row := i + 1 // row 0 is the header row
+		if row < opts.StartRow {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return results, &RowError{row, a, ActionUpdate, err}
+		}
+		r := &RowResult{Row: row, Activity: a, Action: ActionUpdate, ActivityID: a.ID, URL: c.ActivityURL(a.ID)}
+		prev := prevByID[a.ID]
+		if prev == nil {
+			return results, &RowError{row, a, ActionUpdate, &ValidationError{errors.New("activity ID not found in the original")}}
+		}
+		a.fillAbsent(prev)
+		if a.unchanged(prev) {
+			r.Status = StatusUnchanged
+		} else if err := c.updateOne(ctx, a, prev, opts, r); err != nil {
+			r.Status, r.Err = failedStatus(ctx), err
+			progress(opts.Progress, r)
+			return append(results, r), &RowError{row, a, ActionUpdate, err}
+		}
+		progress(opts.Progress, r)
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// updateOne updates a, whose original values were prev, filling in r.
+func (c *Client) updateOne(ctx context.Context, a, prev *Activity, opts *UpdateOptions, r *RowResult) error {
+	if err := a.Verify(prev); err != nil {
+		return &ValidationError{err}
+	}
+	if opts.DryRun {
+		r.Status = StatusDryRun
+		return nil
+	}
+	r.Status = StatusStarted
+	progress(opts.Progress, r)
+	activityType := strava.ActivityType(EffectiveActivityType(a.ActivityType, a.SportType))
+	workoutType, err := a.workoutTypeValue(prev, string(activityType))
+	if err != nil {
+		return err
+	}
+	update := strava.UpdatableActivity{
+		Name:        a.Name,
+		Type_:       &activityType,
+		WorkoutType: workoutType,
+		GearId:      a.GearID,
+		Commute:     a.Commute,
+		Trainer:     a.Trainer,
+	}
+	detailedActivity, resp, err := c.api.ActivitiesApi.UpdateActivityById(ctx, a.ID, &strava.UpdateActivityByIdOpts{Body: optional.NewInterface(update)})
+	if err != nil {
+		return apiError(err, resp)
+	}
+	if a.SportType != "" && a.SportType != prev.SportType {
+		if err := c.SetSportType(ctx, a.ID, a.SportType); err != nil {
+			return err
+		}
+	}
+	r.Status = StatusDone
+	r.ActivityID = detailedActivity.Id
+	r.URL = c.ActivityURL(detailedActivity.Id)
+	return nil
+}
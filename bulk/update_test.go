@@ -0,0 +1,213 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package bulk
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vangent/strava"
+)
+
+func TestDownloadUpdate(t *testing.T) {
+	fake, client, done := newFakeClient(4)
+	defer done()
+	ctx := context.Background()
+
+	orig, err := client.Download(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orig) != 4 {
+		t.Fatalf("got %d activities, want 4", len(orig))
+	}
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, orig, nil); err != nil {
+		t.Fatal(err)
+	}
+	updated, err := ReadActivities(bytes.NewReader(buf.Bytes()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Rename the first activity, and make the second a mountain bike ride.
+	updated[0].Name = "Renamed"
+	updated[1].ActivityType, updated[1].SportType = "Ride", "MountainBikeRide"
+
+	var statuses []Status
+	results, err := client.Update(ctx, orig, updated, &UpdateOptions{Progress: func(r *RowResult) { statuses = append(statuses, r.Status) }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Status{StatusDone, StatusDone, StatusUnchanged, StatusUnchanged}
+	for i, r := range results {
+		if r.Status != want[i] {
+			t.Errorf("row %d: got status %q, want %q", r.Row, r.Status, want[i])
+		}
+	}
+	if got := fmt.Sprint(statuses); got != "[started done started done unchanged unchanged]" {
+		t.Errorf("got progress %s", got)
+	}
+
+	a, _, _ := fake.Activity(updated[0].ID)
+	if a.Name != "Renamed" {
+		t.Errorf("got name %q, want %q", a.Name, "Renamed")
+	}
+	a, sportType, _ := fake.Activity(updated[1].ID)
+	if *a.Type_ != strava.RIDE_ActivityType || sportType != "MountainBikeRide" {
+		t.Errorf("got type %q and sport type %q, want Ride and MountainBikeRide", *a.Type_, sportType)
+	}
+	if a.Name != orig[1].Name || a.GearId != orig[1].GearID {
+		t.Errorf("unchanged fields were modified: %+v", a)
+	}
+}
+
+func TestUpdatePartialColumns(t *testing.T) {
+	fake, client, done := newFakeClient(2)
+	defer done()
+	ctx := context.Background()
+
+	orig, err := client.Download(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csv := fmt.Sprintf("ID,Name\n%d,Evening Ride\n%d,%s\n", orig[0].ID, orig[1].ID, orig[1].Name)
+	updated, err := ReadActivities(strings.NewReader(csv), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := client.Update(ctx, orig, updated, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Status != StatusDone || results[1].Status != StatusUnchanged {
+		t.Errorf("got statuses %q and %q, want done and unchanged", results[0].Status, results[1].Status)
+	}
+	a, _, _ := fake.Activity(orig[0].ID)
+	if a.Name != "Evening Ride" {
+		t.Errorf("got name %q, want %q", a.Name, "Evening Ride")
+	}
+	if string(*a.Type_) != orig[0].ActivityType || a.GearId != orig[0].GearID || a.Commute != orig[0].Commute {
+		t.Errorf("columns missing from the .csv were modified: %+v", a)
+	}
+}
+
+func TestUpdateDryRun(t *testing.T) {
+	fake, client, done := newFakeClient(2)
+	defer done()
+	ctx := context.Background()
+
+	orig, err := client.Download(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated := make([]*Activity, len(orig))
+	for i, a := range orig {
+		cp := *a
+		updated[i] = &cp
+	}
+	updated[0].Name = "Renamed"
+	results, err := client.Update(ctx, orig, updated, &UpdateOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Status != StatusDryRun {
+		t.Errorf("got status %q, want %q", results[0].Status, StatusDryRun)
+	}
+	if a, _, _ := fake.Activity(orig[0].ID); a.Name != orig[0].Name {
+		t.Errorf("dry run renamed the activity to %q", a.Name)
+	}
+}
+
+func TestUpdateValidationError(t *testing.T) {
+	fake, client, done := newFakeClient(2)
+	defer done()
+	ctx := context.Background()
+
+	orig, err := client.Download(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated := make([]*Activity, len(orig))
+	for i, a := range orig {
+		cp := *a
+		updated[i] = &cp
+	}
+	updated[0].Name = "Renamed"
+	updated[1].Start = updated[1].Start.Add(time.Hour)
+	results, err := client.Update(ctx, orig, updated, nil)
+	rowErr, ok := err.(*RowError)
+	if !ok {
+		t.Fatalf("got error %v, want a *RowError", err)
+	}
+	if rowErr.Row != 2 {
+		t.Errorf("got row %d, want 2", rowErr.Row)
+	}
+	if _, ok := rowErr.Err.(*ValidationError); !ok {
+		t.Errorf("got %T, want a *ValidationError", rowErr.Err)
+	}
+	if len(results) != 2 || results[0].Status != StatusDone || results[1].Status != StatusFailed {
+		t.Errorf("got results %+v, want row 1 done and row 2 failed", results)
+	}
+	if a, _, _ := fake.Activity(orig[1].ID); !a.StartDate.Equal(orig[1].Start) {
+		t.Errorf("start was changed to %v", a.StartDate)
+	}
+}
+
+func TestUpdateKeepsWorkoutType(t *testing.T) {
+	fake, client, done := newFakeClient(1)
+	defer done()
+	ctx := context.Background()
+
+	// Strava uses 10 for some VirtualRides; Value rejects it, since it's
+	// a Ride's "None", but an update should still write it back.
+	virtualRide := strava.VIRTUAL_RIDE_ActivityType
+	fake.AddActivity(strava.DetailedActivity{Name: "Zwift", Type_: &virtualRide, WorkoutType: 10, StartDate: testEnd.Add(-time.Hour), ElapsedTime: 3600}, "")
+	orig, err := client.Download(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, orig, nil); err != nil {
+		t.Fatal(err)
+	}
+	updated, err := ReadActivities(bytes.NewReader(buf.Bytes()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range updated {
+		a.Name = "Renamed"
+	}
+	if _, err := client.Update(ctx, orig, updated, nil); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []int32{10, 0} {
+		a, _, _ := fake.Activity(orig[i].ID)
+		if a.Name != "Renamed" || a.WorkoutType != want {
+			t.Errorf("%s: got name %q and workout type %d, want Renamed and %d", *a.Type_, a.Name, a.WorkoutType, want)
+		}
+	}
+}
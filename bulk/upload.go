@@ -0,0 +1,577 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package bulk
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/antihax/optional"
+	"github.com/vangent/strava"
+	"github.com/vangent/stravacli/activityfile"
+)
+
+// UploadActivity is an activity file to upload.
+type UploadActivity struct {
+	ExternalID   string      `csv:"External ID"`
+	ActivityType string      `csv:"Activity Type"`
+	SportType    string      `csv:"Sport Type"`
+	Name         string      `csv:"Name"`
+	Description  string      `csv:"Description"`
+	WorkoutType  WorkoutType `csv:"Workout Type"`
+	GearID       string      `csv:"Gear ID"`
+	Commute      bool        `csv:"Commute?"`
+	Trainer      bool        `csv:"Trainer?"`
+	FileType     string      `csv:"File Type"`
+	Filename     string      `csv:"Filename"`
+
+	summary    *activityfile.Summary // cached result of Summarize
+	summaryErr error
+	hash       string // cached result of ContentHash
+}
+
+func (a *UploadActivity) String() string {
+	return fmt.Sprintf("[%s from %s]", a.Name, a.Filename)
+}
+
+// Summarize parses a.Filename, caching the result.
+func (a *UploadActivity) Summarize() (*activityfile.Summary, error) {
+	if a.summary == nil && a.summaryErr == nil {
+		a.summary, a.summaryErr = activityfile.ParseFile(a.Filename)
+	}
+	return a.summary, a.summaryErr
+}
+
+// ContentHash returns a hash of the contents of a.Filename, caching the
+// result.
+func (a *UploadActivity) ContentHash() (string, error) {
+	if a.hash == "" {
+		hash, err := HashFile(a.Filename)
+		if err != nil {
+			return "", err
+		}
+		a.hash = hash
+	}
+	return a.hash, nil
+}
+
+// Details returns a description of the activity data in a's file.
+func (a *UploadActivity) Details() string {
+	s, err := a.Summarize()
+	if err != nil {
+		return ""
+	}
+	details := []string{
+		EffectiveActivityType(a.ActivityType, a.SportType),
+		s.Start.Format("2006-01-02 15:04"),
+		s.Duration.String(),
+		fmt.Sprintf("%.2f km", s.Distance/1000),
+	}
+	if s.Device != "" {
+		details = append(details, s.Device)
+	}
+	return " (" + strings.Join(details, ", ") + ")"
+}
+
+var validFileType = map[string]bool{
+	"fit":    true,
+	"fit.gz": true,
+	"tcx":    true,
+	"tcx.gz": true,
+	"gpx":    true,
+	"gpx.gz": true,
+}
+
+// FileTypeForPath returns the File Type for path based on its extension
+// (e.g., "gpx.gz" for "ride.gpx.gz"), or "" if path doesn't look like a
+// supported activity file.
+func FileTypeForPath(path string) string {
+	base := strings.ToLower(filepath.Base(path))
+	for fileType := range validFileType {
+		if strings.HasSuffix(base, "."+fileType) {
+			return fileType
+		}
+	}
+	return ""
+}
+
+// Verify checks to see that a looks like it can be uploaded.
+func (a *UploadActivity) Verify() error {
+	return firstError(a.check(validateDefaults(nil)))
+}
+
+// check returns the problems with a.
+func (a *UploadActivity) check(opts *ValidateOptions) []*Problem {
+	var problems []*Problem
+	if a.ActivityType == "" && a.SportType == "" {
+		problems = append(problems, &Problem{Column: "Activity Type", Err: errors.New("missing Activity Type, and it couldn't be inferred from the file")})
+	} else {
+		problems = append(problems, typeProblems(a.ActivityType, a.SportType, a.WorkoutType)...)
+	}
+	if a.Name == "" {
+		problems = append(problems, &Problem{Column: "Name", Err: errors.New("missing Name")})
+	}
+	problems = append(problems, gearProblems(a.GearID, opts)...)
+	switch {
+	case a.FileType == "":
+		problems = append(problems, &Problem{Column: "File Type", Err: errors.New("missing File Type")})
+	case !validFileType[a.FileType]:
+		problems = append(problems, &Problem{Column: "File Type", Err: fmt.Errorf("invalid File Type %q", a.FileType)})
+	}
+	if a.Filename == "" {
+		return append(problems, &Problem{Column: "Filename", Err: errors.New("missing Filename")})
+	}
+	if _, err := os.Stat(a.Filename); os.IsNotExist(err) {
+		return append(problems, &Problem{Column: "Filename", Err: fmt.Errorf("Filename %q not found", a.Filename)})
+	}
+	s, err := a.Summarize()
+	if err != nil {
+		return append(problems, &Problem{Column: "Filename", Err: fmt.Errorf("Filename %q: %v", a.Filename, err)})
+	}
+	if fileType := s.FileType(); validFileType[a.FileType] && fileType != a.FileType {
+		problems = append(problems, &Problem{Column: "File Type", Err: fmt.Errorf("File Type is %q, but %q looks like %q", a.FileType, a.Filename, fileType)})
+	}
+	if s.Start.After(opts.Now) {
+		problems = append(problems, &Problem{Column: "Filename", Err: fmt.Errorf("the activity in %q starts in the future, at %s", a.Filename, s.Start.Format("2006-01-02 15:04")), Warning: true})
+	}
+	return problems
+}
+
+// HashFile returns a hex-encoded SHA-256 hash of the contents of filename.
+func HashFile(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ExternalIDForHash returns a deterministic External ID for a file with
+// the given content hash.
+func ExternalIDForHash(hash string) string {
+	return "stravacli-" + hash[:32]
+}
+
+// SubmitOptions holds options for Submit.
+type SubmitOptions struct {
+	// DryRun verifies the activity without uploading it.
+	DryRun bool
+	// Compress gzips the file before uploading it, if it isn't already
+	// compressed and it's at least CompressThreshold bytes.
+	Compress          bool
+	CompressThreshold int64
+	// Progress, if not nil, is called with StatusStarted or StatusDryRun
+	// before the file is uploaded.
+	Progress func(*RowResult)
+}
+
+// Submit uploads a's file to Strava. If a has no Activity Type, the sport
+// recorded in the file is used; if it has no External ID, one is derived
+// from the file's contents. The returned RowResult has StatusSubmitted and
+// the UploadID for the upload, or StatusDryRun; Submit doesn't wait for
+// Strava to process the upload (see CheckUpload). If a fails verification,
+// the error is a *ValidationError, and if Strava rejects the file right
+// away, it's an *UploadError. opts may be nil.
+func (c *Client) Submit(ctx context.Context, a *UploadActivity, opts *SubmitOptions) (*RowResult, error) {
+	if opts == nil {
+		opts = &SubmitOptions{}
+	}
+	r := &RowResult{Activity: a, Action: ActionUpload}
+	if a.ActivityType == "" && a.SportType == "" {
+		// Default to the sport recorded in the file.
+		if s, err := a.Summarize(); err == nil {
+			a.ActivityType = SportToActivityType(s.Sport)
+		}
+	}
+	if a.ExternalID == "" {
+		if hash, err := a.ContentHash(); err == nil {
+			a.ExternalID = ExternalIDForHash(hash)
+		}
+	}
+	if err := a.Verify(); err != nil {
+		return nil, &ValidationError{err}
+	}
+	info, err := os.Stat(a.Filename)
+	if err != nil {
+		return nil, err
+	}
+	compress := opts.Compress && info.Size() >= opts.CompressThreshold && !strings.HasSuffix(a.FileType, ".gz")
+	r.Details = a.Details()
+	if compress {
+		r.Details += ", compressed"
+	}
+	if opts.DryRun {
+		r.Status = StatusDryRun
+		progress(opts.Progress, r)
+		return r, nil
+	}
+	r.Status = StatusStarted
+	progress(opts.Progress, r)
+
+	dataType := a.FileType
+	var f *os.File
+	if compress {
+		f, err = gzipToTempFile(a.Filename)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compress %q: %v", a.Filename, err)
+		}
+		defer os.Remove(f.Name())
+		dataType += ".gz"
+	} else if f, err = os.Open(a.Filename); err != nil {
+		return nil, fmt.Errorf("failed to open %q: %v", a.Filename, err)
+	}
+	defer f.Close()
+
+	activityType := EffectiveActivityType(a.ActivityType, a.SportType)
+	createOpts := strava.CreateUploadOpts{
+		Name:     optional.NewString(a.Name),
+		Type:     optional.NewString(activityType),
+		DataType: optional.NewString(dataType),
+		File:     optional.NewInterface(f),
+	}
+	if a.ExternalID != "" {
+		createOpts.ExternalId = optional.NewString(a.ExternalID)
+	}
+	if a.Description != "" {
+		createOpts.Description = optional.NewString(a.Description)
+	}
+	if wt, _ := a.WorkoutType.Value(activityType); wt != 0 {
+		createOpts.WorkoutType = optional.NewInt32(int32(wt))
+	}
+	if a.GearID != "" {
+		createOpts.GearId = optional.NewString(a.GearID)
+	}
+	if a.Trainer {
+		createOpts.Trainer = optional.NewInt32(1)
+	}
+	if a.Commute {
+		createOpts.Commute = optional.NewInt32(1)
+	}
+	upload, resp, err := c.api.UploadsApi.CreateUpload(c.Context(ctx), &createOpts)
+	if err != nil {
+		return nil, apiError(err, resp)
+	}
+	if upload.Error_ != "" {
+		return nil, &UploadError{upload.Error_}
+	}
+	r.Status = StatusSubmitted
+	r.UploadID = upload.Id
+	return r, nil
+}
+
+// CheckUpload gets the current status of the upload with ID uploadID. done
+// is true if Strava has finished processing the upload; then either
+// activityID is set, or uploadErr describes why the upload failed.
+func (c *Client) CheckUpload(ctx context.Context, uploadID int64) (done bool, activityID int64, uploadErr string, err error) {
+	upload, _, err := c.api.UploadsApi.GetUploadById(c.Context(ctx), uploadID)
+	if err != nil {
+		return false, 0, "", err
+	}
+	return upload.ActivityId != 0 || upload.Error_ != "", upload.ActivityId, upload.Error_, nil
+}
+
+// CheckLedgerEntry gets the current status of e's upload, updating e. It
+// returns true if Strava has finished processing the upload, whether it
+// succeeded or not. Once the upload has succeeded, e's Sport Type is set on
+// the activity; if that fails, CheckLedgerEntry returns true and the error,
+// and the Sport Type is left in e to try again later.
+func (c *Client) CheckLedgerEntry(ctx context.Context, e *LedgerEntry) (bool, error) {
+	done, activityID, uploadErr, err := c.CheckUpload(ctx, e.UploadID)
+	if err != nil {
+		return false, err
+	}
+	e.ActivityID = activityID
+	e.Error = uploadErr
+	if e.ActivityID == 0 || e.SportType == "" {
+		return done, nil
+	}
+	if err := c.SetSportType(ctx, e.ActivityID, e.SportType); err != nil {
+		return true, err
+	}
+	e.SportType = ""
+	return true, nil
+}
+
+// uploadSportType returns the Sport Type to set on a's activity after it's
+// uploaded, or "" if the upload already sets it.
+func uploadSportType(a *UploadActivity) string {
+	if a.SportType == EffectiveActivityType(a.ActivityType, a.SportType) {
+		return ""
+	}
+	return a.SportType
+}
+
+const (
+	// Bounds on the delay between checks on uploads that Strava is still
+	// processing.
+	uploadPollMinDelay = 1 * time.Second
+	uploadPollMaxDelay = 30 * time.Second
+)
+
+// UploadOptions holds options for Upload.
+type UploadOptions struct {
+	// StartRow skips rows before it; row 0 is the header row.
+	StartRow int
+	// DryRun verifies the rows without uploading anything.
+	DryRun bool
+	// Compress gzips files that aren't already compressed and are at least
+	// CompressThreshold bytes before uploading them.
+	Compress          bool
+	CompressThreshold int64
+	// Ledger, if not nil, records each upload. Files that it shows were
+	// already uploaded are skipped, and files that were submitted before
+	// but not processed yet are checked on instead of being uploaded again.
+	Ledger *Ledger
+	// OnDuplicate is what to do with files that look like the same activity
+	// as an existing one; "" to not check for them.
+	OnDuplicate OnDuplicate
+	// Timeout is how long to wait for Strava to process each upload.
+	Timeout time.Duration
+	// Progress, if not nil, is called as each row makes progress; see
+	// Upload.
+	Progress func(*RowResult)
+}
+
+// Upload uploads the files for activities to Strava, and waits for Strava
+// to process them.
+//
+// All of the files are submitted first. Progress is called with
+// StatusStarted before each file is uploaded, and then with StatusSubmitted,
+// or with StatusSkipped or StatusDryRun. Files that the Ledger shows were
+// already submitted are reported with StatusSubmitted too. Then Strava is
+// polled until it finishes processing each upload, and Progress is called
+// with StatusDone or StatusFailed, or with StatusSubmitted again if Timeout
+// passes first. Sport Types that uploads can't set are set once the upload
+// is done; if that fails, the row has StatusDone with Err set.
+//
+// The returned results have the latest status of each row; rows that
+// Strava couldn't process have StatusFailed with an *UploadError. If a file
+// can't be submitted, or it's a duplicate and OnDuplicate is
+// OnDuplicateFail, Upload stops and returns a *RowError; its Err is a
+// *ValidationError if the row failed verification, or an *UploadError if
+// Strava rejected the file. If ctx is canceled between rows, Upload stops
+// and returns a *RowError for the next row with Err set to ctx.Err(); if
+// it's canceled while waiting for Strava, it returns ctx.Err(). Uploads that
+// Strava hadn't finished processing have StatusSubmitted. opts may be nil.
+func (c *Client) Upload(ctx context.Context, activities []*UploadActivity, opts *UploadOptions) ([]*RowResult, error) {
+	if opts == nil {
+		opts = &UploadOptions{}
+	}
+	led := opts.Ledger
+	if led == nil {
+		led = &Ledger{Entries: map[string]*LedgerEntry{}}
+	}
+	dups := map[*UploadActivity][]int64{}
+	if opts.OnDuplicate != "" {
+		var err error
+		if dups, err = c.findDuplicates(ctx, activities[startIndex(len(activities), opts.StartRow):]); err != nil {
+			return nil, err
+		}
+	}
+	var results []*RowResult
+	var pending []*pendingUpload
+	for i, a := range activities {
+		row := i + 1 // row 0 is the header row
+		if row < opts.StartRow {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return results, &RowError{row, a, ActionUpload, err}
+		}
+		r := &RowResult{Row: row, Activity: a, Action: ActionUpload}
+		results = append(results, r)
+		hash, hashErr := a.ContentHash() // errors are reported by Submit
+		if hashErr == nil {
+			if e := led.Uploaded(hash); e != nil {
+				r.Status, r.Details, r.ActivityID, r.URL = StatusSkipped, "already uploaded", e.ActivityID, c.ActivityURL(e.ActivityID)
+				progress(opts.Progress, r)
+				continue
+			}
+			if e := led.Entries[hash]; e != nil && e.IsPending() && !opts.DryRun {
+				r.Status, r.UploadID = StatusSubmitted, e.UploadID
+				progress(opts.Progress, r)
+				pending = append(pending, &pendingUpload{r, e, time.Now().Add(opts.Timeout)})
+				continue
+			}
+		}
+		if ids := dups[a]; len(ids) > 0 {
+			r.Duplicates = ids
+			switch opts.OnDuplicate {
+			case OnDuplicateSkip:
+				r.Status, r.Details = StatusSkipped, "duplicate of "+c.activityURLs(ids)
+				progress(opts.Progress, r)
+				continue
+			case OnDuplicateFail:
+				if opts.DryRun {
+					r.Status, r.Details = StatusDryRun, "would fail as a duplicate of "+c.activityURLs(ids)
+					progress(opts.Progress, r)
+					continue
+				}
+				err := fmt.Errorf("duplicate of %s", c.activityURLs(ids))
+				r.Status, r.Err = StatusFailed, err
+				progress(opts.Progress, r)
+				return results, &RowError{row, a, ActionUpload, err}
+			}
+		}
+		submitted, err := c.Submit(ctx, a, &SubmitOptions{
+			DryRun:            opts.DryRun,
+			Compress:          opts.Compress,
+			CompressThreshold: opts.CompressThreshold,
+			Progress: func(sr *RowResult) {
+				r.Status, r.Details = sr.Status, sr.Details
+				progress(opts.Progress, r)
+			},
+		})
+		if err != nil {
+			r.Status, r.Err = failedStatus(ctx), err
+			progress(opts.Progress, r)
+			return results, &RowError{row, a, ActionUpload, err}
+		}
+		if opts.DryRun {
+			continue
+		}
+		r.Status, r.UploadID = StatusSubmitted, submitted.UploadID
+		progress(opts.Progress, r)
+		e := &LedgerEntry{
+			Hash:       hash,
+			Filename:   a.Filename,
+			ExternalID: a.ExternalID,
+			UploadID:   submitted.UploadID,
+			SportType:  uploadSportType(a),
+		}
+		if err := led.Record(e); err != nil {
+			return results, err
+		}
+		pending = append(pending, &pendingUpload{r, e, time.Now().Add(opts.Timeout)})
+	}
+	if opts.DryRun {
+		return results, nil
+	}
+	return results, c.waitForUploads(ctx, led, pending, opts.Progress)
+}
+
+// startIndex returns the index into a slice of n rows for startRow.
+func startIndex(n, startRow int) int {
+	i := startRow - 1 // row 0 is the header row
+	if i < 0 {
+		return 0
+	}
+	if i > n {
+		return n
+	}
+	return i
+}
+
+// pendingUpload is an upload that Strava may still be processing.
+type pendingUpload struct {
+	result   *RowResult
+	entry    *LedgerEntry
+	deadline time.Time
+}
+
+// waitForUploads polls Strava until all of the uploads in pending have been
+// processed, or their deadlines have passed, recording the results in led
+// and reporting them to f. All pending uploads are checked in each round,
+// with exponential backoff between rounds.
+func (c *Client) waitForUploads(ctx context.Context, led *Ledger, pending []*pendingUpload, f func(*RowResult)) error {
+	delay := uploadPollMinDelay
+	for len(pending) > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		var stillPending []*pendingUpload
+		for _, p := range pending {
+			done, err := c.CheckLedgerEntry(ctx, p.entry)
+			r := p.result
+			switch {
+			case done && p.entry.Error != "":
+				r.Status, r.Err = StatusFailed, &UploadError{p.entry.Error}
+			case done:
+				r.Status, r.ActivityID, r.URL, r.Err = StatusDone, p.entry.ActivityID, c.ActivityURL(p.entry.ActivityID), err
+			case time.Now().After(p.deadline):
+				r.Details = "still processing"
+				progress(f, r)
+				continue
+			default:
+				// If checking failed, it's probably transient; try again
+				// next round.
+				stillPending = append(stillPending, p)
+				continue
+			}
+			progress(f, r)
+			if err := led.Record(p.entry); err != nil {
+				return err
+			}
+		}
+		pending = stillPending
+		if delay *= 2; delay > uploadPollMaxDelay {
+			delay = uploadPollMaxDelay
+		}
+	}
+	return nil
+}
+
+// gzipToTempFile writes a gzipped copy of filename to a temporary file, and
+// returns it, positioned at the start. The caller should close and remove it.
+func gzipToTempFile(filename string) (*os.File, error) {
+	in, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	out, err := ioutil.TempFile("", "stravacli-*-"+filepath.Base(filename)+".gz")
+	if err != nil {
+		return nil, err
+	}
+	gz := gzip.NewWriter(out)
+	_, err = io.Copy(gz, in)
+	if cerr := gz.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		_, err = out.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return nil, err
+	}
+	return out, nil
+}
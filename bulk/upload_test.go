@@ -0,0 +1,269 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package bulk
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vangent/stravacli/fakestrava"
+)
+
+// writeGPX writes a .gpx track starting at start and lasting d to dir/name,
+// and returns its path.
+func writeGPX(t *testing.T, dir, name string, start time.Time, d time.Duration) string {
+	t.Helper()
+	var pts strings.Builder
+	for i := 0; i < 5; i++ {
+		fmt.Fprintf(&pts, `<trkpt lat="47.6%d" lon="-122.3"><time>%s</time></trkpt>`, i, start.Add(time.Duration(i)*d/4).UTC().Format(time.RFC3339))
+	}
+	gpx := `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1">
+<trk><name>` + name + `</name><type>cycling</type><trkseg>` + pts.String() + `</trkseg></trk>
+</gpx>
+`
+	path := filepath.Join(dir, name+".gpx")
+	if err := ioutil.WriteFile(path, []byte(gpx), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// uploadStatuses returns a Progress function for Upload that records the
+// statuses reported for each row.
+func uploadStatuses(got map[int][]Status) func(*RowResult) {
+	return func(r *RowResult) { got[r.Row] = append(got[r.Row], r.Status) }
+}
+
+func TestUpload(t *testing.T) {
+	fake, client, done := newFakeClient(2)
+	defer done()
+	fake.ProcessingDelay = 500 * time.Millisecond
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "bulk-upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The seeded activities are at 07:30 on the days before testEnd, so
+	// these don't overlap them.
+	activities := []*UploadActivity{
+		NewUploadActivityForFile(writeGPX(t, dir, "Lunch Ride", testEnd.Add(-36*time.Hour), time.Hour), "gpx", ""),
+		NewUploadActivityForFile(writeGPX(t, dir, "Trail Ride", testEnd.Add(-12*time.Hour), time.Hour), "gpx", ""),
+	}
+	activities[1].SportType = "MountainBikeRide"
+	ledgerPath := filepath.Join(dir, "ledger.json")
+	led, err := LoadLedger(ledgerPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[int][]Status{}
+	results, err := client.Upload(ctx, activities, &UploadOptions{
+		Ledger:      led,
+		OnDuplicate: OnDuplicateFail,
+		Timeout:     time.Minute,
+		Progress:    uploadStatuses(got),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for row := 1; row <= 2; row++ {
+		if s := fmt.Sprint(got[row]); s != "[started submitted done]" {
+			t.Errorf("row %d: got progress %s, want [started submitted done]", row, s)
+		}
+	}
+	for _, r := range results {
+		if r.Status != StatusDone || r.ActivityID == 0 {
+			t.Fatalf("row %d: got status %q and activity %d, want done", r.Row, r.Status, r.ActivityID)
+		}
+		if u, ok := fake.Upload(r.UploadID); !ok || u.ActivityId != r.ActivityID {
+			t.Errorf("row %d: upload %d doesn't have activity %d", r.Row, r.UploadID, r.ActivityID)
+		}
+	}
+	a, sportType, ok := fake.Activity(results[0].ActivityID)
+	if !ok || a.Name != "Lunch Ride" || sportType != "Ride" {
+		t.Errorf("got activity %q with sport type %q, want Lunch Ride with Ride", a.Name, sportType)
+	}
+	if _, sportType, _ := fake.Activity(results[1].ActivityID); sportType != "MountainBikeRide" {
+		t.Errorf("got sport type %q, want MountainBikeRide", sportType)
+	}
+
+	// The ledger was saved, so a new one sees both files as uploaded.
+	led, err = LoadLedger(ledgerPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(led.Entries) != 2 || len(led.Pending()) != 0 {
+		t.Errorf("got %d ledger entries with %d pending, want 2 with none pending", len(led.Entries), len(led.Pending()))
+	}
+	results, err = client.Upload(ctx, activities, &UploadOptions{Ledger: led})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if r.Status != StatusSkipped || r.Details != "already uploaded" {
+			t.Errorf("row %d: got status %q (%s), want skipped as already uploaded", r.Row, r.Status, r.Details)
+		}
+	}
+}
+
+func TestUploadDuplicates(t *testing.T) {
+	_, client, done := newFakeClient(2)
+	defer done()
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "bulk-upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// This matches the most recent seeded activity, a 90-minute ride.
+	seeded := time.Date(testEnd.Year(), testEnd.Month(), testEnd.Day()-1, 7, 30, 0, 0, time.UTC)
+	activities := []*UploadActivity{
+		NewUploadActivityForFile(writeGPX(t, dir, "Morning Ride", seeded, 90*time.Minute), "gpx", ""),
+	}
+
+	results, err := client.Upload(ctx, activities, &UploadOptions{OnDuplicate: OnDuplicateFail})
+	if _, ok := err.(*RowError); !ok {
+		t.Fatalf("got error %v, want a *RowError", err)
+	}
+	if len(results) != 1 || results[0].Status != StatusFailed || len(results[0].Duplicates) != 1 {
+		t.Fatalf("got %+v, want a failed row with 1 duplicate", results)
+	}
+
+	results, err = client.Upload(ctx, activities, &UploadOptions{OnDuplicate: OnDuplicateFail, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Status != StatusDryRun || !strings.HasPrefix(results[0].Details, "would fail as a duplicate of ") {
+		t.Errorf("dry run: got status %q (%s)", results[0].Status, results[0].Details)
+	}
+
+	results, err = client.Upload(ctx, activities, &UploadOptions{OnDuplicate: OnDuplicateSkip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Status != StatusSkipped || !strings.HasPrefix(results[0].Details, "duplicate of ") {
+		t.Errorf("skip: got status %q (%s)", results[0].Status, results[0].Details)
+	}
+
+	// Uploaded anyway, Strava rejects it.
+	results, err = client.Upload(ctx, activities, &UploadOptions{OnDuplicate: OnDuplicateUpload, Timeout: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := results[0].Err.(*UploadError); !ok || results[0].Status != StatusFailed {
+		t.Errorf("upload: got status %q with error %v, want failed with an *UploadError", results[0].Status, results[0].Err)
+	}
+}
+
+func TestUploadResume(t *testing.T) {
+	fake := fakestrava.New()
+	fake.ProcessingDelay = 1500 * time.Millisecond
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+	client := NewClient(fakestrava.AccessToken, &ClientOptions{BaseURL: ts.URL})
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "bulk-upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	activities := []*UploadActivity{
+		NewUploadActivityForFile(writeGPX(t, dir, "Evening Ride", testEnd, time.Hour), "gpx", ""),
+	}
+	led, err := LoadLedger(filepath.Join(dir, "ledger.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Strava hasn't processed the upload by the time Upload gives up.
+	results, err := client.Upload(ctx, activities, &UploadOptions{Ledger: led})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := results[0]; r.Status != StatusSubmitted || r.Details != "still processing" {
+		t.Fatalf("got status %q (%s), want submitted and still processing", r.Status, r.Details)
+	}
+	if len(led.Pending()) != 1 {
+		t.Fatalf("got %d pending ledger entries, want 1", len(led.Pending()))
+	}
+	uploadID := results[0].UploadID
+
+	// Uploading again picks up the pending upload instead of resubmitting.
+	got := map[int][]Status{}
+	results, err = client.Upload(ctx, activities, &UploadOptions{Ledger: led, Timeout: time.Minute, Progress: uploadStatuses(got)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := fmt.Sprint(got[1]); s != "[submitted done]" {
+		t.Errorf("got progress %s, want [submitted done]", s)
+	}
+	if r := results[0]; r.Status != StatusDone || r.UploadID != uploadID {
+		t.Errorf("got status %q for upload %d, want done for upload %d", r.Status, r.UploadID, uploadID)
+	}
+	if n := len(fake.Activities()); n != 1 {
+		t.Errorf("got %d activities, want 1", n)
+	}
+}
+
+func TestSubmitCompress(t *testing.T) {
+	_, client, done := newFakeClient(0)
+	defer done()
+	dir, err := ioutil.TempDir("", "bulk-upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := writeGPX(t, dir, "Ride", testEnd, time.Hour)
+
+	tests := []struct {
+		desc string
+		opts *SubmitOptions
+		want bool
+	}{
+		{"nil options", nil, false},
+		{"zero options", &SubmitOptions{}, false},
+		{"Compress", &SubmitOptions{Compress: true}, true},
+		{"below CompressThreshold", &SubmitOptions{Compress: true, CompressThreshold: 1 << 20}, false},
+	}
+	for _, test := range tests {
+		r, err := client.Submit(context.Background(), NewUploadActivityForFile(path, "gpx", ""), test.opts)
+		if err != nil {
+			t.Fatalf("%s: %v", test.desc, err)
+		}
+		if got := strings.HasSuffix(r.Details, ", compressed"); got != test.want {
+			t.Errorf("%s: got details %q, want compressed %v", test.desc, r.Details, test.want)
+		}
+	}
+}
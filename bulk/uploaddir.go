@@ -0,0 +1,98 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package bulk
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FindActivityFiles returns an UploadActivity for each activity file in dir
+// (and its subdirectories, if recursive is true). The File Type is inferred
+// from the file extension, and the Name and Activity Type from the file's
+// metadata when possible; defaultType is used if the Activity Type can't be
+// inferred.
+func FindActivityFiles(dir string, recursive bool, defaultType string) ([]*UploadActivity, error) {
+	var activities []*UploadActivity
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != dir && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		fileType := FileTypeForPath(path)
+		if fileType == "" {
+			return nil
+		}
+		activities = append(activities, NewUploadActivityForFile(path, fileType, defaultType))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %q: %v", dir, err)
+	}
+	return activities, nil
+}
+
+// NewUploadActivityForFile returns an UploadActivity for the activity file
+// at path, inferring the Name and Activity Type from the file's contents
+// when possible.
+func NewUploadActivityForFile(path, fileType, defaultType string) *UploadActivity {
+	a := &UploadActivity{
+		ActivityType: defaultType,
+		Name:         nameFromFilename(path),
+		FileType:     fileType,
+		Filename:     path,
+	}
+	// Errors are reported when the activity is verified.
+	if summary, err := a.Summarize(); err == nil {
+		if summary.Name != "" {
+			a.Name = summary.Name
+		}
+		if activityType := SportToActivityType(summary.Sport); activityType != "" {
+			a.ActivityType = activityType
+		}
+	}
+	return a
+}
+
+// nameFromFilename returns a default activity name based on path; for
+// example, "Morning Ride" for "rides/morning_ride.fit.gz".
+func nameFromFilename(path string) string {
+	base := filepath.Base(path)
+	if i := strings.Index(base, "."); i > 0 {
+		base = base[:i]
+	}
+	words := strings.FieldsFunc(base, func(r rune) bool {
+		return r == '_' || r == '-' || r == ' '
+	})
+	for i, w := range words {
+		words[i] = strings.Title(w)
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,40 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package bulk
+
+import "testing"
+
+func TestNameFromFilename(t *testing.T) {
+	tests := []struct {
+		path, want string
+	}{
+		{"rides/morning_ride.fit.gz", "Morning Ride"},
+		{"Evening-Run.gpx", "Evening Run"},
+		{"2019-02-22 lunch swim.tcx", "2019 02 22 Lunch Swim"},
+	}
+	for _, test := range tests {
+		if got := nameFromFilename(test.path); got != test.want {
+			t.Errorf("nameFromFilename(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+}
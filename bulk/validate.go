@@ -0,0 +1,369 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package bulk
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gocarina/gocsv"
+)
+
+// Problem is a problem with a row of a .csv file, found by one of the
+// Validate functions.
+type Problem struct {
+	// Row is the row with the problem; row 0 is the header row.
+	Row int
+	// Column is the name of the column with the problem, or empty if the
+	// problem isn't with a single column.
+	Column string
+	// Err describes the problem.
+	Err error
+	// Warning is true if the value is suspicious, but not invalid.
+	Warning bool
+}
+
+func (p *Problem) Error() string {
+	kind := "error"
+	if p.Warning {
+		kind = "warning"
+	}
+	if p.Column == "" {
+		return fmt.Sprintf("row %d: %s: %v", p.Row, kind, p.Err)
+	}
+	return fmt.Sprintf("row %d, column %q: %s: %v", p.Row, p.Column, kind, p.Err)
+}
+
+// HasErrors returns true if any of problems aren't warnings.
+func HasErrors(problems []*Problem) bool {
+	return firstError(problems) != nil
+}
+
+// firstError returns the Err of the first of problems that isn't a warning,
+// or nil if there isn't one.
+func firstError(problems []*Problem) error {
+	for _, p := range problems {
+		if !p.Warning {
+			return p.Err
+		}
+	}
+	return nil
+}
+
+// ValidateOptions holds options for the Validate functions.
+type ValidateOptions struct {
+	// Now is used to warn about Start times in the future; the zero value
+	// means time.Now().
+	Now time.Time
+	// Location is the time zone for manual activity Start times without a
+	// UTC offset; nil means the local time zone.
+	Location *time.Location
+	// KnownGear, if not nil, holds the Gear IDs that are known to exist;
+	// others get a warning.
+	KnownGear map[string]bool
+	// StartRow skips rows before it; row 0 is the header row.
+	StartRow int
+	// Dialect is the .csv's dialect; nil means the default.
+	Dialect *Dialect
+}
+
+// ValidateActivities checks every row of an update .csv read from r against
+// orig, the activities in the .csv written by Download. Like Update, it
+// skips the values in rows that didn't change. It returns all of the
+// problems found; the error is only for a file that couldn't be read as a
+// .csv at all. opts may be nil.
+func ValidateActivities(r io.Reader, orig []*Activity, opts *ValidateOptions) ([]*Problem, error) {
+	opts = validateDefaults(opts)
+	prevByID := map[int64]*Activity{}
+	for _, a := range orig {
+		prevByID[a.ID] = a
+	}
+	if opts.KnownGear == nil {
+		opts.KnownGear = map[string]bool{}
+	}
+	for _, a := range orig {
+		// Gear that's already in use obviously exists.
+		if a.GearID != "" {
+			opts.KnownGear[a.GearID] = true
+		}
+	}
+	var activities []*Activity
+	t, problems, rows, err := decodeCSV(r, opts.Dialect, true, &activities, activityRequiredColumns, map[string]func(string) error{
+		"ID":       checkInt,
+		"Start":    checkTime,
+		"Commute?": checkBool,
+		"Trainer?": checkBool,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(t.rows) != len(orig) {
+		problems = append(problems, &Problem{Err: fmt.Errorf("original has %d activities, but updated has %d; for update, they should be the same", len(orig), len(t.rows))})
+	}
+	seen := map[int64]int{}
+	for i, a := range activities {
+		row := rows[i]
+		if prevRow, ok := seen[a.ID]; ok {
+			problems = append(problems, &Problem{Row: row, Column: "ID", Err: fmt.Errorf("duplicate activity ID %d; also on row %d", a.ID, prevRow)})
+			continue
+		}
+		seen[a.ID] = row
+		prev := prevByID[a.ID]
+		if prev == nil {
+			problems = append(problems, &Problem{Row: row, Column: "ID", Err: errors.New("activity ID not found in the original")})
+			continue
+		}
+		a.fillAbsent(prev)
+		if a.unchanged(prev) {
+			// Update skips unchanged rows, so they can't fail.
+			continue
+		}
+		problems = append(problems, inRow(row, a.check(prev, opts))...)
+	}
+	return skipRows(dedupe(problems), opts.StartRow), nil
+}
+
+// ValidateManualActivities checks every row of a .csv of manual activities
+// to upload read from r. It returns all of the problems found; the error is
+// only for a file that couldn't be read as a .csv at all. opts may be nil.
+func ValidateManualActivities(r io.Reader, opts *ValidateOptions) ([]*Problem, error) {
+	opts = validateDefaults(opts)
+	var activities []*ManualActivity
+	_, problems, rows, err := decodeCSV(r, opts.Dialect, false, &activities, manualRequiredColumns, map[string]func(string) error{
+		"Commute?": checkBool,
+		"Trainer?": checkBool,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, a := range activities {
+		a.Location = opts.Location
+		problems = append(problems, inRow(rows[i], a.check(opts))...)
+	}
+	return skipRows(dedupe(problems), opts.StartRow), nil
+}
+
+// ValidateUploadActivities checks every row of a .csv of activity files to
+// upload read from r. It returns all of the problems found; the error is
+// only for a file that couldn't be read as a .csv at all. opts may be nil.
+func ValidateUploadActivities(r io.Reader, opts *ValidateOptions) ([]*Problem, error) {
+	opts = validateDefaults(opts)
+	var activities []*UploadActivity
+	_, problems, rows, err := decodeCSV(r, opts.Dialect, false, &activities, uploadRequiredColumns, map[string]func(string) error{
+		"Commute?": checkBool,
+		"Trainer?": checkBool,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, a := range activities {
+		problems = append(problems, inRow(rows[i], a.check(opts))...)
+	}
+	return skipRows(dedupe(problems), opts.StartRow), nil
+}
+
+// validateDefaults returns a copy of opts with defaults filled in.
+func validateDefaults(opts *ValidateOptions) *ValidateOptions {
+	o := ValidateOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Now.IsZero() {
+		o.Now = time.Now()
+	}
+	return &o
+}
+
+// inRow sets the Row of problems to row, returning them.
+func inRow(row int, problems []*Problem) []*Problem {
+	for _, p := range problems {
+		p.Row = row
+	}
+	return problems
+}
+
+// dedupe returns problems without the later problems for the same row and
+// column; e.g., a cell that couldn't be parsed is blanked out, and may then
+// also be reported as missing.
+func dedupe(problems []*Problem) []*Problem {
+	type cell struct {
+		row    int
+		column string
+	}
+	seen := map[cell]bool{}
+	var kept []*Problem
+	for _, p := range problems {
+		if p.Column != "" {
+			c := cell{p.Row, p.Column}
+			if seen[c] {
+				continue
+			}
+			seen[c] = true
+		}
+		kept = append(kept, p)
+	}
+	return kept
+}
+
+// skipRows returns the problems that aren't in rows before startRow, sorted
+// by row; the header row is always kept.
+func skipRows(problems []*Problem, startRow int) []*Problem {
+	var kept []*Problem
+	for _, p := range problems {
+		if p.Row == 0 || p.Row >= startRow {
+			kept = append(kept, p)
+		}
+	}
+	sort.SliceStable(kept, func(i, j int) bool {
+		return kept[i].Row < kept[j].Row
+	})
+	return kept
+}
+
+// csvTable is a .csv split into its header and rows.
+type csvTable struct {
+	header []string
+	rows   [][]string
+}
+
+// decodeCSV reads a .csv in dialect d from r (see Dialect.readRecords for
+// startUTC), checks the header row against required (see columnProblems)
+// and the cells in the columns in checks, and decodes the rows into out, a
+// pointer to a slice of struct pointers, with the cells that failed their
+// checks blanked out. Rows with the wrong number of cells are skipped, and
+// no rows are decoded if required columns are missing. It returns the
+// problems found, and the row number of each decoded row.
+func decodeCSV(r io.Reader, d *Dialect, startUTC bool, out interface{}, required [][]string, checks map[string]func(string) error) (*csvTable, []*Problem, []int, error) {
+	records, cellProblems, err := d.readRecords(r, startUTC, false) // the # of fields is checked below
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil, nil, errors.New("empty .csv; it should at least have a header row")
+	}
+	t := &csvTable{header: records[0], rows: records[1:]}
+
+	problems := columnProblems(t.header, rowTypeOf(out), required)
+	if missingColumns(t.header, required) != nil {
+		return t, problems, nil, nil
+	}
+	problems = append(problems, cellProblems...)
+	var good [][]string
+	var rows []int
+	for i, record := range t.rows {
+		row := i + 1 // row 0 is the header row
+		if len(record) != len(t.header) {
+			problems = append(problems, &Problem{Row: row, Err: fmt.Errorf("has %d columns, but the header row has %d", len(record), len(t.header))})
+			continue
+		}
+		record = append([]string(nil), record...)
+		for col, name := range t.header {
+			if check := checks[name]; check != nil {
+				if err := check(record[col]); err != nil {
+					problems = append(problems, &Problem{Row: row, Column: name, Err: err})
+					record[col] = ""
+				}
+			}
+		}
+		good = append(good, record)
+		rows = append(rows, row)
+	}
+
+	// Let gocsv decode the rows, the same way that the Read functions do.
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(t.header)
+	w.WriteAll(good)
+	if err := gocsv.Unmarshal(&buf, out); err != nil {
+		return nil, nil, nil, err
+	}
+	markAbsent(out, t.header)
+	return t, problems, rows, nil
+}
+
+func checkInt(s string) error {
+	if _, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64); err != nil {
+		return fmt.Errorf("%q is not a whole number", s)
+	}
+	return nil
+}
+
+func checkTime(s string) error {
+	if _, err := time.Parse(time.RFC3339, s); err != nil {
+		return fmt.Errorf("%q is not an RFC 3339 time like \"2019-02-22T18:53:00Z\"", s)
+	}
+	return nil
+}
+
+// checkBool accepts the same values as gocsv.
+func checkBool(s string) error {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.EqualFold(s, "yes") || strings.EqualFold(s, "no") {
+		return nil
+	}
+	if _, err := strconv.ParseBool(s); err != nil {
+		return fmt.Errorf("%q is not true or false", s)
+	}
+	return nil
+}
+
+// typeProblems checks the Activity Type, Sport Type, and Workout Type
+// columns.
+func typeProblems(activityType, sportType string, workoutType WorkoutType) []*Problem {
+	if err := VerifyActivityType(activityType, sportType); err != nil {
+		column := "Activity Type"
+		if activityType == "" {
+			column = "Sport Type"
+		}
+		return []*Problem{{Column: column, Err: err}}
+	}
+	if _, err := workoutType.Value(EffectiveActivityType(activityType, sportType)); err != nil {
+		return []*Problem{{Column: "Workout Type", Err: err}}
+	}
+	return nil
+}
+
+// gearIDPattern matches Strava Gear IDs: "b" for bikes or "g" for shoes,
+// followed by a number.
+var gearIDPattern = regexp.MustCompile(`^[bg][0-9]+$`)
+
+// gearProblems warns about suspicious Gear IDs.
+func gearProblems(gearID string, opts *ValidateOptions) []*Problem {
+	switch {
+	case gearID == "":
+		return nil
+	case !gearIDPattern.MatchString(gearID):
+		return []*Problem{{Column: "Gear ID", Err: fmt.Errorf("%q doesn't look like a Gear ID, which start with \"b\" or \"g\" and a number", gearID), Warning: true}}
+	case opts.KnownGear != nil && !opts.KnownGear[gearID]:
+		return []*Problem{{Column: "Gear ID", Err: fmt.Errorf("unknown Gear ID %q", gearID), Warning: true}}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package bulk
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// problemSummaries returns the row, column, and kind of each of problems.
+func problemSummaries(problems []*Problem) []string {
+	var s []string
+	for _, p := range problems {
+		kind := "error"
+		if p.Warning {
+			kind = "warning"
+		}
+		s = append(s, fmt.Sprintf("%d %q %s", p.Row, p.Column, kind))
+	}
+	return s
+}
+
+func checkProblems(t *testing.T, problems []*Problem, want []string) {
+	t.Helper()
+	got := problemSummaries(problems)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got problems:\n%v\nwant:\n%s", problems, strings.Join(want, "\n"))
+	}
+}
+
+func TestValidateActivities(t *testing.T) {
+	orig := []*Activity{
+		// Strava uses 10 for some VirtualRides; it isn't valid, but the
+		// row is unchanged, so it isn't checked.
+		{ID: 1, ActivityType: "VirtualRide", Name: "A", WorkoutType: "10", GearID: "b1"},
+		{ID: 2, ActivityType: "Run", Name: "B", WorkoutType: "None", GearID: "g1"},
+		{ID: 3, ActivityType: "Run", Name: "C", WorkoutType: "None", GearID: "g1"},
+	}
+	const csv = `ID,Name,Workout Type,Gear ID,Commute?,Notes
+1,A,10,b1,false,
+2,B,Race,b9,maybe,
+2,C,None,g1,false,
+99,D,None,,false,
+`
+	problems, err := ValidateActivities(strings.NewReader(csv), orig, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkProblems(t, problems, []string{
+		`0 "Notes" warning`,
+		`0 "" error`, // 3 activities in the original, but 4 rows
+		`2 "Commute?" error`,
+		`2 "Gear ID" warning`, // b9 isn't known
+		`3 "ID" error`,        // duplicate
+		`4 "ID" error`,        // not in the original
+	})
+
+	problems, err = ValidateActivities(strings.NewReader(csv), orig, &ValidateOptions{StartRow: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkProblems(t, problems, []string{`0 "Notes" warning`, `0 "" error`, `3 "ID" error`, `4 "ID" error`})
+}
+
+func TestValidateManualActivities(t *testing.T) {
+	const csv = `Start,Activity Type,Name,Workout Type,Duration,Gear ID
+2019-02-22 18:53,Run,Treadmill,,45:00,g1
+2019-04-01 08:00,Ride,,,0,bike
+2019-02-23 08:00,Swim,Laps,Race,30:00,
+2019-02-24 08:00,Run
+`
+	problems, err := ValidateManualActivities(strings.NewReader(csv), &ValidateOptions{Now: testEnd, Location: time.UTC})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkProblems(t, problems, []string{
+		`2 "Start" warning`, // in the future
+		`2 "Name" error`,
+		`2 "Gear ID" warning`,
+		`2 "Duration" warning`,
+		`3 "Workout Type" error`,
+		`4 "" error`, // too few columns
+	})
+}
+
+func TestValidateMissingColumns(t *testing.T) {
+	problems, err := ValidateManualActivities(strings.NewReader("Name,Distance\nRun,5km\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkProblems(t, problems, []string{`0 "" error`})
+	if !strings.Contains(problems[0].Error(), `"Start"`) {
+		t.Errorf("got %v, want it to mention the missing Start column", problems[0])
+	}
+
+	if _, err := ValidateUploadActivities(strings.NewReader(""), nil); err == nil {
+		t.Error("got nil error for an empty .csv")
+	}
+}
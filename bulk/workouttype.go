@@ -0,0 +1,151 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package bulk
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// WorkoutType is the Workout Type column. It may be a name like "Race" or
+// "LongRun", or the raw Strava number for backward compatibility; names
+// depend on the Activity Type, so use value to interpret it. Empty means
+// the default ("None").
+type WorkoutType string
+
+const WorkoutTypeNone = "None"
+
+// workoutTypes maps Activity Types that support workout types to the names
+// and Strava numbers of those workout types. Other Activity Types only
+// support "None" (0).
+var workoutTypes = map[string]map[string]int{
+	"Run": {
+		WorkoutTypeNone: 0,
+		"Race":          1,
+		"LongRun":       2,
+		"Workout":       3,
+	},
+	"Ride": {
+		WorkoutTypeNone: 10,
+		"Race":          11,
+		"Workout":       12,
+	},
+}
+
+// Value returns the Strava number for w for an activity of type
+// activityType, or an error if w isn't valid for activityType. Numbers are
+// accepted unless they're another Activity Type's workout type (e.g., 11
+// for a Run).
+func (w WorkoutType) Value(activityType string) (int, error) {
+	s := strings.TrimSpace(string(w))
+	if s == "" {
+		return 0, nil
+	}
+	names := workoutTypes[activityType]
+	if n, err := strconv.Atoi(s); err == nil {
+		// Numbers are passed through for backward compatibility, and
+		// because Download writes numbers that don't have names here.
+		if owner := workoutTypeOwner(n); owner != "" && owner != activityType {
+			return 0, fmt.Errorf("invalid Workout Type %d for Activity Type %q; it's for %q%s", n, activityType, owner, workoutTypeHint(activityType))
+		}
+		return n, nil
+	}
+	// Accept names case-insensitively and ignoring spaces (e.g., "Long Run").
+	s = strings.Replace(s, " ", "", -1)
+	if strings.EqualFold(s, WorkoutTypeNone) || strings.EqualFold(s, "Default") {
+		if v, ok := names[WorkoutTypeNone]; ok {
+			return v, nil
+		}
+		return 0, nil
+	}
+	for name, v := range names {
+		if strings.EqualFold(s, name) {
+			return v, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid Workout Type %q for Activity Type %q%s", string(w), activityType, workoutTypeHint(activityType))
+}
+
+// workoutTypeOwner returns the Activity Type that the Strava number n is a
+// workout type for, or "" if there isn't one. 0 belongs to every type.
+func workoutTypeOwner(n int) string {
+	if n == 0 {
+		return ""
+	}
+	for activityType, names := range workoutTypes {
+		for _, v := range names {
+			if v == n {
+				return activityType
+			}
+		}
+	}
+	return ""
+}
+
+// WorkoutTypeNames returns the names of the workout types for activityType,
+// in order of their Strava numbers, starting with "None".
+func WorkoutTypeNames(activityType string) []string {
+	names := workoutTypes[activityType]
+	if len(names) == 0 {
+		return []string{WorkoutTypeNone}
+	}
+	var sorted []string
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return names[sorted[i]] < names[sorted[j]] })
+	return sorted
+}
+
+// workoutTypeHint returns a hint listing the valid workout types for
+// activityType.
+func workoutTypeHint(activityType string) string {
+	names := workoutTypes[activityType]
+	if len(names) == 0 {
+		return fmt.Sprintf(" (only %q is allowed)", WorkoutTypeNone)
+	}
+	var valid []string
+	for _, name := range WorkoutTypeNames(activityType) {
+		valid = append(valid, fmt.Sprintf("%q (%d)", name, names[name]))
+	}
+	return fmt.Sprintf(" (valid values are %s)", strings.Join(valid, ", "))
+}
+
+// WorkoutTypeFor returns the WorkoutType name for the Strava number n for an
+// activity of type activityType. Unknown numbers are returned as-is,
+// including 0 for a Ride, whose "None" is 10, so that an update writes
+// back the same number.
+func WorkoutTypeFor(activityType string, n int) WorkoutType {
+	names := workoutTypes[activityType]
+	if len(names) == 0 && n == 0 {
+		return WorkoutTypeNone
+	}
+	for name, v := range names {
+		if v == n {
+			return WorkoutType(name)
+		}
+	}
+	return WorkoutType(strconv.Itoa(n))
+}
@@ -0,0 +1,84 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package bulk
+
+import "testing"
+
+func TestWorkoutTypeValue(t *testing.T) {
+	tests := []struct {
+		workoutType  WorkoutType
+		activityType string
+		want         int
+		wantErr      bool
+	}{
+		{"", "Run", 0, false},
+		{"None", "Run", 0, false},
+		{"None", "Ride", 10, false},
+		{"none", "Swim", 0, false},
+		{"Long Run", "Run", 2, false},
+		{"race", "Ride", 11, false},
+		{"LongRun", "Ride", 0, true},
+		{"Race", "Swim", 0, true},
+		{"0", "Ride", 0, false},
+		{"2", "Run", 2, false},
+		{"12", "Ride", 12, false},
+		{"42", "Run", 42, false},
+		{"2", "Ride", 0, true},
+		{"11", "Run", 0, true},
+		{"10", "VirtualRide", 0, true},
+	}
+	for _, test := range tests {
+		got, err := test.workoutType.Value(test.activityType)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%q for %s: got error %v, want error %v", test.workoutType, test.activityType, err, test.wantErr)
+		} else if got != test.want {
+			t.Errorf("%q for %s: got %d, want %d", test.workoutType, test.activityType, got, test.want)
+		}
+	}
+}
+
+func TestWorkoutTypeFor(t *testing.T) {
+	tests := []struct {
+		activityType string
+		n            int
+		want         WorkoutType
+	}{
+		{"Run", 0, "None"},
+		{"Run", 2, "LongRun"},
+		{"Ride", 10, "None"},
+		{"Ride", 11, "Race"},
+		// A Ride's "None" is 10, so 0 must stay 0.
+		{"Ride", 0, "0"},
+		{"Swim", 0, "None"},
+		{"VirtualRide", 10, "10"},
+	}
+	for _, test := range tests {
+		got := WorkoutTypeFor(test.activityType, test.n)
+		if got != test.want {
+			t.Errorf("%s %d: got %q, want %q", test.activityType, test.n, got, test.want)
+		}
+		if n, err := got.Value(test.activityType); test.activityType != "VirtualRide" && (err != nil || n != test.n) {
+			t.Errorf("%s %d: %q doesn't round trip: got %d, %v", test.activityType, test.n, got, n, err)
+		}
+	}
+}
@@ -88,7 +88,7 @@ func doAuth(clientID, clientSecret string, port int, readOnly bool) error {
 		http.ListenAndServe(fmt.Sprintf("127.0.0.1:%d", port), nil)
 	}()
 
-	u, _ := url.Parse("https://www.strava.com/oauth/authorize")
+	u, _ := url.Parse(oauthURL("authorize"))
 	q := u.Query()
 	q.Add("client_id", clientID)
 	q.Add("redirect_uri", fmt.Sprintf("http://127.0.0.1:%d", port))
@@ -101,7 +101,7 @@ func doAuth(clientID, clientSecret string, port int, readOnly bool) error {
 	u.RawQuery = q.Encode()
 	urlstr := u.String()
 
-	fmt.Printf("Pointing your browser to %s. If it doesn't work, please copy the URL and paste it into your browser.\n", urlstr)
+	printf("Pointing your browser to %s. If it doesn't work, please copy the URL and paste it into your browser.\n", urlstr)
 	if err := open.Start(urlstr); err != nil {
 		return err
 	}
@@ -113,31 +113,47 @@ func doAuth(clientID, clientSecret string, port int, readOnly bool) error {
 	}
 	log.Printf("got code %s", res.code)
 
+	m, err := exchangeCode(clientID, clientSecret, res.code)
+	if err != nil {
+		return err
+	}
+	if athlete, ok := m["athlete"].(map[string]interface{}); ok {
+		printf("Hello, %s %s!\n", athlete["firstname"], athlete["lastname"])
+	}
+	if jsonOutput() {
+		recordResult("access_token", m["access_token"])
+		return nil
+	}
+	fmt.Printf("Your Strava access token is: %s\n", m["access_token"])
+	return nil
+}
+
+// exchangeCode exchanges an authorization code for an access token, and
+// returns the decoded response.
+func exchangeCode(clientID, clientSecret, code string) (map[string]interface{}, error) {
 	form := url.Values{}
 	form.Set("client_id", clientID)
 	form.Set("client_secret", clientSecret)
-	form.Set("code", res.code)
-	resp, err := http.PostForm("https://www.strava.com/oauth/token", form)
+	form.Set("code", code)
+	resp, err := httpClient().PostForm(oauthURL("token"), form)
 	if err != nil {
-		return fmt.Errorf("authentication failed at POST: %v", err)
+		return nil, fmt.Errorf("authentication failed at POST: %v", err)
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("authentication failed at POST, status code %d", resp.StatusCode)
+		return nil, fmt.Errorf("authentication failed at POST, status code %d", resp.StatusCode)
 	}
 	body, err := ioutil.ReadAll(resp.Body)
-	defer resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("authentication failed reading POST response: %v", err)
+	}
 	log.Printf("POST body: %s", string(body))
 	m := map[string]interface{}{}
 	if err := json.Unmarshal(body, &m); err != nil {
-		return fmt.Errorf("authentication failed, POST response was not JSON: %v", err)
+		return nil, fmt.Errorf("authentication failed, POST response was not JSON: %v", err)
 	}
-	accessToken := m["access_token"]
-	if accessToken == "" {
-		return fmt.Errorf("authentication failed, no access token received: %v", m)
+	if accessToken, _ := m["access_token"].(string); accessToken == "" {
+		return nil, fmt.Errorf("authentication failed, no access token received: %v", m)
 	}
-	if athlete, ok := m["athlete"].(map[string]interface{}); ok {
-		fmt.Printf("Hello, %s %s!\n", athlete["firstname"], athlete["lastname"])
-	}
-	fmt.Printf("Your Strava access token is: %s\n", m["access_token"])
-	return nil
+	return m, nil
 }
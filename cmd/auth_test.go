@@ -0,0 +1,76 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/vangent/stravacli/fakestrava"
+)
+
+func TestExchangeCode(t *testing.T) {
+	ts := httptest.NewServer(fakestrava.New())
+	defer ts.Close()
+	defer func(prev string) { apiBase = prev }(apiBase)
+	apiBase = ts.URL
+
+	// Authorize, and get the code from the redirect back to doAuth's local
+	// server, without following it.
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(oauthURL("authorize") + "?client_id=1&redirect_uri=" + url.QueryEscape("http://127.0.0.1:1/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := loc.Query().Get("code")
+	if code == "" {
+		t.Fatalf("redirect to %q has no code", loc)
+	}
+
+	m, err := exchangeCode("1", "secret", code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token, _ := m["access_token"].(string); !strings.HasPrefix(token, fakestrava.AccessToken+"-") {
+		t.Errorf("got access token %q, want one starting with %q", token, fakestrava.AccessToken+"-")
+	}
+	if athlete, _ := m["athlete"].(map[string]interface{}); athlete["firstname"] != "Fake" {
+		t.Errorf("got athlete %v, want Fake", m["athlete"])
+	}
+
+	// Codes can only be used once.
+	if _, err := exchangeCode("1", "secret", code); err == nil {
+		t.Error("reusing the code: got nil error, want one")
+	}
+	if _, err := exchangeCode("1", "secret", "bogus"); err == nil {
+		t.Error("bogus code: got nil error, want one")
+	}
+}
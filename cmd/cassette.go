@@ -0,0 +1,332 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// recordDir and replayDir are set by the --record and --replay flags.
+var (
+	recordDir string
+	replayDir string
+)
+
+// httpTransport is used for all HTTP requests to Strava; see
+// setupHTTPTransport.
+var httpTransport http.RoundTripper = http.DefaultTransport
+
+// redacted replaces secrets in recorded cassettes.
+const redacted = "REDACTED"
+
+// sensitiveParams are the query, form, and JSON parameters that are
+// redacted from cassettes.
+var sensitiveParams = map[string]bool{
+	"access_token":  true,
+	"refresh_token": true,
+	"client_secret": true,
+	"code":          true,
+}
+
+// sensitiveJSON matches sensitiveParams in JSON bodies.
+var sensitiveJSON = regexp.MustCompile(`"(access_token|refresh_token|client_secret|code)"(\s*):(\s*)"[^"]*"`)
+
+// setupHTTPTransport sets httpTransport based on the --record and --replay
+// flags.
+func setupHTTPTransport() error {
+	switch {
+	case recordDir != "" && replayDir != "":
+		return errors.New("only one of --record and --replay may be used")
+	case recordDir != "":
+		rec, err := newCassetteRecorder(recordDir, http.DefaultTransport)
+		if err != nil {
+			return err
+		}
+		httpTransport = rec
+	case replayDir != "":
+		rep, err := loadCassette(replayDir)
+		if err != nil {
+			return err
+		}
+		httpTransport = rep
+	}
+	httpTransport = loggingTransport{httpTransport}
+	return nil
+}
+
+// httpClient returns the HTTP client to use for requests to Strava.
+func httpClient() *http.Client {
+	return &http.Client{Transport: httpTransport}
+}
+
+// loggingTransport logs requests for --debug, and records the status of
+// each response for exitCode.
+type loggingTransport struct {
+	next http.RoundTripper
+}
+
+func (t loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		log.Printf("%s %s: %v", req.Method, redactURL(req.URL), err)
+		return nil, err
+	}
+	noteHTTPStatus(resp.StatusCode)
+	log.Printf("%s %s: %s (rate limit usage %s of %s)", req.Method, redactURL(req.URL), resp.Status, resp.Header.Get("X-RateLimit-Usage"), resp.Header.Get("X-RateLimit-Limit"))
+	return resp, nil
+}
+
+// cassetteInteraction is a recorded HTTP request and its response.
+type cassetteInteraction struct {
+	Request  cassetteMessage `json:"request"`
+	Response cassetteMessage `json:"response"`
+}
+
+// cassetteMessage is a recorded HTTP request or response. Bodies that
+// aren't valid UTF-8 are base64-encoded.
+type cassetteMessage struct {
+	Method     string      `json:"method,omitempty"`
+	URL        string      `json:"url,omitempty"`
+	StatusCode int         `json:"status_code,omitempty"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+	BodyBase64 bool        `json:"body_base64,omitempty"`
+}
+
+func (m *cassetteMessage) setBody(b []byte) {
+	if utf8.Valid(b) {
+		m.Body = string(b)
+		return
+	}
+	m.Body = base64.StdEncoding.EncodeToString(b)
+	m.BodyBase64 = true
+}
+
+func (m *cassetteMessage) body() ([]byte, error) {
+	if m.BodyBase64 {
+		return base64.StdEncoding.DecodeString(m.Body)
+	}
+	return []byte(m.Body), nil
+}
+
+// cassetteFilePattern is the pattern for interaction files in a cassette
+// directory; they're numbered in the order they happened.
+const cassetteFilePattern = "interaction-*.json"
+
+// cassetteRecorder is an http.RoundTripper that records each interaction in
+// a directory, with secrets redacted.
+type cassetteRecorder struct {
+	dir  string
+	next http.RoundTripper
+
+	mu sync.Mutex
+	n  int
+}
+
+func newCassetteRecorder(dir string, next http.RoundTripper) (*cassetteRecorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create --record directory: %v", err)
+	}
+	if existing, _ := filepath.Glob(filepath.Join(dir, cassetteFilePattern)); len(existing) > 0 {
+		return nil, fmt.Errorf("--record directory %q already contains a recording; use an empty directory", dir)
+	}
+	return &cassetteRecorder{dir: dir, next: next}, nil
+}
+
+func (r *cassetteRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		if reqBody, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+	}
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+	in := &cassetteInteraction{
+		Request: cassetteMessage{
+			Method: req.Method,
+			URL:    redactURL(req.URL),
+			Header: redactHeader(req.Header),
+		},
+		Response: cassetteMessage{
+			StatusCode: resp.StatusCode,
+			Header:     resp.Header,
+		},
+	}
+	in.Request.setBody(redactBody(req.Header.Get("Content-Type"), reqBody))
+	in.Response.setBody(redactBody(resp.Header.Get("Content-Type"), respBody))
+	b, err := json.MarshalIndent(in, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.n++
+	filename := filepath.Join(r.dir, strings.Replace(cassetteFilePattern, "*", fmt.Sprintf("%04d", r.n), 1))
+	if err := ioutil.WriteFile(filename, b, 0644); err != nil {
+		return nil, fmt.Errorf("failed to record to %q: %v", filename, err)
+	}
+	return resp, nil
+}
+
+// cassetteReplayer is an http.RoundTripper that serves recorded
+// interactions. Each request gets the response from the first unused
+// interaction with the same method and URL.
+type cassetteReplayer struct {
+	mu           sync.Mutex
+	interactions []*cassetteInteraction
+	used         []bool
+}
+
+func loadCassette(dir string) (*cassetteReplayer, error) {
+	filenames, err := filepath.Glob(filepath.Join(dir, cassetteFilePattern))
+	if err != nil {
+		return nil, err
+	}
+	if len(filenames) == 0 {
+		return nil, fmt.Errorf("--replay directory %q doesn't contain a recording", dir)
+	}
+	sort.Strings(filenames)
+	r := &cassetteReplayer{}
+	for _, filename := range filenames {
+		b, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		in := &cassetteInteraction{}
+		if err := json.Unmarshal(b, in); err != nil {
+			return nil, fmt.Errorf("failed to parse %q: %v", filename, err)
+		}
+		r.interactions = append(r.interactions, in)
+	}
+	r.used = make([]bool, len(r.interactions))
+	return r, nil
+}
+
+func (r *cassetteReplayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	u := redactURL(req.URL)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, in := range r.interactions {
+		if r.used[i] || in.Request.Method != req.Method || in.Request.URL != u {
+			continue
+		}
+		r.used[i] = true
+		body, err := in.Response.body()
+		if err != nil {
+			return nil, err
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", in.Response.StatusCode, http.StatusText(in.Response.StatusCode)),
+			StatusCode:    in.Response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        in.Response.Header,
+			Body:          ioutil.NopCloser(bytes.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("no recorded response for %s %s", req.Method, u)
+}
+
+// redactURL returns u as a string, with sensitiveParams redacted from the
+// query.
+func redactURL(u *url.URL) string {
+	c := *u
+	c.RawQuery = redactValues(u.Query()).Encode()
+	return c.String()
+}
+
+// redactValues returns a copy of v with sensitiveParams redacted.
+func redactValues(v url.Values) url.Values {
+	c := url.Values{}
+	for key, values := range v {
+		for _, value := range values {
+			if sensitiveParams[key] {
+				value = redacted
+			}
+			c.Add(key, value)
+		}
+	}
+	return c
+}
+
+// redactHeader returns a copy of h with credentials redacted.
+func redactHeader(h http.Header) http.Header {
+	c := http.Header{}
+	for key, values := range h {
+		c[key] = values
+	}
+	if c.Get("Authorization") != "" {
+		c.Set("Authorization", "Bearer "+redacted)
+	}
+	return c
+}
+
+// redactBody returns body with sensitiveParams redacted, for form and JSON
+// bodies.
+func redactBody(contentType string, body []byte) []byte {
+	switch {
+	case strings.HasPrefix(contentType, "application/x-www-form-urlencoded"):
+		v, err := url.ParseQuery(string(body))
+		if err != nil {
+			return body
+		}
+		return []byte(redactValues(v).Encode())
+	case strings.HasPrefix(contentType, "application/json"):
+		return sensitiveJSON.ReplaceAll(body, []byte(`"$1"$2:$3"`+redacted+`"`))
+	}
+	return body
+}
@@ -0,0 +1,65 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/vangent/stravacli/bulk"
+)
+
+// defaultAPIBase is the base URL for Strava.
+const defaultAPIBase = bulk.DefaultBaseURL
+
+// apiBase is the base URL for Strava's API and OAuth endpoints, and for
+// activity links; it is set by the hidden --api_base flag (e.g., to point
+// at a fakestrava server).
+var apiBase = defaultAPIBase
+
+// newClient returns a client for the Strava API that authenticates with
+// accessToken, and a context carrying accessToken for calls made directly
+// with client.API().
+func newClient(accessToken string) (context.Context, *bulk.Client) {
+	client := bulk.NewClient(accessToken, &bulk.ClientOptions{
+		BaseURL:    baseURL(),
+		HTTPClient: httpClient(),
+	})
+	return client.Context(cmdCtx), client
+}
+
+// baseURL returns apiBase without a trailing slash.
+func baseURL() string {
+	return strings.TrimSuffix(apiBase, "/")
+}
+
+// oauthURL returns the URL for the OAuth endpoint endpoint (e.g., "token").
+func oauthURL(endpoint string) string {
+	return baseURL() + "/oauth/" + endpoint
+}
+
+// activityURL returns the web URL for the activity id.
+func activityURL(id int64) string {
+	return fmt.Sprintf("%s/activities/%d", baseURL(), id)
+}
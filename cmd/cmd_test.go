@@ -0,0 +1,285 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/vangent/stravacli/fakestrava"
+)
+
+// testConfigFile is the configuration file used by runCommand, via
+// STRAVACLI_CONFIG; tests that write it should remove it when done.
+var testConfigFile string
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "stravacli-cmd")
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Don't pick up the environment or configuration of whoever runs the
+	// tests.
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, envPrefix) {
+			os.Unsetenv(kv[:strings.Index(kv, "=")])
+		}
+	}
+	log.SetOutput(ioutil.Discard)
+	testConfigFile = filepath.Join(dir, "config.json")
+	os.Setenv(flagEnvVar("config"), testConfigFile)
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+var markRunStartedOnce sync.Once
+
+// runCommand runs stravacli with args like Execute does, but without
+// exiting, and returns what it wrote to stdout. Flags and other global
+// state are reset first, except for repeatable flags like schedule's
+// --rule, which pflag can't reset; only one test may set each of them.
+func runCommand(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	markRunStartedOnce.Do(func() { markRunStarted(rootCmd) })
+	for _, c := range allCommands(rootCmd) {
+		for _, fs := range []*pflag.FlagSet{c.Flags(), c.PersistentFlags()} {
+			fs.VisitAll(func(f *pflag.Flag) {
+				if f.Value.Type() != "stringArray" {
+					f.Value.Set(f.DefValue)
+				}
+				f.Changed = false
+			})
+		}
+	}
+	log.SetOutput(ioutil.Discard)
+	runStarted = false
+	interruptCount = 0
+	cmdCtx = context.Background()
+	httpTransport = http.DefaultTransport
+	httpStatuses = map[int]bool{}
+	results = map[string]interface{}{}
+	completedRows = map[int]bool{}
+
+	f, err := ioutil.TempFile("", "stravacli-stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	stdout := os.Stdout
+	os.Stdout = f
+	rootCmd.SetArgs(args)
+	err = rootCmd.Execute()
+	os.Stdout = stdout
+	out, rerr := ioutil.ReadFile(f.Name())
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	return string(out), err
+}
+
+// testEnd is the end of the fake athlete's seeded activities.
+var testEnd = time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC)
+
+// newFakeStrava returns a fake Strava server seeded with n activities (see
+// fakestrava.Server.Seed), and the flags to use it. Call the returned
+// function to shut the server down.
+func newFakeStrava(n int) (*fakestrava.Server, []string, func()) {
+	fake := fakestrava.New()
+	fake.Seed(n, testEnd)
+	ts := httptest.NewServer(fake)
+	return fake, []string{"--api_base", ts.URL, "--access_token", fakestrava.AccessToken}, ts.Close
+}
+
+// tempDir returns a new temporary directory; call the returned function to
+// remove it.
+func tempDir(t *testing.T) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "stravacli-cmd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// writeFile writes s to dir/name and returns its path.
+func writeFile(t *testing.T, dir, name, s string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(s), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDownloadUpdateCommands(t *testing.T) {
+	fake, flags, done := newFakeStrava(3)
+	defer done()
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	orig := filepath.Join(dir, "orig.csv")
+	out, err := runCommand(t, append([]string{"download", "--out", orig}, flags...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "Downloaded 3 activities") {
+		t.Errorf("got output %q", out)
+	}
+	b, err := ioutil.ReadFile(orig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The second activity is the only run.
+	updated := writeFile(t, dir, "updated.csv", strings.Replace(string(b), "Morning Run", "Tempo Run", 1))
+
+	if _, err := runCommand(t, append([]string{"update", "--orig", orig, "--updated", updated, "--dryrun"}, flags...)...); err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range fake.Activities() {
+		if a.Name == "Tempo Run" {
+			t.Fatal("--dryrun renamed an activity")
+		}
+	}
+	out, err = runCommand(t, append([]string{"update", "--orig", orig, "--updated", updated}, flags...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var renamed int
+	for _, a := range fake.Activities() {
+		if a.Name == "Tempo Run" {
+			renamed++
+		}
+	}
+	if renamed != 1 {
+		t.Errorf("%d activities were renamed, want 1; output:\n%s", renamed, out)
+	}
+}
+
+func TestUploadManualCommand(t *testing.T) {
+	fake, flags, done := newFakeStrava(0)
+	defer done()
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	in := writeFile(t, dir, "manual.csv", `Start,Activity Type,Name,Duration,Distance
+2019-02-22 18:53,Run,Treadmill,45:00,10km
+2019-02-23 08:00,Ride,Trainer,1h,30km
+`)
+	if _, err := runCommand(t, append([]string{"uploadmanual", "--in", in, "--tz", "America/New_York"}, flags...)...); err != nil {
+		t.Fatal(err)
+	}
+	activities := fake.Activities()
+	if len(activities) != 2 {
+		t.Fatalf("got %d activities, want 2", len(activities))
+	}
+	want := time.Date(2019, 2, 22, 23, 53, 0, 0, time.UTC)
+	for _, a := range activities {
+		if a.Name == "Treadmill" && !a.StartDate.Equal(want) {
+			t.Errorf("got start %v, want %v", a.StartDate, want)
+		}
+	}
+
+	// Rows that fail validation stop the upload, with exitInvalid.
+	in = writeFile(t, dir, "bad.csv", "Start,Activity Type,Name,Duration\n2019-02-24 08:00,Ride,Bad,forever\n")
+	_, err := runCommand(t, append([]string{"uploadmanual", "--in", in, "--tz", "UTC"}, flags...)...)
+	if code := exitCode(err); code != exitInvalid {
+		t.Errorf("got exit code %d (%v), want %d", code, err, exitInvalid)
+	}
+	if n := len(fake.Activities()); n != 2 {
+		t.Errorf("got %d activities, want 2", n)
+	}
+}
+
+func TestUploadDirCommand(t *testing.T) {
+	fake, flags, done := newFakeStrava(0)
+	defer done()
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	files := filepath.Join(dir, "files")
+	if err := os.Mkdir(files, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, files, "evening_ride.gpx", testGPX)
+	ledger := filepath.Join(dir, "ledger.json")
+	args := append([]string{"upload", "--dir", files, "--ledger", ledger, "--type", "Ride"}, flags...)
+	out, err := runCommand(t, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "Uploaded 1 activities.") {
+		t.Errorf("got output %q", out)
+	}
+	activities := fake.Activities()
+	if len(activities) != 1 || activities[0].Name != "Evening Ride" {
+		t.Fatalf("got activities %+v, want an Evening Ride", activities)
+	}
+
+	// The ledger shows that it was uploaded, so it's skipped.
+	out, err = runCommand(t, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "already uploaded") || len(fake.Activities()) != 1 {
+		t.Errorf("second upload: got output %q", out)
+	}
+
+	// Without the ledger, it's found to be a duplicate.
+	_, err = runCommand(t, append([]string{"upload", "--dir", files, "--ledger", ""}, flags...)...)
+	if err == nil || !strings.Contains(err.Error(), "--on_duplicate") {
+		t.Errorf("got error %v, want a duplicate", err)
+	}
+}
+
+func TestBadAccessTokenExitCode(t *testing.T) {
+	_, flags, done := newFakeStrava(1)
+	defer done()
+
+	_, err := runCommand(t, "download", "--api_base", flags[1], "--access_token", "bad", "--out", os.DevNull)
+	if code := exitCode(err); code != exitAuth {
+		t.Errorf("got exit code %d (%v), want %d", code, err, exitAuth)
+	}
+}
+
+// testGPX is a short ride.
+const testGPX = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1">
+<trk><trkseg>
+<trkpt lat="47.60" lon="-122.30"><time>2019-02-27T18:00:00Z</time></trkpt>
+<trkpt lat="47.61" lon="-122.30"><time>2019-02-27T18:10:00Z</time></trkpt>
+<trkpt lat="47.62" lon="-122.30"><time>2019-02-27T18:20:00Z</time></trkpt>
+</trkseg></trk>
+</gpx>
+`
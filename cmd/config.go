@@ -0,0 +1,515 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// skipFlagDefaults is a cobra annotation for commands whose flags aren't
+// set from the environment or configuration file.
+const skipFlagDefaults = "skip_flag_defaults"
+
+func init() {
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "Get, set, or list default flag values",
+		Long: `Get, set, or list default flag values.
+
+Any flag that isn't given on the command line is taken from a STRAVACLI_*
+environment variable named after it (e.g., STRAVACLI_ACCESS_TOKEN for
+--access_token), or from the "flags" section of the configuration file (see
+--config). The precedence is:
+
+  1. The command line.
+  2. The environment variable.
+  3. The configuration file, for the command (e.g., "upload.ledger").
+  4. The configuration file, for all commands (e.g., "ledger").
+  5. The flag's built-in default.
+
+Keys are flag names, optionally prefixed by the command name and a "." to
+only apply to that command; for subcommands, the names are joined by "."
+(e.g., "uploads.status.ledger"). STRAVACLI_CONFIG sets --config.`,
+		Annotations: map[string]string{skipFlagDefaults: "true"},
+	}
+
+	configListCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the flag values set in the environment and configuration file",
+		Long:  `List the flag values set in the environment and configuration file.`,
+		Args:  cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			return doConfigList()
+		},
+	}
+	configGetCmd := &cobra.Command{
+		Use:   "get KEY",
+		Short: "Print the default value for a flag",
+		Long: `Print the default value for a flag, from the environment or the
+configuration file, one value per line.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			return doConfigGet(args[0])
+		},
+	}
+	configSetCmd := &cobra.Command{
+		Use:   "set KEY VALUE...",
+		Short: "Set the default value for a flag in the configuration file",
+		Long: `Set the default value for a flag in the configuration file.
+
+Give more than one value for flags that can be repeated, like schedule's
+--rule.`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: func(_ *cobra.Command, args []string) error {
+			return doConfigSet(args[0], args[1:])
+		},
+	}
+	configUnsetCmd := &cobra.Command{
+		Use:   "unset KEY",
+		Short: "Remove the default value for a flag from the configuration file",
+		Long:  `Remove the default value for a flag from the configuration file.`,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			return doConfigSet(args[0], nil)
+		},
+	}
+	configCmd.AddCommand(configListCmd, configGetCmd, configSetCmd, configUnsetCmd)
+	rootCmd.AddCommand(configCmd)
+}
+
+// configFile is the path to the configuration file; set via --config.
+var configFile string
+
+// config holds the settings stored in the configuration file.
+type config struct {
+	// Flags holds default flag values, keyed by flag name or
+	// "command.flag"; see applyFlagDefaults. Values are strings, numbers,
+	// booleans, or lists of them for repeatable flags.
+	Flags map[string]interface{} `json:"flags,omitempty"`
+	// Gear holds per-gear settings, keyed by Gear ID (e.g., "g3880367").
+	Gear map[string]*gearConfig `json:"gear,omitempty"`
+	// Watch holds the defaults for the watch command.
+	Watch *watchConfig `json:"watch,omitempty"`
+}
+
+// gearConfig holds the service intervals for a single piece of gear.
+// Zero values mean "no limit".
+type gearConfig struct {
+	// Name is used in reports if Strava doesn't know the gear.
+	Name string `json:"name,omitempty"`
+	// ServiceDistanceKm is the distance, in kilometers, between services.
+	ServiceDistanceKm float64 `json:"service_distance_km,omitempty"`
+	// ServiceHours is the moving time, in hours, between services.
+	ServiceHours float64 `json:"service_hours,omitempty"`
+	// ServiceActivities is the # of activities between services.
+	ServiceActivities int `json:"service_activities,omitempty"`
+	// LastService is the date of the last service (YYYY-MM-DD); activities
+	// before it don't count towards the service intervals.
+	LastService string `json:"last_service,omitempty"`
+	// RetireDistanceKm is the total distance, in kilometers, after which the
+	// gear should be retired.
+	RetireDistanceKm float64 `json:"retire_distance_km,omitempty"`
+}
+
+// defaultConfigFile returns the default path for the configuration file,
+// following the XDG convention on Linux (e.g.,
+// ~/.config/stravacli/config.json).
+func defaultConfigFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "stravacli", "config.json")
+}
+
+// loadConfig reads the configuration file. A missing file is not an error;
+// it results in an empty config.
+func loadConfig() (*config, error) {
+	cfg := &config{}
+	if configFile == "" {
+		return cfg, nil
+	}
+	b, err := ioutil.ReadFile(configFile)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %q: %v", configFile, err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber() // so that int flags don't round-trip through float64
+	if err := dec.Decode(cfg); err != nil {
+		return nil, invalidInput(fmt.Errorf("failed to parse config file %q: %v", configFile, err))
+	}
+	return cfg, nil
+}
+
+// saveConfig writes cfg to the configuration file.
+func saveConfig(cfg *config) error {
+	if configFile == "" {
+		return invalidInput(errors.New("no configuration file; use --config"))
+	}
+	b, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(configFile), 0700); err != nil {
+		return fmt.Errorf("failed to create directory for config file %q: %v", configFile, err)
+	}
+	if err := ioutil.WriteFile(configFile, append(b, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to write config file %q: %v", configFile, err)
+	}
+	return nil
+}
+
+// envPrefix is the prefix for environment variables that set flags.
+const envPrefix = "STRAVACLI_"
+
+// flagEnvVar returns the environment variable for the flag name; e.g.,
+// STRAVACLI_ACCESS_TOKEN for access_token.
+func flagEnvVar(name string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// commandKey returns the prefix for c's flags in the "flags" section of the
+// configuration file; e.g., "uploads.status".
+func commandKey(c *cobra.Command) string {
+	return strings.Join(strings.Fields(c.CommandPath())[1:], ".")
+}
+
+// flagDefault returns the default value for the flag name of the command
+// with commandKey cmdKey, from the environment or cfg, and where it came
+// from. ok is false if there isn't one.
+func (cfg *config) flagDefault(cmdKey, name string) (values []string, source string, ok bool, err error) {
+	if v, ok := os.LookupEnv(flagEnvVar(name)); ok {
+		return []string{v}, "environment variable " + flagEnvVar(name), true, nil
+	}
+	keys := []string{name}
+	if cmdKey != "" {
+		keys = []string{cmdKey + "." + name, name}
+	}
+	for _, key := range keys {
+		if v, ok := cfg.Flags[key]; ok {
+			source = fmt.Sprintf("%q in config file %q", key, configFile)
+			values, err := configValues(v)
+			if err != nil {
+				return nil, source, true, fmt.Errorf("invalid value for %s: %v", source, err)
+			}
+			return values, source, true, nil
+		}
+	}
+	return nil, "", false, nil
+}
+
+// configValues converts v, a value from the "flags" section of the
+// configuration file, into strings to pass to pflag.Value.Set.
+func configValues(v interface{}) ([]string, error) {
+	switch v := v.(type) {
+	case string:
+		return []string{v}, nil
+	case json.Number:
+		return []string{v.String()}, nil
+	case bool:
+		return []string{strconv.FormatBool(v)}, nil
+	case []interface{}:
+		var values []string
+		for _, elem := range v {
+			if _, isList := elem.([]interface{}); isList {
+				return nil, errors.New("lists can't be nested")
+			}
+			elemValues, err := configValues(elem)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, elemValues...)
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("%v should be a string, number, boolean, or list", v)
+}
+
+// applyFlagDefaults sets the flags for c that weren't given on the command
+// line from the environment or the configuration file; see the config
+// command for the precedence. It returns a message for each flag that was
+// set, to be logged once --debug (which may itself be set this way) has
+// taken effect.
+func applyFlagDefaults(c *cobra.Command) ([]string, error) {
+	if f := c.Flags().Lookup("config"); f != nil && !f.Changed {
+		if v, ok := os.LookupEnv(flagEnvVar("config")); ok {
+			configFile = v
+		}
+	}
+	if c.Annotations[skipFlagDefaults] != "" || (c.HasParent() && c.Parent().Annotations[skipFlagDefaults] != "") {
+		return nil, nil
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	var messages []string
+	cmdKey := commandKey(c)
+	c.Flags().VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed || f.Name == "help" || f.Name == "config" {
+			return
+		}
+		values, source, ok, ferr := cfg.flagDefault(cmdKey, f.Name)
+		if ferr != nil {
+			err = invalidInput(ferr)
+			return
+		}
+		if !ok {
+			return
+		}
+		messages = append(messages, fmt.Sprintf("setting --%s from %s", f.Name, source))
+		for _, v := range values {
+			if serr := c.Flags().Set(f.Name, v); serr != nil {
+				err = invalidInput(fmt.Errorf("invalid value %q for --%s from %s: %v", v, f.Name, source, serr))
+				return
+			}
+		}
+	})
+	return messages, err
+}
+
+// lookupFlagKey returns a flag for key, a key in the "flags" section of the
+// configuration file.
+func lookupFlagKey(key string) (*pflag.Flag, error) {
+	name := key
+	cmds := allCommands(rootCmd)
+	if i := strings.LastIndex(key, "."); i >= 0 {
+		var c *cobra.Command
+		for _, cmd := range cmds {
+			if commandKey(cmd) == key[:i] {
+				c = cmd
+				break
+			}
+		}
+		if c == nil {
+			return nil, invalidInput(fmt.Errorf("invalid key %q: unknown command %q", key, key[:i]))
+		}
+		cmds, name = []*cobra.Command{c}, key[i+1:]
+	}
+	for _, c := range cmds {
+		if f := c.Flags().Lookup(name); f != nil {
+			return f, nil
+		}
+		if f := c.InheritedFlags().Lookup(name); f != nil {
+			return f, nil
+		}
+	}
+	return nil, invalidInput(fmt.Errorf("invalid key %q: no such flag", key))
+}
+
+// allCommands returns c and all of its subcommands.
+func allCommands(c *cobra.Command) []*cobra.Command {
+	cmds := []*cobra.Command{c}
+	for _, sub := range c.Commands() {
+		cmds = append(cmds, allCommands(sub)...)
+	}
+	return cmds
+}
+
+func doConfigList() error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tVALUE\tSOURCE")
+	var envVars []string
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, envPrefix) {
+			envVars = append(envVars, kv)
+		}
+	}
+	sort.Strings(envVars)
+	for _, kv := range envVars {
+		i := strings.Index(kv, "=")
+		fmt.Fprintf(tw, "%s\t%s\tenvironment\n", kv[:i], kv[i+1:])
+	}
+	var keys []string
+	for key := range cfg.Flags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		values, err := configValues(cfg.Flags[key])
+		if err != nil {
+			return invalidInput(fmt.Errorf("invalid value for %q in config file %q: %v", key, configFile, err))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", key, strings.Join(values, ", "), configFile)
+	}
+	return tw.Flush()
+}
+
+func doConfigGet(key string) error {
+	if _, err := lookupFlagKey(key); err != nil {
+		return err
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	cmdKey, name := "", key
+	if i := strings.LastIndex(key, "."); i >= 0 {
+		cmdKey, name = key[:i], key[i+1:]
+	}
+	values, _, ok, err := cfg.flagDefault(cmdKey, name)
+	if err != nil {
+		return invalidInput(err)
+	}
+	if !ok {
+		return fmt.Errorf("%q is not set", key)
+	}
+	for _, v := range values {
+		fmt.Println(v)
+	}
+	return nil
+}
+
+// doConfigSet sets key to values in the configuration file, or removes it
+// if values is empty.
+func doConfigSet(key string, values []string) error {
+	f, err := lookupFlagKey(key)
+	if err != nil {
+		return err
+	}
+	for _, v := range values {
+		// The flag isn't used by this command, so it's OK to set it to
+		// check that v is valid.
+		if err := f.Value.Set(v); err != nil {
+			return invalidInput(fmt.Errorf("invalid value %q for %q: %v", v, key, err))
+		}
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	switch len(values) {
+	case 0:
+		if _, ok := cfg.Flags[key]; !ok {
+			return fmt.Errorf("%q is not set in config file %q", key, configFile)
+		}
+		delete(cfg.Flags, key)
+	case 1:
+		if cfg.Flags == nil {
+			cfg.Flags = map[string]interface{}{}
+		}
+		cfg.Flags[key] = values[0]
+	default:
+		if cfg.Flags == nil {
+			cfg.Flags = map[string]interface{}{}
+		}
+		cfg.Flags[key] = values
+	}
+	if err := saveConfig(cfg); err != nil {
+		return err
+	}
+	printf("Updated %q in %q.\n", key, configFile)
+	return nil
+}
+
+// watchConfig holds the defaults for activities uploaded by the watch
+// command.
+type watchConfig struct {
+	// ActivityType is used when the Activity Type can't be inferred from
+	// the file.
+	ActivityType string `json:"activity_type,omitempty"`
+	// Gear maps Activity Types to the Gear ID to use for them.
+	Gear map[string]string `json:"gear,omitempty"`
+	// Commute lists rules for marking activities as commutes; an activity
+	// is a commute if it matches any of them.
+	Commute []*commuteRule `json:"commute,omitempty"`
+}
+
+// commuteRule matches activities by type, day of week, and local start
+// time. Empty fields match everything.
+type commuteRule struct {
+	// ActivityType is the Activity Type to match (e.g., "Ride").
+	ActivityType string `json:"activity_type,omitempty"`
+	// Days lists the days of the week to match (e.g., ["Mon", "Fri"]).
+	Days []string `json:"days,omitempty"`
+	// After and Before are the range of start times to match, in the
+	// local time zone (e.g., "07:00" and "09:30").
+	After  string `json:"after,omitempty"`
+	Before string `json:"before,omitempty"`
+}
+
+// validate checks that r's times are well-formed.
+func (r *commuteRule) validate() error {
+	for _, bound := range []string{r.After, r.Before} {
+		if bound == "" {
+			continue
+		}
+		if _, err := time.Parse("15:04", bound); err != nil {
+			return fmt.Errorf("invalid commute rule time %q (should be HH:MM)", bound)
+		}
+	}
+	return nil
+}
+
+// matches returns true if an activity of type activityType starting at
+// start matches r.
+func (r *commuteRule) matches(activityType string, start time.Time) (bool, error) {
+	if r.ActivityType != "" && r.ActivityType != activityType {
+		return false, nil
+	}
+	start = start.Local()
+	if len(r.Days) > 0 {
+		found := false
+		for _, day := range r.Days {
+			if strings.EqualFold(day, start.Weekday().String()[:3]) || strings.EqualFold(day, start.Weekday().String()) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false, nil
+		}
+	}
+	if err := r.validate(); err != nil {
+		return false, err
+	}
+	clock := start.Format("15:04")
+	if r.After != "" && clock < r.After {
+		return false, nil
+	}
+	if r.Before != "" && clock >= r.Before {
+		return false, nil
+	}
+	return true, nil
+}
@@ -0,0 +1,98 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vangent/stravacli/fakestrava"
+)
+
+func TestConfigPrecedence(t *testing.T) {
+	_, flags, done := newFakeStrava(1)
+	defer done()
+	defer os.Remove(testConfigFile)
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	apiBase := flags[:2]
+
+	// download authenticates only if it's given the right --access_token.
+	download := func(extra ...string) error {
+		args := append([]string{"download", "--out", filepath.Join(dir, "orig.csv")}, apiBase...)
+		_, err := runCommand(t, append(args, extra...)...)
+		return err
+	}
+	checkAuth := func(desc string, err error, wantOK bool) {
+		t.Helper()
+		switch {
+		case wantOK && err != nil:
+			t.Errorf("%s: got %v, want the right access token", desc, err)
+		case !wantOK && exitCode(err) != exitAuth:
+			t.Errorf("%s: got %v (exit code %d), want the wrong access token", desc, err, exitCode(err))
+		}
+	}
+
+	for _, args := range [][]string{
+		{"config", "set", "access_token", "bad"},
+		{"config", "set", "download.access_token", fakestrava.AccessToken},
+	} {
+		if _, err := runCommand(t, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkAuth("config file for the command", download(), true)
+
+	os.Setenv(flagEnvVar("access_token"), "bad")
+	defer os.Unsetenv(flagEnvVar("access_token"))
+	checkAuth("environment variable", download(), false)
+	checkAuth("command line", download("--access_token", fakestrava.AccessToken), true)
+	os.Unsetenv(flagEnvVar("access_token"))
+
+	if _, err := runCommand(t, "config", "unset", "download.access_token"); err != nil {
+		t.Fatal(err)
+	}
+	checkAuth("config file for all commands", download(), false)
+	out, err := runCommand(t, "config", "get", "download.access_token")
+	if err != nil || out != "bad\n" {
+		t.Errorf("config get: got %q, %v, want the value for all commands", out, err)
+	}
+}
+
+func TestConfigSetInvalid(t *testing.T) {
+	defer os.Remove(testConfigFile)
+	for _, args := range [][]string{
+		{"config", "set", "upload.compress_threshold", "lots"},
+		{"config", "set", "nosuchcommand.ledger", "x"},
+		{"config", "set", "no_such_flag", "x"},
+	} {
+		if _, err := runCommand(t, args...); exitCode(err) != exitInvalid {
+			t.Errorf("%s: got %v (exit code %d), want exit code %d", strings.Join(args, " "), err, exitCode(err), exitInvalid)
+		}
+	}
+	if _, err := os.Stat(testConfigFile); !os.IsNotExist(err) {
+		t.Errorf("invalid values were written to %s", testConfigFile)
+	}
+}
@@ -0,0 +1,71 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vangent/stravacli/bulk"
+)
+
+// Flags for the .csv dialect, set by --csv_*.
+var (
+	csvDelimiter  string
+	csvDecimal    string
+	csvBooleans   string
+	csvTimeLayout string
+	csvBOM        bool
+)
+
+// dialect is the .csv dialect for all .csv files read and written, from
+// the --csv_* flags; see parseCSVDialect.
+var dialect *bulk.Dialect
+
+// parseCSVDialect sets dialect from the --csv_* flags.
+func parseCSVDialect() error {
+	d := &bulk.Dialect{TimeLayout: csvTimeLayout, BOM: csvBOM}
+	switch csvDelimiter {
+	case "auto":
+	case "tab", `\t`:
+		d.Comma = '\t'
+	case ",", ";", "|":
+		d.Comma = rune(csvDelimiter[0])
+	default:
+		return invalidInput(fmt.Errorf("invalid --csv_delimiter %q (should be auto, \",\", \";\", \"|\", or tab)", csvDelimiter))
+	}
+	switch csvDecimal {
+	case "auto":
+	case ".", ",":
+		d.Decimal = rune(csvDecimal[0])
+	default:
+		return invalidInput(fmt.Errorf("invalid --csv_decimal %q (should be auto, \".\", or \",\")", csvDecimal))
+	}
+	parts := strings.Split(csvBooleans, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return invalidInput(fmt.Errorf("invalid --csv_booleans %q (should be like true/false or Yes/No)", csvBooleans))
+	}
+	d.True, d.False = parts[0], parts[1]
+	dialect = d
+	return nil
+}
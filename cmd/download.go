@@ -23,23 +23,16 @@ THE SOFTWARE.
 package cmd
 
 import (
-	"context"
-	"errors"
 	"fmt"
 	"io"
 	"os"
 	"time"
 
-	"github.com/antihax/optional"
-	"github.com/gocarina/gocsv"
 	"github.com/spf13/cobra"
-	"github.com/vangent/strava"
+	"github.com/vangent/stravacli/bulk"
 )
 
-const (
-	pageSize  = 25           // # of activities to download per page
-	dayFormat = "2006-01-02" // format for date flags
-)
+const dayFormat = "2006-01-02" // format for date flags
 
 func init() { //
 	var accessToken string
@@ -58,26 +51,19 @@ for detailed instructions.
 Data Columns:
 ID: The Strava ID. Do not edit!
 Start: The start time. Do not edit! The time format looks like YYYY-MM-DDTHH:mm:ssZ; for example, 2019-02-22T18:53:46Z".
-Activity Type: The activity type; see the available list here: https://developers.strava.com/docs/reference/#api-models-ActivityType.
+Activity Type: The activity type; use "stravacli types" to see the available list.
+Sport Type: The more specific sport type, like "TrailRun" or "MountainBikeRide". Always blank on download; set it to change the sport type. Use "stravacli types" to see the available list; it must match the Activity Type.
 Name: The name of the activity.
-Workout Type: The type of workout. 0=default/none. For Ride: 11=Race, 12=Workout; for Run: 1=Race, 2=Long Run, 3=Workout. You can figure out other values by setting the field to what you want in Strava, then using "download" to view it.
+Workout Type: The type of workout. "None" (or blank) for the default. For Run: "Race", "LongRun", or "Workout"; for Ride: "Race" or "Workout". Other Activity Types only support "None" by name. Strava numbers are also accepted, and passed through as-is (for Run: 1=Race, 2=Long Run, 3=Workout; for Ride: 10=None, 11=Race, 12=Workout), except that 1-3 are only allowed for Run and 10-12 only for Ride; download writes numbers that don't have a name that way, and update writes an unchanged Workout Type back as-is.
 Gear ID: The ID for the gear used. The ID is not shown on the UI; you can figure out what the ID for a specific bike or pair of shoes by using "download" to view an activity that uses them. The ID looks something like "g3880367".
 Commute?: "false" or "true", depending on whether this activity was for a commute.
 Trainer?: "false" or "true", depending on whether this activity used a trainer. The Strava UI shows this differently depending on the activity type; for example, "Indoor Cycling" for Rides and "Treadmill" for Runs.
 `,
 		Args: cobra.NoArgs,
 		RunE: func(_ *cobra.Command, _ []string) error {
-			var before, after time.Time
-			var err error
-			if beforeStr != "" {
-				if before, err = time.Parse(dayFormat, beforeStr); err != nil {
-					return fmt.Errorf("invalid --before %q (should be YYYY-MM-DD): %v", beforeStr, err)
-				}
-			}
-			if afterStr != "" {
-				if after, err = time.Parse(dayFormat, afterStr); err != nil {
-					return fmt.Errorf("invalid --after %q (should be YYYY-MM-DD): %v", afterStr, err)
-				}
+			before, after, err := parseDayFlags(beforeStr, afterStr)
+			if err != nil {
+				return err
 			}
 			return doDownload(accessToken, outFile, maxActivities, before, after)
 		},
@@ -92,75 +78,48 @@ Trainer?: "false" or "true", depending on whether this activity used a trainer.
 	rootCmd.AddCommand(downloadCmd)
 }
 
-// updatableActivity represents a single Strava activity to be updated.
-type updatableActivity struct {
-	// Read-only fields.
-	ID    int64     `csv:"ID"`
-	Start time.Time `csv:"Start"`
-
-	// Editable fields.
-	ActivityType string `csv:"Activity Type"`
-	Name         string `csv:"Name"`
-	WorkoutType  int    `csv:"Workout Type"`
-	GearID       string `csv:"Gear ID"`
-	Commute      bool   `csv:"Commute?"`
-	Trainer      bool   `csv:"Trainer?"`
-}
-
-func (a *updatableActivity) String() string {
-	return fmt.Sprintf("[%s on %s (ID %d)]", a.Name, a.Start.Format(dayFormat), a.ID)
-}
-
-// Verify checks to see that a looks like it can be uploaded as an update to prev.
-func (a *updatableActivity) Verify(prev *updatableActivity) error {
-	if !a.Start.Equal(prev.Start) {
-		return errors.New("sorry, can't modify Start")
+// parseDayFlags parses the --before and --after flags; empty values result
+// in a zero time.Time.
+func parseDayFlags(beforeStr, afterStr string) (before, after time.Time, err error) {
+	if beforeStr != "" {
+		if before, err = time.Parse(dayFormat, beforeStr); err != nil {
+			return before, after, invalidInput(fmt.Errorf("invalid --before %q (should be YYYY-MM-DD): %v", beforeStr, err))
+		}
 	}
-	return nil
+	if afterStr != "" {
+		if after, err = time.Parse(dayFormat, afterStr); err != nil {
+			return before, after, invalidInput(fmt.Errorf("invalid --after %q (should be YYYY-MM-DD): %v", afterStr, err))
+		}
+	}
+	return before, after, nil
 }
 
 func doDownload(accessToken, outFile string, maxActivities int, before, after time.Time) error {
-	ctx := context.WithValue(context.Background(), strava.ContextAccessToken, accessToken)
-	cfg := strava.NewConfiguration()
-	client := strava.NewAPIClient(cfg)
-
-	page := int32(1)
-	var activities []*updatableActivity
-
-PageLoop:
-	for {
-		req := &strava.GetLoggedInAthleteActivitiesOpts{
-			Page:    optional.NewInt32(page),
-			PerPage: optional.NewInt32(pageSize),
-		}
-		if !before.IsZero() {
-			req.Before = optional.NewInt32(int32(before.Unix()))
-		}
-		if !after.IsZero() {
-			req.After = optional.NewInt32(int32(after.Unix()))
-		}
-		summaries, _, err := client.ActivitiesApi.GetLoggedInAthleteActivities(ctx, req)
-		if err != nil {
-			return fmt.Errorf("failed ListActivities call (page %d, per page %d): %v", page, pageSize, err)
-		}
-		for _, a := range summaries {
-			activity := &updatableActivity{a.Id, a.StartDate, string(*a.Type_), a.Name, int(a.WorkoutType), a.GearId, a.Commute, a.Trainer}
-			activities = append(activities, activity)
-			if maxActivities != -1 && len(activities) == maxActivities {
-				break PageLoop
-			}
-		}
-		if len(summaries) < pageSize {
-			break
-		}
-		fmt.Printf("%d activities so far, fetching next %d...\n", len(activities), pageSize)
-		page++
+	ctx, client := newClient(accessToken)
+	activities, err := client.Download(ctx, listOptions(before, after, maxActivities))
+	if err != nil {
+		return err
 	}
-	fmt.Printf("Downloaded %d activities.\n", len(activities))
+	printf("Downloaded %d activities.\n", len(activities))
+	recordResult("downloaded", len(activities))
 	return downloadWriteCSV(outFile, activities)
 }
 
-func downloadWriteCSV(filename string, activities []*updatableActivity) error {
+// listOptions returns options for listing activities between after and
+// before (either may be zero), stopping after maxActivities activities if
+// maxActivities is positive. Progress is printed to stderr.
+func listOptions(before, after time.Time, maxActivities int) *bulk.ListOptions {
+	return &bulk.ListOptions{
+		Before: before,
+		After:  after,
+		Max:    maxActivities,
+		Progress: func(n int) {
+			fmt.Fprintf(os.Stderr, "%d activities so far, fetching next page...\n", n)
+		},
+	}
+}
+
+func downloadWriteCSV(filename string, activities []*bulk.Activity) error {
 	var w io.Writer
 	if filename == "" {
 		w = os.Stdout
@@ -172,10 +131,8 @@ func downloadWriteCSV(filename string, activities []*updatableActivity) error {
 		defer f.Close()
 		w = f
 	}
-	csv, err := gocsv.MarshalString(activities)
-	if err != nil {
+	if err := bulk.WriteCSV(w, activities, dialect); err != nil {
 		return fmt.Errorf("failed to generate .csv: %v", err)
 	}
-	fmt.Fprintf(w, csv)
 	return nil
 }
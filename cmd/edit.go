@@ -0,0 +1,359 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vangent/stravacli/bulk"
+)
+
+// editHeader is the comment at the top of the document for edit.
+const editHeader = `
+Edit the activities below, then save the file and quit the editor to review
+the changes; nothing is sent to Strava until you confirm them.
+
+id and start can't be changed. Removing an activity, or one of its keys,
+leaves it unchanged. See "stravacli types" for the valid activity_type and
+sport_type values. workout_type is None, or for Run: Race, LongRun, or
+Workout; for Ride: Race or Workout.
+`
+
+func init() {
+	var accessToken string
+	var activityType string
+	var maxActivities int
+	var beforeStr, afterStr string
+
+	editCmd := &cobra.Command{
+		Use:   "edit [ID...]",
+		Short: "Edit activities in a text editor",
+		Long: `Edit activities in a text editor.
+
+Fetches the activities with the given IDs, or the activities chosen with
+--after, --before, --type, and --max, and opens them as a YAML document in
+your editor ($VISUAL or $EDITOR, or vi if neither is set). When you save
+the document and quit the editor, the changes are checked the same way as for
+update; if there are errors, you can edit the document again. Then the
+changes are shown, and applied to Strava after you confirm them.
+
+See "stravacli help download" for info about the fields.
+`,
+		RunE: func(_ *cobra.Command, args []string) error {
+			if jsonOutput() {
+				return invalidInput(errors.New("edit is interactive, and doesn't support --output=json"))
+			}
+			var ids []int64
+			for _, arg := range args {
+				id, err := strconv.ParseInt(arg, 10, 64)
+				if err != nil {
+					return invalidInput(fmt.Errorf("invalid activity ID %q", arg))
+				}
+				ids = append(ids, id)
+			}
+			filtered := activityType != "" || maxActivities > 0 || beforeStr != "" || afterStr != ""
+			if len(ids) > 0 && filtered {
+				return invalidInput(errors.New("use either activity IDs, or --after, --before, --type, and --max, not both"))
+			}
+			if len(ids) == 0 && !filtered {
+				return invalidInput(errors.New("specify activity IDs, or choose activities with --after, --before, --type, or --max"))
+			}
+			if activityType != "" {
+				if t, _ := bulk.ParseActivityTypeName(activityType); t != "" {
+					activityType = t
+				} else {
+					return invalidInput(fmt.Errorf("invalid --type %q (should be an Activity Type; see \"stravacli types\")", activityType))
+				}
+			}
+			before, after, err := parseDayFlags(beforeStr, afterStr)
+			if err != nil {
+				return err
+			}
+			return doEdit(accessToken, ids, activityType, maxActivities, before, after)
+		},
+	}
+	editCmd.Flags().StringVarP(&accessToken, "access_token", "t", "", "Strava access token; use the auth command to get one")
+	editCmd.MarkFlagRequired("access_token")
+	editCmd.Flags().StringVar(&activityType, "type", "", "only edit activities with this Activity Type")
+	editCmd.Flags().IntVar(&maxActivities, "max", 0, "maximum # of activities to edit (default 0 means no limit)")
+	editCmd.Flags().StringVar(&beforeStr, "before", "", "only edit activities before this date (YYYY-MM-DD)")
+	editCmd.Flags().StringVar(&afterStr, "after", "", "only edit activities after this date (YYYY-MM-DD)")
+	rootCmd.AddCommand(editCmd)
+}
+
+func doEdit(accessToken string, ids []int64, activityType string, maxActivities int, before, after time.Time) error {
+	ctx, client := newClient(accessToken)
+	var orig []*bulk.Activity
+	if len(ids) > 0 {
+		for _, id := range ids {
+			a, err := client.GetActivity(ctx, id)
+			if err != nil {
+				return fmt.Errorf("failed to get activity %d: %v", id, err)
+			}
+			orig = append(orig, a)
+		}
+	} else {
+		// With --type, --max counts only the matching activities, so they're
+		// filtered here rather than by Download.
+		opts := listOptions(before, after, maxActivities)
+		if activityType != "" {
+			opts.Max = 0
+		}
+		activities, err := client.Download(ctx, opts)
+		if err != nil {
+			return err
+		}
+		for _, a := range activities {
+			if activityType != "" && a.ActivityType != activityType {
+				continue
+			}
+			if maxActivities > 0 && len(orig) == maxActivities {
+				break
+			}
+			orig = append(orig, a)
+		}
+	}
+	if len(orig) == 0 {
+		fmt.Println("No activities found.")
+		return nil
+	}
+
+	f, err := ioutil.TempFile("", "stravacli-edit-*.yaml")
+	if err != nil {
+		return err
+	}
+	filename := f.Name()
+	defer os.Remove(filename)
+	_, err = f.Write(marshalActivitiesYAML(orig, editHeader))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %q: %v", filename, err)
+	}
+
+	var prevs, updated []*bulk.Activity
+	for {
+		if err := runEditor(filename); err != nil {
+			return err
+		}
+		if interrupted() {
+			return errors.New("interrupted; nothing was changed")
+		}
+		b, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		var problems []*editProblem
+		prevs, updated, problems = editedActivities(b, orig)
+		hasErrors := false
+		for _, p := range problems {
+			fmt.Println(p)
+			hasErrors = hasErrors || !p.warning
+		}
+		if !hasErrors {
+			break
+		}
+		ok, err := confirm(readLine(os.Stdin), os.Stdout, "Edit again?")
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if !ok {
+			return invalidInput(errors.New("found errors; nothing was changed"))
+		}
+	}
+	if len(updated) == 0 {
+		fmt.Println("No changes.")
+		return nil
+	}
+	for i, a := range updated {
+		fmt.Println(prevs[i])
+		printChanges(os.Stdout, a, prevs[i])
+	}
+	ok, err := confirm(readLine(os.Stdin), os.Stdout, fmt.Sprintf("Apply the changes to %d activities to Strava?", len(updated)))
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if !ok {
+		fmt.Println("Nothing was changed.")
+		return nil
+	}
+	results, err := client.Update(ctx, prevs, updated, &bulk.UpdateOptions{Progress: printRowResult})
+	n := 0
+	for _, r := range results {
+		if r.Status == bulk.StatusDone {
+			n++
+		}
+	}
+	fmt.Printf("Updated %d activities.\n", n)
+	recordResult("updated", n)
+	return err
+}
+
+// editorCommand returns the command line for the user's editor.
+func editorCommand() []string {
+	for _, env := range []string{"VISUAL", "EDITOR"} {
+		if fields := strings.Fields(os.Getenv(env)); len(fields) > 0 {
+			return fields
+		}
+	}
+	return []string{"vi"}
+}
+
+// runEditor runs the user's editor on filename, waiting for it to exit.
+func runEditor(filename string) error {
+	args := append(editorCommand(), filename)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to run editor %q: %v", strings.Join(args[:len(args)-1], " "), err)
+	}
+	return nil
+}
+
+// editProblem is a problem with the document for edit.
+type editProblem struct {
+	line    int
+	err     error
+	warning bool
+}
+
+func (p *editProblem) String() string {
+	kind := "error"
+	if p.warning {
+		kind = "warning"
+	}
+	return fmt.Sprintf("line %d: %s: %v", p.line, kind, p.err)
+}
+
+// editedActivities parses b, the edited document for edit, and returns the
+// changed activities, with their originals from orig, and the problems
+// found.
+func editedActivities(b []byte, orig []*bulk.Activity) (prevs, updated []*bulk.Activity, problems []*editProblem) {
+	items, err := parseYAMLItems(b)
+	if err != nil {
+		yerr := err.(*yamlError)
+		return nil, nil, []*editProblem{{line: yerr.line, err: yerr.err}}
+	}
+	prevByID := map[int64]*bulk.Activity{}
+	opts := &bulk.ValidateOptions{KnownGear: knownGear()}
+	if opts.KnownGear == nil {
+		opts.KnownGear = map[string]bool{}
+	}
+	for _, a := range orig {
+		prevByID[a.ID] = a
+		if a.GearID != "" {
+			opts.KnownGear[a.GearID] = true
+		}
+	}
+	seen := map[int64]int{}
+	for _, item := range items {
+		errorf := func(line int, format string, args ...interface{}) {
+			problems = append(problems, &editProblem{line: line, err: fmt.Errorf(format, args...)})
+		}
+		idValue := item.values["id"]
+		if idValue == nil {
+			errorf(item.line, "missing id")
+			continue
+		}
+		id, err := strconv.ParseInt(idValue.value, 10, 64)
+		if err != nil {
+			errorf(idValue.line, "invalid id %q", idValue.value)
+			continue
+		}
+		prev := prevByID[id]
+		if prev == nil {
+			errorf(idValue.line, "activity %d wasn't one of the activities being edited", id)
+			continue
+		}
+		if line, ok := seen[id]; ok {
+			errorf(idValue.line, "duplicate activity %d; also on line %d", id, line)
+			continue
+		}
+		seen[id] = idValue.line
+		a := *prev
+		lineFor := map[string]int{}
+		for key, v := range item.values {
+			f := yamlFieldFor(key)
+			lineFor[f.column] = v.line
+			if f.set == nil {
+				continue
+			}
+			if err := f.set(&a, v.value); err != nil {
+				errorf(v.line, "%s: %v", key, err)
+			}
+		}
+		if len(a.Changes(prev)) == 0 {
+			continue
+		}
+		for _, p := range a.Problems(prev, opts) {
+			line := lineFor[p.Column]
+			if line == 0 {
+				line = item.line
+			}
+			problems = append(problems, &editProblem{line: line, err: p.Err, warning: p.Warning})
+		}
+		prevs = append(prevs, prev)
+		updated = append(updated, &a)
+	}
+	return prevs, updated, problems
+}
+
+// readLine returns a channel for prompt that receives a single line read
+// from r. Unlike readLines, it reads a byte at a time and stops at the end
+// of the line, so that nothing more is consumed from r; e.g., the editor
+// can use os.Stdin afterwards.
+func readLine(r io.Reader) <-chan string {
+	lines := make(chan string, 1)
+	go func() {
+		defer close(lines)
+		var line []byte
+		b := make([]byte, 1)
+		for {
+			n, err := r.Read(b)
+			if n == 1 {
+				if b[0] == '\n' {
+					break
+				}
+				line = append(line, b[0])
+			}
+			if err != nil {
+				if len(line) == 0 {
+					return
+				}
+				break
+			}
+		}
+		lines <- string(line)
+	}()
+	return lines
+}
@@ -0,0 +1,144 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/vangent/stravacli/bulk"
+)
+
+// Exit codes. If more than one applies, exitCode prefers exitInterrupted,
+// then exitInvalid (unless some rows were processed), exitAuth,
+// exitRateLimited, and exitPartial; e.g., a run that updated some rows and
+// then hit Strava's rate limit exits with exitRateLimited.
+const (
+	exitOK          = 0
+	exitFailure     = 1   // anything not covered below
+	exitInvalid     = 2   // invalid flags or input; nothing was sent to Strava for the bad row
+	exitAuth        = 3   // Strava rejected the access token or credentials
+	exitRateLimited = 4   // Strava's rate limit was exceeded
+	exitPartial     = 5   // some rows were processed before an error; see --start_row
+	exitInterrupted = 130 // interrupted by SIGINT or SIGTERM, like shells report for Ctrl-C
+)
+
+// exitStatus maps exit codes to the status in the --output=json "done"
+// event.
+var exitStatus = map[int]string{
+	exitOK:          "ok",
+	exitFailure:     "failed",
+	exitInvalid:     "invalid",
+	exitAuth:        "auth_error",
+	exitRateLimited: "rate_limited",
+	exitPartial:     "partial",
+	exitInterrupted: "interrupted",
+}
+
+// runStarted is set when a command's RunE is called; errors before then are
+// from flag or argument validation.
+var runStarted bool
+
+var (
+	httpStatusMu sync.Mutex
+	httpStatuses = map[int]bool{} // HTTP status codes seen from Strava
+)
+
+// noteHTTPStatus records an HTTP status code from Strava, for exitCode.
+func noteHTTPStatus(code int) {
+	httpStatusMu.Lock()
+	defer httpStatusMu.Unlock()
+	httpStatuses[code] = true
+}
+
+// sawHTTPStatus returns true if Strava returned any of codes.
+func sawHTTPStatus(codes ...int) bool {
+	httpStatusMu.Lock()
+	defer httpStatusMu.Unlock()
+	for _, code := range codes {
+		if httpStatuses[code] {
+			return true
+		}
+	}
+	return false
+}
+
+// inputError marks an error as a problem with a command's flags or input.
+type inputError struct {
+	err error
+}
+
+func (e *inputError) Error() string {
+	return e.err.Error()
+}
+
+// invalidInput marks err as a problem with a command's flags or input, for
+// exitCode.
+func invalidInput(err error) error {
+	return &inputError{err}
+}
+
+// partialError is returned by checkPartialSuccess when some rows were
+// processed before an error.
+type partialError struct {
+	err error
+	row int
+}
+
+func (e *partialError) Error() string {
+	return e.err.Error()
+}
+
+// isInvalid returns true if err is a problem with flags or input.
+func isInvalid(err error) bool {
+	switch e := err.(type) {
+	case *inputError, *bulk.ValidationError:
+		return true
+	case *bulk.RowError:
+		return isInvalid(e.Err)
+	}
+	return false
+}
+
+// exitCode returns the exit code for a command that returned err.
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	if interrupted() {
+		return exitInterrupted
+	}
+	if _, ok := err.(*partialError); !ok && (!runStarted || isInvalid(err)) {
+		return exitInvalid
+	}
+	if sawHTTPStatus(http.StatusUnauthorized, http.StatusForbidden) {
+		return exitAuth
+	}
+	if sawHTTPStatus(http.StatusTooManyRequests) {
+		return exitRateLimited
+	}
+	if _, ok := err.(*partialError); ok {
+		return exitPartial
+	}
+	return exitFailure
+}
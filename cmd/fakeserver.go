@@ -0,0 +1,70 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vangent/stravacli/fakestrava"
+)
+
+func init() {
+	var port int
+	var numActivities int
+	var processingDelay time.Duration
+
+	fakeServerCmd := &cobra.Command{
+		Use:    "fakeserver",
+		Short:  "Run a fake Strava API server for offline testing",
+		Hidden: true,
+		Long: `Run a fake Strava API server for offline testing.
+
+The server keeps everything in memory, and is seeded with sample gear and
+--activities sample activities. Point other commands at it with the hidden
+--api_base flag, using the access token it prints.`,
+		Args: cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			return doFakeServer(port, numActivities, processingDelay)
+		},
+	}
+	fakeServerCmd.Flags().IntVar(&port, "port", 8081, "port to run the fake server on")
+	fakeServerCmd.Flags().IntVar(&numActivities, "activities", 30, "number of sample activities to create")
+	fakeServerCmd.Flags().DurationVar(&processingDelay, "processing_delay", 2*time.Second, "how long uploads take to process")
+	rootCmd.AddCommand(fakeServerCmd)
+}
+
+func doFakeServer(port, numActivities int, processingDelay time.Duration) error {
+	fake := fakestrava.New()
+	fake.ProcessingDelay = processingDelay
+	fake.Seed(numActivities, time.Now())
+	l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return err
+	}
+	printf("Serving a fake Strava API; use --api_base=http://%s --access_token=%s\n", l.Addr(), fakestrava.AccessToken)
+	return http.Serve(l, fake)
+}
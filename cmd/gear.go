@@ -0,0 +1,284 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vangent/strava"
+	"github.com/vangent/stravacli/bulk"
+)
+
+const (
+	gearStatusOK      = "ok"
+	gearStatusService = "service due"
+	gearStatusRetire  = "retire"
+)
+
+func init() {
+	var accessToken string
+	var outFile string
+	var format string
+	var beforeStr, afterStr string
+
+	gearCmd := &cobra.Command{
+		Use:   "gear",
+		Short: "Work with gear (bikes and shoes)",
+		Long:  `Work with gear (bikes and shoes).`,
+	}
+
+	gearReportCmd := &cobra.Command{
+		Use:   "report",
+		Short: "Report gear usage and flag gear due for maintenance",
+		Long: `Report gear usage and flag gear due for maintenance.
+
+Computes the distance, time, and # of activities for each piece of gear used
+by activities in the given date range, and compares them against the service
+intervals in the configuration file (see --config). For example:
+
+  {
+    "gear": {
+      "g3880367": {
+        "service_distance_km": 3000,
+        "last_service": "2019-06-01",
+        "retire_distance_km": 20000
+      },
+      "g4191125": {
+        "name": "Trail shoes",
+        "retire_distance_km": 800
+      }
+    }
+  }
+
+Available settings per gear:
+name: A name to use if Strava doesn't know the gear.
+service_distance_km: Distance between services, in kilometers.
+service_hours: Moving time between services, in hours.
+service_activities: # of activities between services.
+last_service: Date of the last service (YYYY-MM-DD); activities before this date don't count for that gear.
+retire_distance_km: Total distance (as tracked by Strava) after which the gear should be retired.
+
+Data Columns:
+Gear ID: The ID for the gear.
+Name: The name of the gear.
+Activities: The # of activities counted.
+Distance (km): The distance covered in the counted activities.
+Moving Time (h): The moving time in the counted activities.
+Elapsed Time (h): The elapsed time in the counted activities.
+Total Distance (km): The total distance for the gear, as tracked by Strava.
+Status: "ok", "service due", or "retire".
+Reason: Why the gear was flagged.
+`,
+		Args: cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			before, after, err := parseDayFlags(beforeStr, afterStr)
+			if err != nil {
+				return err
+			}
+			switch format {
+			case "text", "csv", "json":
+			default:
+				return invalidInput(fmt.Errorf("invalid --format %q (should be text, csv, or json)", format))
+			}
+			return doGearReport(accessToken, outFile, format, before, after)
+		},
+	}
+	gearReportCmd.Flags().StringVarP(&accessToken, "access_token", "t", "", "Strava access token; use the auth command to get one")
+	gearReportCmd.MarkFlagRequired("access_token")
+	gearReportCmd.Flags().StringVar(&outFile, "out", "", "output filename (default stdout)")
+	gearReportCmd.Flags().StringVar(&format, "format", "text", "output format: text, csv, or json")
+	gearReportCmd.Flags().StringVar(&beforeStr, "before", "", "only count activities before this date (YYYY-MM-DD)")
+	gearReportCmd.Flags().StringVar(&afterStr, "after", "", "only count activities after this date (YYYY-MM-DD)")
+	gearCmd.AddCommand(gearReportCmd)
+	rootCmd.AddCommand(gearCmd)
+}
+
+// gearUsage represents usage of a single piece of gear.
+type gearUsage struct {
+	GearID          string  `csv:"Gear ID" json:"gear_id"`
+	Name            string  `csv:"Name" json:"name"`
+	Activities      int     `csv:"Activities" json:"activities"`
+	DistanceKm      float64 `csv:"Distance (km)" json:"distance_km"`
+	MovingHours     float64 `csv:"Moving Time (h)" json:"moving_hours"`
+	ElapsedHours    float64 `csv:"Elapsed Time (h)" json:"elapsed_hours"`
+	TotalDistanceKm float64 `csv:"Total Distance (km)" json:"total_distance_km"`
+	Status          string  `csv:"Status" json:"status"`
+	Reason          string  `csv:"Reason" json:"reason,omitempty"`
+}
+
+// check sets u.Status and u.Reason based on gc.
+func (u *gearUsage) check(gc *gearConfig) {
+	u.Status = gearStatusOK
+	if gc == nil {
+		return
+	}
+	var reasons []string
+	if gc.RetireDistanceKm > 0 && u.TotalDistanceKm >= gc.RetireDistanceKm {
+		u.Status = gearStatusRetire
+		reasons = append(reasons, fmt.Sprintf("total distance %.1f km >= %.1f km", u.TotalDistanceKm, gc.RetireDistanceKm))
+	}
+	var service []string
+	if gc.ServiceDistanceKm > 0 && u.DistanceKm >= gc.ServiceDistanceKm {
+		service = append(service, fmt.Sprintf("distance %.1f km >= %.1f km", u.DistanceKm, gc.ServiceDistanceKm))
+	}
+	if gc.ServiceHours > 0 && u.MovingHours >= gc.ServiceHours {
+		service = append(service, fmt.Sprintf("moving time %.1f h >= %.1f h", u.MovingHours, gc.ServiceHours))
+	}
+	if gc.ServiceActivities > 0 && u.Activities >= gc.ServiceActivities {
+		service = append(service, fmt.Sprintf("%d activities >= %d", u.Activities, gc.ServiceActivities))
+	}
+	if len(service) > 0 && u.Status == gearStatusOK {
+		u.Status = gearStatusService
+	}
+	reasons = append(reasons, service...)
+	u.Reason = strings.Join(reasons, "; ")
+}
+
+func doGearReport(accessToken, outFile, format string, before, after time.Time) error {
+	conf, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	lastService := map[string]time.Time{}
+	for id, gc := range conf.Gear {
+		if gc.LastService == "" {
+			continue
+		}
+		t, err := time.Parse(dayFormat, gc.LastService)
+		if err != nil {
+			return fmt.Errorf("invalid last_service %q for gear %q in config file (should be YYYY-MM-DD): %v", gc.LastService, id, err)
+		}
+		lastService[id] = t
+	}
+
+	ctx, client := newClient(accessToken)
+
+	usage := map[string]*gearUsage{}
+	for id := range conf.Gear {
+		usage[id] = &gearUsage{GearID: id}
+	}
+	n := 0 // activities counted towards gear usage
+	err = client.ListActivities(ctx, listOptions(before, after, 0), func(a *strava.SummaryActivity) {
+		if a.GearId == "" {
+			return
+		}
+		if t, ok := lastService[a.GearId]; ok && a.StartDate.Before(t) {
+			return
+		}
+		u := usage[a.GearId]
+		if u == nil {
+			u = &gearUsage{GearID: a.GearId}
+			usage[a.GearId] = u
+		}
+		n++
+		u.Activities++
+		u.DistanceKm += float64(a.Distance) / 1000
+		u.MovingHours += float64(a.MovingTime) / 3600
+		u.ElapsedHours += float64(a.ElapsedTime) / 3600
+	})
+	if err != nil {
+		return err
+	}
+	used := 0
+	for _, u := range usage {
+		if u.Activities > 0 {
+			used++
+		}
+	}
+	printf("Found %d activities using %d pieces of gear.\n", n, used)
+
+	var report []*gearUsage
+	for id, u := range usage {
+		gc := conf.Gear[id]
+		gear, _, err := client.API().GearsApi.GetGearById(ctx, id)
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			// The gear may have been deleted, or the ID in the config file
+			// may be wrong; report what's known about it.
+			printf("  Warning: failed to get gear %q from Strava, so its total distance is unknown: %v\n", id, err)
+		}
+		u.Name = gear.Name
+		if u.Name == "" && gc != nil {
+			u.Name = gc.Name
+		}
+		u.TotalDistanceKm = float64(gear.Distance) / 1000
+		u.DistanceKm = round2(u.DistanceKm)
+		u.MovingHours = round2(u.MovingHours)
+		u.ElapsedHours = round2(u.ElapsedHours)
+		u.TotalDistanceKm = round2(u.TotalDistanceKm)
+		u.check(gc)
+		report = append(report, u)
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].GearID < report[j].GearID })
+	return gearWriteReport(outFile, format, report)
+}
+
+// round2 rounds f to 2 decimal places.
+func round2(f float64) float64 {
+	return math.Round(f*100) / 100
+}
+
+func gearWriteReport(filename, format string, report []*gearUsage) error {
+	var w io.Writer
+	if filename == "" {
+		w = os.Stdout
+	} else {
+		f, err := os.Create(filename)
+		if err != nil {
+			return fmt.Errorf("failed to open output file %q: %v", filename, err)
+		}
+		defer f.Close()
+		w = f
+	}
+	switch format {
+	case "csv":
+		if err := bulk.WriteCSV(w, report, dialect); err != nil {
+			return fmt.Errorf("failed to generate .csv: %v", err)
+		}
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return fmt.Errorf("failed to generate .json: %v", err)
+		}
+	default:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "GEAR ID\tNAME\tACTIVITIES\tDISTANCE (KM)\tMOVING (H)\tTOTAL (KM)\tSTATUS\tREASON")
+		for _, u := range report {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%.1f\t%.1f\t%.1f\t%s\t%s\n", u.GearID, u.Name, u.Activities, u.DistanceKm, u.MovingHours, u.TotalDistanceKm, u.Status, u.Reason)
+		}
+		tw.Flush()
+	}
+	return nil
+}
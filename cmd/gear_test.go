@@ -0,0 +1,91 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vangent/strava"
+)
+
+func TestGearReportCommand(t *testing.T) {
+	fake, flags, done := newFakeStrava(4) // 2 rides on b1, 2 runs on g1
+	defer done()
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	swim := strava.SWIM_ActivityType
+	fake.AddActivity(strava.DetailedActivity{Name: "Swim", Type_: &swim, StartDate: testEnd.Add(-time.Hour), ElapsedTime: 1800}, "")
+
+	// b2 isn't used, and Strava doesn't know about b999.
+	writeFile(t, filepath.Dir(testConfigFile), filepath.Base(testConfigFile), `{
+  "gear": {
+    "b1": {"service_activities": 2},
+    "b2": {"name": "Spare bike"},
+    "b999": {"name": "Old bike", "service_distance_km": 1000}
+  }
+}`)
+	defer os.Remove(testConfigFile)
+
+	report := filepath.Join(dir, "report.json")
+	out, err := runCommand(t, append([]string{"gear", "report", "--format", "json", "--out", report}, flags...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "Found 4 activities using 2 pieces of gear.") {
+		t.Errorf("got output %q, want 4 activities using 2 pieces of gear", out)
+	}
+	if !strings.Contains(out, `Warning: failed to get gear "b999"`) {
+		t.Errorf("got output %q, want a warning about b999", out)
+	}
+
+	b, err := ioutil.ReadFile(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var usage []*gearUsage
+	if err := json.Unmarshal(b, &usage); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]*gearUsage{}
+	for _, u := range usage {
+		got[u.GearID] = u
+	}
+	if len(got) != 4 {
+		t.Fatalf("got report for %d pieces of gear, want 4:\n%s", len(got), b)
+	}
+	if u := got["b1"]; u.Name != "Road Bike" || u.Activities != 2 || u.DistanceKm != 80 || u.Status != gearStatusService {
+		t.Errorf("got b1 %+v, want 2 activities and due for service", u)
+	}
+	if u := got["g1"]; u.Activities != 2 || u.Status != gearStatusOK {
+		t.Errorf("got g1 %+v, want 2 activities and ok", u)
+	}
+	if u := got["b999"]; u.Name != "Old bike" || u.Activities != 0 {
+		t.Errorf("got b999 %+v, want its name from the config file", u)
+	}
+}
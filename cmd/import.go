@@ -0,0 +1,504 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vangent/stravacli/bulk"
+)
+
+func init() {
+	var accessToken string
+	var inFile string
+	var profileName string
+	var tz string
+	var emitCSV string
+	var printProfile string
+	var startRow int
+	var dryRun bool
+
+	importCmd := &cobra.Command{
+		Use:   "import",
+		Short: "Upload manual activities exported from other apps",
+		Long: `Upload manual activities exported from other apps.
+
+The export (a .csv or .json file) is converted into manual activities using a
+profile that says which of its columns hold the start time, name, type,
+duration, and so on, and what units they're in. The activities are then
+uploaded the same way as "stravacli uploadmanual", or written to a .csv with
+--emit_csv for review.
+
+--profile is either the name of a built-in profile, or a .json profile file.
+Built-in profiles:
+` + importProfileList() + `
+
+Use --print_profile to print a built-in profile as a starting point for your
+own. See https://github.com/vangent/stravacli#import-from-other-apps
+for a description of the profile format.`,
+		Args: cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			if printProfile != "" {
+				return doPrintImportProfile(printProfile)
+			}
+			if inFile == "" || profileName == "" {
+				return invalidInput(errors.New("--in and --profile are required"))
+			}
+			activities, err := importActivities(inFile, profileName, tz)
+			if err != nil {
+				return invalidInput(err)
+			}
+			if emitCSV != "" {
+				return writeActivitiesCSV(emitCSV, activities)
+			}
+			if accessToken == "" {
+				return invalidInput(errors.New("required flag \"access_token\" not set"))
+			}
+			return checkPartialSuccess(uploadManualActivities(accessToken, inFile, activities, startRow, dryRun))
+		},
+	}
+	importCmd.Flags().StringVarP(&accessToken, "access_token", "t", "", "Strava access token; use the auth command to get one")
+	importCmd.Flags().StringVar(&inFile, "in", "", ".csv or .json file exported from another app")
+	importCmd.Flags().StringVar(&profileName, "profile", "", "name of a built-in profile, or a .json profile file, describing the export")
+	importCmd.Flags().StringVar(&tz, "tz", "", "time zone for start times without one (e.g., \"Europe/Amsterdam\"); overrides the profile's; defaults to the local time zone")
+	importCmd.Flags().StringVar(&emitCSV, "emit_csv", "", "write the converted activities to this .csv file (\"-\" for stdout) for use with uploadmanual, instead of uploading")
+	importCmd.Flags().StringVar(&printProfile, "print_profile", "", "print the named built-in profile and exit")
+	importCmd.Flags().IntVar(&startRow, "start_row", 1, "skip rows in the input up to this row (row 0 is the header row)")
+	importCmd.Flags().BoolVar(&dryRun, "dryrun", false, "do a dry run: print out proposed changes")
+	rootCmd.AddCommand(importCmd)
+}
+
+// importProfile describes how to convert another app's export into manual
+// activities.
+type importProfile struct {
+	// Format is "csv" or "json".
+	Format string `json:"format"`
+	// Delimiter is the field delimiter for "csv"; defaults to ",".
+	Delimiter string `json:"delimiter,omitempty"`
+	// Records is the dotted path to the array of records for "json"; empty
+	// if the file is an array.
+	Records string `json:"records,omitempty"`
+	// Columns maps manual activity fields to columns in the export. For
+	// "json", nested fields are named with dotted paths.
+	Columns importColumns `json:"columns"`
+	// StartLayout is the Go time layout for the start column; if empty, the
+	// formats accepted by bulk.ParseLocalTime are used.
+	StartLayout string `json:"start_layout,omitempty"`
+	// TimeZone is the time zone for start times without one; defaults to
+	// the local time zone.
+	TimeZone string `json:"time_zone,omitempty"`
+	// DurationUnit is the unit for durations that are plain numbers; see
+	// durationUnits. Defaults to "s".
+	DurationUnit string `json:"duration_unit,omitempty"`
+	// DistanceUnit is the unit for distances that are plain numbers; see
+	// distanceUnits. Defaults to "m".
+	DistanceUnit string `json:"distance_unit,omitempty"`
+	// TypeDistanceUnits overrides DistanceUnit for specific Activity Types
+	// (e.g., for swims recorded in meters).
+	TypeDistanceUnits map[string]string `json:"type_distance_units,omitempty"`
+	// Types maps the export's activity types to Activity Types or Sport
+	// Types. Types that aren't listed are matched against the Strava names
+	// (e.g., "Weight Training" matches "WeightTraining").
+	Types map[string]string `json:"types,omitempty"`
+	// Defaults holds values for fields that are missing or blank.
+	Defaults importDefaults `json:"defaults,omitempty"`
+}
+
+// importColumns maps manual activity fields to columns in an export.
+type importColumns struct {
+	Start        string `json:"start"`
+	ActivityType string `json:"activity_type,omitempty"`
+	SportType    string `json:"sport_type,omitempty"`
+	Name         string `json:"name,omitempty"`
+	Description  string `json:"description,omitempty"`
+	WorkoutType  string `json:"workout_type,omitempty"`
+	GearID       string `json:"gear_id,omitempty"`
+	Duration     string `json:"duration,omitempty"`
+	Distance     string `json:"distance,omitempty"`
+	Commute      string `json:"commute,omitempty"`
+	Trainer      string `json:"trainer,omitempty"`
+}
+
+// importDefaults holds default values for manual activity fields.
+type importDefaults struct {
+	ActivityType string `json:"activity_type,omitempty"`
+	Name         string `json:"name,omitempty"`
+	Description  string `json:"description,omitempty"`
+	GearID       string `json:"gear_id,omitempty"`
+	Commute      bool   `json:"commute,omitempty"`
+	Trainer      bool   `json:"trainer,omitempty"`
+}
+
+// garminConnectTypes maps Garmin Connect activity types to Strava ones.
+var garminConnectTypes = map[string]string{
+	"Cardio":              "Workout",
+	"Cycling":             "Ride",
+	"HIIT":                "HighIntensityIntervalTraining",
+	"Indoor Cycling":      "Ride",
+	"Indoor Rowing":       "Rowing",
+	"Mountain Biking":     "MountainBikeRide",
+	"Open Water Swimming": "Swim",
+	"Pool Swim":           "Swim",
+	"Road Cycling":        "Ride",
+	"Running":             "Run",
+	"Strength Training":   "WeightTraining",
+	"Trail Running":       "TrailRun",
+	"Treadmill Running":   "Run",
+	"Walking":             "Walk",
+	"Hiking":              "Hike",
+}
+
+// builtinImportProfiles are the profiles that can be used by name.
+var builtinImportProfiles = map[string]*importProfile{
+	"garmin-connect": {
+		Format: "csv",
+		Columns: importColumns{
+			Start:        "Date",
+			ActivityType: "Activity Type",
+			Name:         "Title",
+			Duration:     "Time",
+			Distance:     "Distance",
+		},
+		StartLayout:       "2006-01-02 15:04:05",
+		DistanceUnit:      "km",
+		TypeDistanceUnits: map[string]string{"Swim": "m"},
+		Types:             garminConnectTypes,
+		Defaults:          importDefaults{ActivityType: "Workout"},
+	},
+	"garmin-connect-mi": {
+		Format: "csv",
+		Columns: importColumns{
+			Start:        "Date",
+			ActivityType: "Activity Type",
+			Name:         "Title",
+			Duration:     "Time",
+			Distance:     "Distance",
+		},
+		StartLayout:       "2006-01-02 15:04:05",
+		DistanceUnit:      "mi",
+		TypeDistanceUnits: map[string]string{"Swim": "yd"},
+		Types:             garminConnectTypes,
+		Defaults:          importDefaults{ActivityType: "Workout"},
+	},
+}
+
+var importProfileDescriptions = map[string]string{
+	"garmin-connect":    "Garmin Connect \"Export CSV\" of the activities list, with metric units",
+	"garmin-connect-mi": "Garmin Connect \"Export CSV\" of the activities list, with statute units",
+}
+
+func importProfileList() string {
+	var names []string
+	for name := range builtinImportProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var lines []string
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("  %s: %s", name, importProfileDescriptions[name]))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func doPrintImportProfile(name string) error {
+	p, ok := builtinImportProfiles[name]
+	if !ok {
+		return invalidInput(fmt.Errorf("unknown built-in profile %q", name))
+	}
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+// loadImportProfile returns the built-in profile called name, or reads the
+// profile from the file name.
+func loadImportProfile(name string) (*importProfile, error) {
+	if p, ok := builtinImportProfiles[name]; ok {
+		return p, nil
+	}
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a built-in profile, and failed to read it as a file: %v", name, err)
+	}
+	p := &importProfile{}
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, invalidInput(fmt.Errorf("failed to parse profile %q: %v", name, err))
+	}
+	if p.Columns.Start == "" {
+		return nil, fmt.Errorf("invalid profile %q: missing columns.start", name)
+	}
+	return p, nil
+}
+
+// importActivities converts the export in filename into manual activities
+// using the profile profileName. tz, if not empty, overrides the profile's
+// time zone.
+func importActivities(filename, profileName, tz string) ([]*bulk.ManualActivity, error) {
+	p, err := loadImportProfile(profileName)
+	if err != nil {
+		return nil, err
+	}
+	if tz == "" {
+		tz = p.TimeZone
+	}
+	loc := time.Local
+	if tz != "" {
+		if loc, err = time.LoadLocation(tz); err != nil {
+			return nil, fmt.Errorf("invalid time zone %q: %v", tz, err)
+		}
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, invalidInput(fmt.Errorf("failed to open %q: %v", filename, err))
+	}
+	defer f.Close()
+	records, err := p.readRecords(f)
+	if err != nil {
+		return nil, invalidInput(fmt.Errorf("failed to parse %q: %v", filename, err))
+	}
+	var activities []*bulk.ManualActivity
+	for i, rec := range records {
+		a, err := p.activity(rec, loc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert row %d of %q: %v", i+1, filename, err)
+		}
+		activities = append(activities, a)
+	}
+	return activities, nil
+}
+
+// readRecords reads the records in r, each as a map from column name to
+// value.
+func (p *importProfile) readRecords(r io.Reader) ([]map[string]string, error) {
+	switch p.Format {
+	case "csv":
+		return p.readCSVRecords(r)
+	case "json":
+		return p.readJSONRecords(r)
+	}
+	return nil, fmt.Errorf("invalid profile format %q (should be csv or json)", p.Format)
+}
+
+func (p *importProfile) readCSVRecords(r io.Reader) ([]map[string]string, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	if p.Delimiter != "" {
+		cr.Comma = []rune(p.Delimiter)[0]
+	}
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+	found := false
+	for _, name := range header {
+		found = found || name == p.Columns.Start
+	}
+	if !found {
+		return nil, fmt.Errorf("missing required column %q (the profile's columns.start)", p.Columns.Start)
+	}
+	var records []map[string]string
+	for {
+		fields, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		rec := map[string]string{}
+		for i, name := range header {
+			if i < len(fields) {
+				if _, ok := rec[name]; !ok {
+					rec[name] = fields[i]
+				}
+			}
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+func (p *importProfile) readJSONRecords(r io.Reader) ([]map[string]string, error) {
+	var v interface{}
+	if err := json.NewDecoder(r).Decode(&v); err != nil {
+		return nil, err
+	}
+	if p.Records != "" {
+		for _, key := range strings.Split(p.Records, ".") {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("records path %q not found", p.Records)
+			}
+			v = m[key]
+		}
+	}
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected an array of records at %q", p.Records)
+	}
+	var records []map[string]string
+	for _, item := range items {
+		rec := map[string]string{}
+		flattenJSON("", item, rec)
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// flattenJSON adds the values in v to rec, naming nested fields with dotted
+// paths starting at prefix.
+func flattenJSON(prefix string, v interface{}, rec map[string]string) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			flattenJSON(key, value, rec)
+		}
+	case float64:
+		rec[prefix] = strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		rec[prefix] = ""
+	default:
+		rec[prefix] = fmt.Sprint(v)
+	}
+}
+
+// activity converts rec into a manual activity. Start times without a time
+// zone are in loc.
+func (p *importProfile) activity(rec map[string]string, loc *time.Location) (*bulk.ManualActivity, error) {
+	get := func(column string) string {
+		if column == "" {
+			return ""
+		}
+		v := strings.TrimSpace(rec[column])
+		if v == "--" {
+			// Used by some apps for "no value".
+			return ""
+		}
+		return v
+	}
+	a := &bulk.ManualActivity{
+		Name:        get(p.Columns.Name),
+		Description: get(p.Columns.Description),
+		WorkoutType: bulk.WorkoutType(get(p.Columns.WorkoutType)),
+		GearID:      get(p.Columns.GearID),
+		Commute:     parseFlexibleBool(get(p.Columns.Commute)) || p.Defaults.Commute,
+		Trainer:     parseFlexibleBool(get(p.Columns.Trainer)) || p.Defaults.Trainer,
+	}
+
+	start := get(p.Columns.Start)
+	if start == "" {
+		return nil, fmt.Errorf("missing start time in column %q", p.Columns.Start)
+	}
+	var t time.Time
+	var err error
+	if p.StartLayout != "" {
+		if t, err = time.ParseInLocation(p.StartLayout, start, loc); err != nil {
+			return nil, fmt.Errorf("invalid start time %q (should be like %q)", start, p.StartLayout)
+		}
+	} else if t, err = bulk.ParseLocalTime(start, loc); err != nil {
+		return nil, err
+	}
+	a.Start = bulk.ManualStart(t.UTC().Format(time.RFC3339))
+
+	typeName := get(p.Columns.ActivityType)
+	for from, to := range p.Types {
+		if strings.EqualFold(from, typeName) {
+			typeName = to
+			break
+		}
+	}
+	a.ActivityType, a.SportType = bulk.ParseActivityTypeName(typeName)
+	if a.ActivityType == "" && a.SportType == "" {
+		a.ActivityType = p.Defaults.ActivityType
+	}
+	if sportType := get(p.Columns.SportType); sportType != "" {
+		// Invalid Sport Types are reported by Verify.
+		a.SportType = sportType
+		if activityType, newSportType := bulk.ParseActivityTypeName(sportType); newSportType != "" {
+			a.SportType = newSportType
+		} else if activityType != "" {
+			a.SportType = activityType
+		}
+	}
+	activityType := bulk.EffectiveActivityType(a.ActivityType, a.SportType)
+	if activityType == "" {
+		return nil, fmt.Errorf("unknown activity type %q; add it to the profile's types, or set defaults.activity_type", typeName)
+	}
+
+	if a.Name == "" {
+		a.Name = p.Defaults.Name
+	}
+	if a.Name == "" {
+		a.Name = get(p.Columns.ActivityType)
+	}
+	if a.Description == "" {
+		a.Description = p.Defaults.Description
+	}
+	if a.GearID == "" {
+		a.GearID = p.Defaults.GearID
+	}
+
+	durationUnit := p.DurationUnit
+	if durationUnit == "" {
+		durationUnit = "s"
+	}
+	d, err := bulk.ParseDuration(get(p.Columns.Duration), durationUnit)
+	if err != nil {
+		return nil, err
+	}
+	a.Duration = bulk.ManualDuration(strconv.Itoa(int(d / time.Second)))
+
+	distanceUnit := p.TypeDistanceUnits[activityType]
+	if distanceUnit == "" {
+		distanceUnit = p.DistanceUnit
+	}
+	if distanceUnit == "" {
+		distanceUnit = "m"
+	}
+	distance, err := bulk.ParseDistance(get(p.Columns.Distance), distanceUnit)
+	if err != nil {
+		return nil, err
+	}
+	a.Distance = bulk.ManualDistance(strconv.FormatFloat(distance, 'f', -1, 32))
+	return a, nil
+}
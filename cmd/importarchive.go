@@ -0,0 +1,262 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vangent/stravacli/bulk"
+)
+
+// archiveActivitiesCSV is the name of the file listing the activities in a
+// Strava account export archive.
+const archiveActivitiesCSV = "activities.csv"
+
+func init() {
+	var accessToken string
+	var archive string
+	var opts uploadOptions
+
+	importArchiveCmd := &cobra.Command{
+		Use:   "import-archive",
+		Short: "Upload the activities in a Strava account export archive",
+		Long: `Upload the activities in a Strava account export archive.
+
+The archive is the .zip file you get from "Download or delete your account"
+in Strava's settings; it can also be a directory where it has been extracted.
+Each activity in its activities.csv that has an activity file is uploaded
+using the same pipeline as the upload command, including duplicate detection
+and the ledger; see "stravacli help upload".
+
+The Name, Activity Type, Description, and Commute? are copied from the
+archive. Gear is matched by name against the bikes and shoes of the account
+you're uploading to; gear that can't be matched is left blank. Manual
+activities (without an activity file) are skipped.
+
+Rows for --start_row are numbered among the activities with files, in the
+order they appear in activities.csv.
+
+See https://github.com/vangent/stravacli#import-an-account-export
+for detailed instructions.`,
+		Args: cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			switch bulk.OnDuplicate(opts.onDuplicate) {
+			case bulk.OnDuplicateSkip, bulk.OnDuplicateFail, bulk.OnDuplicateUpload:
+			default:
+				return invalidInput(fmt.Errorf("invalid --on_duplicate %q (should be skip, fail, or upload)", opts.onDuplicate))
+			}
+			return checkPartialSuccess(doImportArchive(accessToken, archive, &opts))
+		},
+	}
+	importArchiveCmd.Flags().StringVarP(&accessToken, "access_token", "t", "", "Strava access token; use the auth command to get one")
+	importArchiveCmd.MarkFlagRequired("access_token")
+	importArchiveCmd.Flags().StringVar(&archive, "in", "", "Strava export .zip file, or a directory where it was extracted")
+	importArchiveCmd.MarkFlagRequired("in")
+	importArchiveCmd.Flags().IntVar(&opts.startRow, "start_row", 1, "skip activities in the archive up to this row (row 0 is the header row)")
+	importArchiveCmd.Flags().BoolVar(&opts.dryRun, "dryrun", false, "do a dry run: print out proposed changes")
+	importArchiveCmd.Flags().StringVar(&opts.onDuplicate, "on_duplicate", string(bulk.OnDuplicateFail), "what to do with files that match an existing activity: skip, fail, or upload")
+	importArchiveCmd.Flags().DurationVar(&opts.timeout, "upload_timeout", 10*time.Minute, "how long to wait for Strava to process each upload; use \"stravacli uploads status\" to check on uploads that take longer")
+	importArchiveCmd.Flags().BoolVar(&opts.compress, "compress", false, "gzip uncompressed files of at least --compress_threshold bytes before uploading them")
+	importArchiveCmd.Flags().Int64Var(&opts.compressThreshold, "compress_threshold", 1<<20, "with --compress, the minimum size in bytes of files to compress")
+	importArchiveCmd.Flags().StringVar(&opts.ledgerFile, "ledger", defaultLedgerFile(), "file recording previous uploads, used to skip files that were already uploaded; empty to disable")
+	rootCmd.AddCommand(importArchiveCmd)
+}
+
+func doImportArchive(accessToken, archive string, opts *uploadOptions) (int, error) {
+	dir := archive
+	if info, err := os.Stat(archive); err != nil {
+		return 0, err
+	} else if !info.IsDir() {
+		tmpDir, err := ioutil.TempDir("", "stravacli-archive")
+		if err != nil {
+			return 0, err
+		}
+		defer os.RemoveAll(tmpDir)
+		if err := extractArchive(archive, tmpDir); err != nil {
+			return 0, err
+		}
+		dir = tmpDir
+	}
+
+	ctx, client := newClient(accessToken)
+	athlete, _, err := client.API().AthletesApi.GetLoggedInAthlete(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get athlete: %v", err)
+	}
+	gearIDs := map[string]string{}
+	for _, g := range append(athlete.Bikes, athlete.Shoes...) {
+		gearIDs[g.Name] = g.Id
+	}
+
+	activities, err := loadArchiveActivities(dir, gearIDs)
+	if err != nil {
+		return 0, err
+	}
+	return doUpload(accessToken, archive, activities, opts)
+}
+
+// extractArchive extracts activities.csv and the activity files from the
+// Strava export .zip file archive into dir.
+func extractArchive(archive, dir string) error {
+	r, err := zip.OpenReader(archive)
+	if err != nil {
+		return invalidInput(fmt.Errorf("failed to open %q: %v", archive, err))
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		name := filepath.Clean(filepath.FromSlash(f.Name))
+		if name != archiveActivitiesCSV && !strings.HasPrefix(name, "activities"+string(filepath.Separator)) {
+			continue
+		}
+		// The prefix check on the cleaned name already excludes names
+		// that would escape dir, like "activities/../../x".
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if err := extractArchiveFile(f, filepath.Join(dir, name)); err != nil {
+			return fmt.Errorf("failed to extract %q from %q: %v", f.Name, archive, err)
+		}
+	}
+	return nil
+}
+
+func extractArchiveFile(f *zip.File, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	in, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// loadArchiveActivities reads activities.csv from the extracted Strava
+// export in dir, and returns the activities that have activity files.
+// gearIDs maps gear names to IDs.
+//
+// The header of activities.csv has some duplicate column names, so it is
+// read by hand rather than with gocsv; the first column with each name is
+// used.
+func loadArchiveActivities(dir string, gearIDs map[string]string) ([]*bulk.UploadActivity, error) {
+	filename := filepath.Join(dir, archiveActivitiesCSV)
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, invalidInput(fmt.Errorf("failed to open %q: %v", filename, err))
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, invalidInput(fmt.Errorf("failed to parse %q: %v", filename, err))
+	}
+	cols := map[string]int{}
+	for i, name := range header {
+		name = strings.TrimPrefix(name, "\ufeff")
+		if _, ok := cols[name]; !ok {
+			cols[name] = i
+		}
+	}
+	for _, name := range []string{"Activity Name", "Activity Type", "Filename"} {
+		if _, ok := cols[name]; !ok {
+			return nil, invalidInput(fmt.Errorf("failed to parse %q: missing %q column; is this a Strava export?", filename, name))
+		}
+	}
+
+	var activities []*bulk.UploadActivity
+	var noFile int
+	unknownGear := map[string]bool{}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, invalidInput(fmt.Errorf("failed to parse %q: %v", filename, err))
+		}
+		get := func(name string) string {
+			if i, ok := cols[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		path := get("Filename")
+		if path == "" {
+			noFile++
+			continue
+		}
+		path = filepath.Join(dir, filepath.FromSlash(path))
+		a := &bulk.UploadActivity{
+			Name:        get("Activity Name"),
+			Description: get("Activity Description"),
+			Commute:     parseFlexibleBool(get("Commute")),
+			FileType:    bulk.FileTypeForPath(path),
+			Filename:    path,
+		}
+		a.ActivityType, a.SportType = bulk.ParseActivityTypeName(get("Activity Type"))
+		if gear := get("Activity Gear"); gear != "" {
+			if id, ok := gearIDs[gear]; ok {
+				a.GearID = id
+			} else {
+				unknownGear[gear] = true
+			}
+		}
+		activities = append(activities, a)
+	}
+	if noFile > 0 {
+		printf("Skipping %d manual activities without activity files.\n", noFile)
+	}
+	var unknown []string
+	for gear := range unknownGear {
+		unknown = append(unknown, gear)
+	}
+	sort.Strings(unknown)
+	for _, gear := range unknown {
+		printf("Gear %q doesn't match any of your bikes or shoes; leaving it blank.\n", gear)
+	}
+	if len(activities) == 0 {
+		return nil, errors.New("no activities with activity files found in the archive")
+	}
+	return activities, nil
+}
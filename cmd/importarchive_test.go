@@ -0,0 +1,125 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeZip writes a .zip file to path with the given files, which map names
+// to contents.
+func writeZip(t *testing.T, path string, files map[string]string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(f)
+	for name, s := range files {
+		fw, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractArchive(t *testing.T) {
+	dir, done := tempDir(t)
+	defer done()
+	archive := filepath.Join(dir, "export.zip")
+	writeZip(t, archive, map[string]string{
+		"activities.csv":         "Activity Name,Activity Type,Filename\n",
+		"activities/run..gpx":    testGPX,
+		"activities/../../x.gpx": testGPX,
+		"profile.csv":            "",
+	})
+	out := filepath.Join(dir, "out")
+	if err := extractArchive(archive, out); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"activities.csv", "activities/run..gpx"} {
+		if _, err := os.Stat(filepath.Join(out, filepath.FromSlash(name))); err != nil {
+			t.Errorf("%s wasn't extracted: %v", name, err)
+		}
+	}
+	for _, path := range []string{filepath.Join(dir, "x.gpx"), filepath.Join(out, "profile.csv")} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s was extracted", path)
+		}
+	}
+}
+
+func TestImportArchiveCommand(t *testing.T) {
+	fake, flags, done := newFakeStrava(0)
+	defer done()
+	dir, done2 := tempDir(t)
+	defer done2()
+	archive := filepath.Join(dir, "export.zip")
+	writeZip(t, archive, map[string]string{
+		"activities.csv": `Activity ID,Activity Name,Activity Type,Activity Description,Filename,Activity Gear
+1,Evening Ride,Ride,,activities/1.gpx,Zed Bike
+2,Second Ride,Ride,,activities/2.gpx,Alpha Bike
+3,Third Ride,Ride,,activities/3.gpx,Road Bike
+4,Treadmill,Run,,,
+`,
+		"activities/1.gpx": testGPX,
+		"activities/2.gpx": strings.Replace(testGPX, "2019-02-27", "2019-02-26", -1),
+		"activities/3.gpx": strings.Replace(testGPX, "2019-02-27", "2019-02-25", -1),
+	})
+	args := append([]string{"import-archive", "--in", archive, "--ledger", ""}, flags...)
+	out, err := runCommand(t, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Unknown gear is warned about in sorted order.
+	alpha, zed := strings.Index(out, `Gear "Alpha Bike"`), strings.Index(out, `Gear "Zed Bike"`)
+	if alpha < 0 || zed < alpha {
+		t.Errorf("got output %q, want warnings about Alpha Bike and then Zed Bike", out)
+	}
+	if !strings.Contains(out, "Skipping 1 manual activities") {
+		t.Errorf("got output %q, want the manual activity skipped", out)
+	}
+	activities := fake.Activities()
+	if len(activities) != 3 {
+		t.Fatalf("got %d activities, want 3", len(activities))
+	}
+	gear := map[string]string{}
+	for _, a := range activities {
+		gear[a.Name] = a.GearId
+	}
+	if gear["Third Ride"] != "b1" || gear["Evening Ride"] != "" {
+		t.Errorf("got gear %v, want b1 for only the Third Ride", gear)
+	}
+}
@@ -0,0 +1,65 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// cmdCtx is canceled when the user interrupts stravacli; see
+// handleInterrupts.
+var cmdCtx = context.Background()
+
+// interruptCount is the number of SIGINT/SIGTERM signals received.
+var interruptCount int32
+
+// handleInterrupts arranges for cmdCtx to be canceled on the first SIGINT
+// (Ctrl-C) or SIGTERM, so that the in-flight request is aborted and the
+// command can stop cleanly and say how to resume. A second signal exits
+// immediately.
+func handleInterrupts() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cmdCtx = ctx
+	ch := make(chan os.Signal, 2)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for range ch {
+			if atomic.AddInt32(&interruptCount, 1) > 1 {
+				fmt.Fprintln(os.Stderr, "Interrupted again; exiting immediately.")
+				os.Exit(exitInterrupted)
+			}
+			fmt.Fprintln(os.Stderr, "\nInterrupted; stopping (interrupt again to exit immediately)...")
+			cancel()
+		}
+	}()
+}
+
+// interrupted returns true if the user interrupted stravacli.
+func interrupted() bool {
+	return atomic.LoadInt32(&interruptCount) > 0
+}
@@ -0,0 +1,216 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/vangent/stravacli/bulk"
+)
+
+// outputFormat is set by the --output flag.
+var outputFormat = outputText
+
+// Valid values for --output.
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// jsonOutput returns true if --output=json.
+func jsonOutput() bool {
+	return outputFormat == outputJSON
+}
+
+// textOut is where human-readable progress goes: stdout, or stderr with
+// --output=json so that stdout only has JSON.
+func textOut() io.Writer {
+	if jsonOutput() {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// printf prints human-readable progress to textOut.
+func printf(format string, args ...interface{}) {
+	fmt.Fprintf(textOut(), format, args...)
+}
+
+// outputEvent is a line of --output=json output. There's a "row" event for
+// each input row that's processed, a "problem" event for each problem found
+// by validate, and a final "done" event.
+type outputEvent struct {
+	Event      string `json:"event"`
+	Row        int    `json:"row,omitempty"`
+	Activity   string `json:"activity,omitempty"`
+	Action     string `json:"action,omitempty"`
+	Status     string `json:"status,omitempty"`
+	Details    string `json:"details,omitempty"`
+	ActivityID int64  `json:"activity_id,omitempty"`
+	URL        string `json:"url,omitempty"`
+	UploadID   int64  `json:"upload_id,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Column     string `json:"column,omitempty"` // for "problem"
+
+	// For "done".
+	ExitCode *int                   `json:"exit_code,omitempty"`
+	Result   map[string]interface{} `json:"result,omitempty"`
+}
+
+var (
+	emitMu  sync.Mutex
+	results = map[string]interface{}{}
+)
+
+// emit writes e to stdout as a line of JSON.
+func emit(e *outputEvent) {
+	emitMu.Lock()
+	defer emitMu.Unlock()
+	b, err := json.Marshal(e)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(string(b))
+}
+
+// recordResult records a command-specific result (e.g., the # of
+// activities updated) for the "done" event.
+func recordResult(key string, value interface{}) {
+	emitMu.Lock()
+	defer emitMu.Unlock()
+	results[key] = value
+}
+
+// emitDone writes the final "done" event for a command that returned err.
+func emitDone(err error, code int) {
+	e := &outputEvent{Event: "done", Status: exitStatus[code], ExitCode: &code}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if perr, ok := err.(*partialError); ok {
+		recordResult("failed_row", perr.row)
+	}
+	emitMu.Lock()
+	if len(results) > 0 {
+		e.Result = results
+	}
+	emitMu.Unlock()
+	emit(e)
+}
+
+// rowEvent returns a "row" event for r.
+func rowEvent(r *bulk.RowResult) *outputEvent {
+	e := &outputEvent{
+		Event:      "row",
+		Row:        r.Row,
+		Action:     string(r.Action),
+		Status:     string(r.Status),
+		Details:    r.Details,
+		ActivityID: r.ActivityID,
+		URL:        r.URL,
+		UploadID:   r.UploadID,
+	}
+	if r.Activity != nil {
+		e.Activity = r.Activity.String()
+	}
+	if r.Err != nil {
+		e.Error = r.Err.Error()
+	}
+	return e
+}
+
+var (
+	completedMu   sync.Mutex
+	completedRows = map[int]bool{} // rows reported as completed
+)
+
+// noteCompleted records r's row if it was completed, for
+// completedRowsMessage.
+func noteCompleted(r *bulk.RowResult) {
+	if r.Row == 0 {
+		return
+	}
+	switch r.Status {
+	case bulk.StatusDone, bulk.StatusUnchanged, bulk.StatusSkipped, bulk.StatusDryRun, bulk.StatusSubmitted:
+	default:
+		return
+	}
+	completedMu.Lock()
+	defer completedMu.Unlock()
+	completedRows[r.Row] = true
+}
+
+// completedRowsMessage describes the rows reported as completed so far.
+func completedRowsMessage() string {
+	completedMu.Lock()
+	defer completedMu.Unlock()
+	var first, last int
+	for row := range completedRows {
+		if first == 0 || row < first {
+			first = row
+		}
+		if row > last {
+			last = row
+		}
+	}
+	switch {
+	case len(completedRows) == 0:
+		return "No rows were completed."
+	case first == last:
+		return fmt.Sprintf("Row %d was completed.", first)
+	case last-first+1 == len(completedRows):
+		return fmt.Sprintf("Rows %d-%d were completed.", first, last)
+	}
+	return fmt.Sprintf("%d rows between rows %d and %d were completed.", len(completedRows), first, last)
+}
+
+// reportProblem reports p, a problem found by validation: as a "problem"
+// event with --output=json, or by printing it otherwise.
+func reportProblem(p *bulk.Problem) {
+	if jsonOutput() {
+		status := "error"
+		if p.Warning {
+			status = "warning"
+		}
+		emit(&outputEvent{Event: "problem", Row: p.Row, Column: p.Column, Status: status, Error: p.Err.Error()})
+		return
+	}
+	fmt.Printf("  %v\n", p)
+}
+
+// reportRow reports r: as a "row" event with --output=json, or by printing
+// format and args otherwise (nothing if format is empty).
+func reportRow(r *bulk.RowResult, format string, args ...interface{}) {
+	noteCompleted(r)
+	if jsonOutput() {
+		emit(rowEvent(r))
+		return
+	}
+	if format != "" {
+		fmt.Printf(format, args...)
+	}
+}
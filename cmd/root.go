@@ -40,23 +40,72 @@ var rootCmd = &cobra.Command{
 	SilenceErrors: true,
 }
 
-// Execute adds all child commands to the root command and sets flags appropriately.
-// This is called by main.main(). It only needs to happen once to the rootCmd.
-func Execute() {
-	log.SetOutput(ioutil.Discard)
+// debug is set by the --debug flag.
+var debug bool
 
-	var debug bool
-	cobra.OnInitialize(func() {
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "enable verbose debug logging")
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", defaultConfigFile(), "path to the configuration file, which can also hold default flag values; see the config command")
+	rootCmd.PersistentFlags().StringVar(&apiBase, "api_base", defaultAPIBase, "base URL for the Strava API and OAuth endpoints")
+	rootCmd.PersistentFlags().MarkHidden("api_base")
+	rootCmd.PersistentFlags().StringVar(&recordDir, "record", "", "record all HTTP requests to Strava and their responses (with tokens redacted) in this directory")
+	rootCmd.PersistentFlags().StringVar(&replayDir, "replay", "", "serve HTTP requests to Strava from a directory written by --record instead of contacting Strava")
+	rootCmd.PersistentFlags().StringVar(&outputFormat, "output", outputText, "output format: text, or json for a JSON object per line on stdout (with progress on stderr)")
+	rootCmd.PersistentFlags().StringVar(&csvDelimiter, "csv_delimiter", "auto", "field delimiter for .csv files: auto (detect when reading, \",\" when writing), \",\", \";\", \"|\", or tab")
+	rootCmd.PersistentFlags().StringVar(&csvDecimal, "csv_decimal", "auto", "decimal separator for numbers in .csv files: auto (\",\" if the delimiter is \";\", else \".\"), \".\", or \",\"")
+	rootCmd.PersistentFlags().StringVar(&csvBooleans, "csv_booleans", "true/false", "how to write booleans in .csv files, like TRUE/FALSE or Yes/No; reading accepts any of these")
+	rootCmd.PersistentFlags().StringVar(&csvTimeLayout, "csv_time_layout", "", "Go time layout for Start in .csv files, like \"1/2/2006 15:04\"; reading also accepts RFC 3339 and common spreadsheet formats")
+	rootCmd.PersistentFlags().BoolVar(&csvBOM, "csv_bom", false, "write a UTF-8 byte order mark at the start of .csv files, for Excel")
+	rootCmd.PersistentPreRunE = func(c *cobra.Command, _ []string) error {
+		messages, err := applyFlagDefaults(c)
+		if err != nil {
+			return err
+		}
 		if debug {
 			log.SetOutput(os.Stderr)
 			log.SetPrefix("DEBUG: ")
 			log.SetFlags(0)
-			return
 		}
-	})
-	rootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "enable verbose debug logging")
-	if err := rootCmd.Execute(); err != nil {
+		for _, m := range messages {
+			log.Print(m)
+		}
+		if err := parseCSVDialect(); err != nil {
+			return err
+		}
+		switch outputFormat {
+		case outputText, outputJSON:
+		default:
+			return fmt.Errorf("invalid --output %q (should be text or json)", outputFormat)
+		}
+		return setupHTTPTransport()
+	}
+}
+
+// Execute adds all child commands to the root command and sets flags appropriately.
+// This is called by main.main(). It only needs to happen once to the rootCmd.
+func Execute() {
+	log.SetOutput(ioutil.Discard)
+	markRunStarted(rootCmd)
+	handleInterrupts()
+	err := rootCmd.Execute()
+	code := exitCode(err)
+	if jsonOutput() {
+		emitDone(err, code)
+	} else if err != nil {
 		fmt.Println(err)
-		os.Exit(1)
+	}
+	os.Exit(code)
+}
+
+// markRunStarted wraps the RunE of c and its subcommands to set runStarted.
+func markRunStarted(c *cobra.Command) {
+	if runE := c.RunE; runE != nil {
+		c.RunE = func(c *cobra.Command, args []string) error {
+			runStarted = true
+			return runE(c, args)
+		}
+	}
+	for _, sub := range c.Commands() {
+		markRunStarted(sub)
 	}
 }
@@ -0,0 +1,365 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vangent/strava"
+	"github.com/vangent/stravacli/bulk"
+)
+
+func init() {
+	var accessToken string
+	var rules []string
+	var rulesFile string
+	var fromStr, toStr string
+	var tz string
+	var outFile string
+	var dryRun bool
+
+	scheduleCmd := &cobra.Command{
+		Use:   "schedule",
+		Short: "Generate manual activities from recurrence rules",
+		Long: `Generate manual activities from recurrence rules.
+
+Each rule looks like:
+
+  Mon/Wed/Fri 07:00 WeightTraining 45m 'Gym'
+
+That is: the days of the week (separated by "/", or "daily", "weekdays", or
+"weekends"), the local start time, the Activity Type or Sport Type, the
+duration (like "45m" or "1:15:00"), an optional distance (like "2km"), and an
+optional name in quotes (defaulting to the type).
+
+The rules are expanded over the dates from --from to --to (inclusive). With
+--out, the activities are written to a .csv for use with uploadmanual;
+otherwise they are uploaded directly. If an access token is given,
+activities that already exist (an activity of the same type starting within
+30 minutes) are skipped, so if uploading stops partway, rerunning the same
+command continues where it left off.`,
+		Args: cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			if rulesFile != "" {
+				fileRules, err := readScheduleRules(rulesFile)
+				if err != nil {
+					return invalidInput(err)
+				}
+				rules = append(rules, fileRules...)
+			}
+			if len(rules) == 0 {
+				return invalidInput(errors.New("at least one --rule or --rules is required"))
+			}
+			if outFile == "" && accessToken == "" {
+				return invalidInput(errors.New("either --out or --access_token is required"))
+			}
+			return checkScheduleSuccess(doSchedule(accessToken, rules, fromStr, toStr, tz, outFile, dryRun))
+		},
+	}
+	scheduleCmd.Flags().StringVarP(&accessToken, "access_token", "t", "", "Strava access token; use the auth command to get one")
+	scheduleCmd.Flags().StringArrayVar(&rules, "rule", nil, "recurrence rule, like \"Mon/Wed/Fri 07:00 WeightTraining 45m 'Gym'\"; may be repeated")
+	scheduleCmd.Flags().StringVar(&rulesFile, "rules", "", "file with one recurrence rule per line; blank lines and lines starting with # are ignored")
+	scheduleCmd.Flags().StringVar(&fromStr, "from", "", "first date to generate activities for (YYYY-MM-DD)")
+	scheduleCmd.MarkFlagRequired("from")
+	scheduleCmd.Flags().StringVar(&toStr, "to", "", "last date to generate activities for (YYYY-MM-DD); defaults to today")
+	scheduleCmd.Flags().StringVar(&tz, "tz", "", "time zone for the rules' start times (e.g., \"America/Los_Angeles\"); defaults to the local time zone")
+	scheduleCmd.Flags().StringVar(&outFile, "out", "", "write the activities to this .csv file (\"-\" for stdout) for use with uploadmanual, instead of uploading")
+	scheduleCmd.Flags().BoolVar(&dryRun, "dryrun", false, "do a dry run: print out proposed changes")
+	rootCmd.AddCommand(scheduleCmd)
+}
+
+// scheduleMatchSlop is how close the start of an existing activity of the
+// same type must be to a scheduled activity for it to be skipped.
+const scheduleMatchSlop = 30 * time.Minute
+
+// scheduleRule is a parsed recurrence rule.
+type scheduleRule struct {
+	days         [7]bool // indexed by time.Weekday
+	hour, minute int
+	activityType string
+	sportType    string
+	duration     time.Duration
+	distance     float64 // meters
+	name         string
+}
+
+// scheduleDayGroups are the names for groups of days accepted in rules.
+var scheduleDayGroups = map[string][]time.Weekday{
+	"daily":    {time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday},
+	"weekdays": {time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+	"weekends": {time.Saturday, time.Sunday},
+}
+
+// parseScheduleRule parses a rule like
+// "Mon/Wed/Fri 07:00 WeightTraining 45m 'Gym'".
+func parseScheduleRule(s string) (*scheduleRule, error) {
+	fields, err := splitRuleFields(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rule %q: %v", s, err)
+	}
+	if len(fields) < 4 {
+		return nil, fmt.Errorf("invalid rule %q: should be like \"Mon/Wed/Fri 07:00 WeightTraining 45m 'Gym'\"", s)
+	}
+	r := &scheduleRule{}
+	for _, day := range strings.Split(fields[0], "/") {
+		if group, ok := scheduleDayGroups[strings.ToLower(day)]; ok {
+			for _, wd := range group {
+				r.days[wd] = true
+			}
+			continue
+		}
+		wd, err := parseWeekday(day)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule %q: %v", s, err)
+		}
+		r.days[wd] = true
+	}
+	clock, err := time.Parse("15:04", fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid rule %q: invalid start time %q (should be HH:MM)", s, fields[1])
+	}
+	r.hour, r.minute = clock.Hour(), clock.Minute()
+	r.activityType, r.sportType = bulk.ParseActivityTypeName(fields[2])
+	if r.activityType == "" && r.sportType == "" {
+		return nil, fmt.Errorf("invalid rule %q: unknown type %q; see \"stravacli types\"", s, fields[2])
+	}
+	if r.duration, err = bulk.ParseDuration(fields[3], "min"); err != nil {
+		return nil, fmt.Errorf("invalid rule %q: %v", s, err)
+	}
+	rest := fields[4:]
+	if len(rest) > 0 {
+		// A distance must have units, to tell it apart from a name.
+		if d, err := bulk.ParseDistance(rest[0], ""); err == nil {
+			r.distance = d
+			rest = rest[1:]
+		}
+	}
+	r.name = strings.Join(rest, " ")
+	if r.name == "" {
+		r.name = r.activityType + r.sportType // only one is set
+	}
+	return r, nil
+}
+
+// parseWeekday parses a day of the week like "Mon" or "Monday".
+func parseWeekday(s string) (time.Weekday, error) {
+	for wd := time.Sunday; wd <= time.Saturday; wd++ {
+		if strings.EqualFold(s, wd.String()) || strings.EqualFold(s, wd.String()[:3]) {
+			return wd, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid day %q (should be like Mon, daily, weekdays, or weekends)", s)
+}
+
+// splitRuleFields splits s into whitespace-separated fields; single or
+// double quotes group words into one field.
+func splitRuleFields(s string) ([]string, error) {
+	var fields []string
+	var cur strings.Builder
+	inField := false
+	var quote rune
+	for _, c := range s {
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			cur.WriteRune(c)
+		case c == '\'' || c == '"':
+			quote = c
+			inField = true
+		case c == ' ' || c == '\t':
+			if inField {
+				fields = append(fields, cur.String())
+				cur.Reset()
+				inField = false
+			}
+		default:
+			cur.WriteRune(c)
+			inField = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if inField {
+		fields = append(fields, cur.String())
+	}
+	return fields, nil
+}
+
+// readScheduleRules reads rules from filename, one per line.
+func readScheduleRules(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, invalidInput(fmt.Errorf("failed to open %q: %v", filename, err))
+	}
+	defer f.Close()
+	var rules []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rules = append(rules, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %q: %v", filename, err)
+	}
+	return rules, nil
+}
+
+func doSchedule(accessToken string, ruleStrs []string, fromStr, toStr, tz, outFile string, dryRun bool) (int, error) {
+	var rules []*scheduleRule
+	for _, s := range ruleStrs {
+		r, err := parseScheduleRule(s)
+		if err != nil {
+			return 0, invalidInput(err)
+		}
+		rules = append(rules, r)
+	}
+	loc := time.Local
+	if tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil {
+			return 0, invalidInput(fmt.Errorf("invalid --tz %q: %v", tz, err))
+		}
+	}
+	from, err := time.ParseInLocation(dayFormat, fromStr, loc)
+	if err != nil {
+		return 0, invalidInput(fmt.Errorf("invalid --from %q (should be YYYY-MM-DD): %v", fromStr, err))
+	}
+	now := time.Now().In(loc)
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	if toStr != "" {
+		if to, err = time.ParseInLocation(dayFormat, toStr, loc); err != nil {
+			return 0, invalidInput(fmt.Errorf("invalid --to %q (should be YYYY-MM-DD): %v", toStr, err))
+		}
+	}
+	if to.Before(from) {
+		return 0, invalidInput(errors.New("--to must not be before --from"))
+	}
+
+	activities := expandScheduleRules(rules, from, to)
+	if accessToken != "" {
+		ctx, client := newClient(accessToken)
+		if activities, err = skipScheduledExisting(ctx, client, activities, from, to.AddDate(0, 0, 1), loc); err != nil {
+			return 0, err
+		}
+	}
+	if outFile != "" {
+		return 0, writeActivitiesCSV(outFile, activities)
+	}
+	return uploadManualActivities(accessToken, "the schedule", activities, 1, dryRun)
+}
+
+// checkScheduleSuccess is like checkPartialSuccess, but for schedule, which
+// has no --start_row; rerunning it is enough to continue, since activities
+// that were already uploaded are skipped.
+func checkScheduleSuccess(row int, err error) error {
+	if err == nil {
+		return nil
+	}
+	if interrupted() {
+		err = fmt.Errorf("interrupted: %v", err)
+	}
+	if row <= 1 {
+		return err
+	}
+	return &partialError{row: row, err: fmt.Errorf("%v\n\nSome activities were uploaded before the error.\nFix the error and rerun the same command to continue; the activities that were uploaded will be skipped", err)}
+}
+
+// expandScheduleRules returns the activities for rules on the days from from
+// through to, in order of start time.
+func expandScheduleRules(rules []*scheduleRule, from, to time.Time) []*bulk.ManualActivity {
+	var activities []*bulk.ManualActivity
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		for _, r := range rules {
+			if !r.days[day.Weekday()] {
+				continue
+			}
+			start := time.Date(day.Year(), day.Month(), day.Day(), r.hour, r.minute, 0, 0, day.Location())
+			a := &bulk.ManualActivity{
+				Start:        bulk.ManualStart(start.UTC().Format(time.RFC3339)),
+				ActivityType: r.activityType,
+				SportType:    r.sportType,
+				Name:         r.name,
+				Duration:     bulk.ManualDuration(strconv.Itoa(int(r.duration / time.Second))),
+				Location:     from.Location(),
+			}
+			if r.distance != 0 {
+				a.Distance = bulk.ManualDistance(strconv.FormatFloat(r.distance, 'f', -1, 32))
+			}
+			activities = append(activities, a)
+		}
+	}
+	sort.SliceStable(activities, func(i, j int) bool {
+		return activities[i].Start < activities[j].Start
+	})
+	return activities
+}
+
+// skipScheduledExisting returns the activities that don't match an existing
+// activity between after and before: one of the same Activity Type that
+// starts within scheduleMatchSlop.
+func skipScheduledExisting(ctx context.Context, client *bulk.Client, activities []*bulk.ManualActivity, after, before time.Time, loc *time.Location) ([]*bulk.ManualActivity, error) {
+	existing := map[string][]time.Time{} // start times by Activity Type
+	err := client.ListActivities(ctx, listOptions(before.Add(scheduleMatchSlop), after.Add(-scheduleMatchSlop), 0), func(sa *strava.SummaryActivity) {
+		if sa.Type_ != nil {
+			existing[string(*sa.Type_)] = append(existing[string(*sa.Type_)], sa.StartDate)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list existing activities: %v", err)
+	}
+	var remaining []*bulk.ManualActivity
+	for _, a := range activities {
+		if start, _ := a.Start.Value(loc); matchesScheduled(start, existing[bulk.EffectiveActivityType(a.ActivityType, a.SportType)]) {
+			r := &bulk.RowResult{Activity: a, Action: bulk.ActionUpload, Status: bulk.StatusSkipped, Details: "already has a matching activity"}
+			reportRow(r, "  Skipping %v, there's already a matching activity...\n", a)
+			continue
+		}
+		remaining = append(remaining, a)
+	}
+	return remaining, nil
+}
+
+// matchesScheduled returns true if any of starts is within
+// scheduleMatchSlop of start.
+func matchesScheduled(start time.Time, starts []time.Time) bool {
+	for _, s := range starts {
+		if d := s.Sub(start); d <= scheduleMatchSlop && d >= -scheduleMatchSlop {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vangent/strava"
+)
+
+func TestParseScheduleRule(t *testing.T) {
+	tests := []struct {
+		rule    string
+		want    scheduleRule
+		days    string // the days the rule applies to, like "Mon Wed Fri"
+		wantErr bool
+	}{
+		{
+			rule: "Mon/Wed/Fri 07:00 WeightTraining 45m 'Gym'",
+			want: scheduleRule{hour: 7, activityType: "WeightTraining", duration: 45 * time.Minute, name: "Gym"},
+			days: "Mon Wed Fri",
+		},
+		{
+			rule: `weekends 9:30 Yoga 1:15:00 "Morning yoga"`,
+			want: scheduleRule{hour: 9, minute: 30, activityType: "Yoga", duration: 75 * time.Minute, name: "Morning yoga"},
+			days: "Sun Sat",
+		},
+		{
+			rule: "Tuesday 18:00 Run 30 2mi",
+			want: scheduleRule{hour: 18, activityType: "Run", duration: 30 * time.Minute, distance: 3218.688, name: "Run"},
+			days: "Tue",
+		},
+		{
+			rule: "daily 06:00 MountainBikeRide 1h Trails at dawn",
+			want: scheduleRule{hour: 6, sportType: "MountainBikeRide", duration: time.Hour, name: "Trails at dawn"},
+			days: "Sun Mon Tue Wed Thu Fri Sat",
+		},
+		{rule: "Mon 07:00 WeightTraining", wantErr: true},
+		{rule: "Someday 07:00 WeightTraining 45m", wantErr: true},
+		{rule: "Mon 7am WeightTraining 45m", wantErr: true},
+		{rule: "Mon 07:00 Jousting 45m", wantErr: true},
+		{rule: "Mon 07:00 WeightTraining soon", wantErr: true},
+		{rule: "Mon 07:00 WeightTraining 45m 'Gym", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := parseScheduleRule(test.rule)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%q: got error %v, want error %v", test.rule, err, test.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		var days []string
+		for wd, ok := range got.days {
+			if ok {
+				days = append(days, time.Weekday(wd).String()[:3])
+			}
+		}
+		got.days = [7]bool{}
+		if *got != test.want || strings.Join(days, " ") != test.days {
+			t.Errorf("%q: got %+v on %v, want %+v on %s", test.rule, *got, days, test.want, test.days)
+		}
+	}
+}
+
+func TestExpandScheduleRules(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	var rules []*scheduleRule
+	for _, s := range []string{"weekdays 18:00 Run 30m", "Mon/Sat 07:00 Yoga 1h"} {
+		r, err := parseScheduleRule(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, r)
+	}
+	// Friday through Monday.
+	from := time.Date(2019, 3, 8, 0, 0, 0, 0, loc)
+	activities := expandScheduleRules(rules, from, from.AddDate(0, 0, 3))
+	var got []string
+	for _, a := range activities {
+		got = append(got, string(a.Start)+" "+a.Name)
+	}
+	// DST starts on March 10, 2019.
+	want := []string{
+		"2019-03-08T23:00:00Z Run",
+		"2019-03-09T12:00:00Z Yoga",
+		"2019-03-11T11:00:00Z Yoga",
+		"2019-03-11T22:00:00Z Run",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestScheduleCommand(t *testing.T) {
+	fake, flags, done := newFakeStrava(0)
+	defer done()
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	add := func(start time.Time, name string) {
+		weightTraining := strava.WEIGHT_TRAINING_ActivityType
+		fake.AddActivity(strava.DetailedActivity{Name: name, Type_: &weightTraining, StartDate: start, ElapsedTime: 3600}, "")
+	}
+	// An unrelated evening session doesn't stop the morning one from being
+	// uploaded, but one at about the same time does.
+	add(time.Date(2019, 3, 4, 18, 0, 0, 0, time.UTC), "Evening lifting")
+	add(time.Date(2019, 3, 5, 7, 10, 0, 0, time.UTC), "Gym, a bit late")
+
+	rules := writeFile(t, dir, "rules.txt", "# Morning sessions.\ndaily 07:00 WeightTraining 45m 'Gym'\n")
+	args := append([]string{"schedule", "--rules", rules, "--from", "2019-03-04", "--to", "2019-03-05", "--tz", "UTC"}, flags...)
+	out, err := runCommand(t, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gym []time.Time
+	for _, a := range fake.Activities() {
+		if a.Name == "Gym" {
+			gym = append(gym, a.StartDate)
+		}
+	}
+	if want := time.Date(2019, 3, 4, 7, 0, 0, 0, time.UTC); len(gym) != 1 || !gym[0].Equal(want) {
+		t.Errorf("got Gym activities at %v, want one at %v; output:\n%s", gym, want, out)
+	}
+
+	// Rerunning skips what was uploaded.
+	if out, err = runCommand(t, args...); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(fake.Activities()); n != 3 {
+		t.Errorf("got %d activities after rerunning, want 3; output:\n%s", n, out)
+	}
+}
+
+func TestCheckScheduleSuccess(t *testing.T) {
+	err := checkScheduleSuccess(3, errors.New("failed"))
+	if _, ok := err.(*partialError); !ok {
+		t.Fatalf("got %T, want a *partialError", err)
+	}
+	if msg := err.Error(); strings.Contains(msg, "--start_row") || !strings.Contains(msg, "rerun the same command") {
+		t.Errorf("got message %q, want it to say to rerun without --start_row", msg)
+	}
+	if err := checkScheduleSuccess(1, errors.New("failed")); err.Error() != "failed" {
+		t.Errorf("failure on the first activity: got %v", err)
+	}
+}
@@ -0,0 +1,710 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"github.com/spf13/cobra"
+	"github.com/vangent/stravacli/bulk"
+)
+
+// tuiHelp describes the commands for tui.
+const tuiHelp = `Commands:
+  n, p      Show the next or previous page.
+  / TEXT    Only show activities whose date, type, name, or gear contain TEXT;
+            "/" alone shows all of them.
+  e N       Edit activity #N (or just enter N). Press Enter to keep a value,
+            "?" to list the numbered choices and "#N" to pick one, or "-" to
+            clear Sport Type or Gear ID.
+  r N       Revert the pending changes to activity #N.
+  d         Show the pending changes.
+  a         Apply the pending changes to Strava, after confirming.
+  q         Quit, after confirming if there are pending changes.
+  h         Show this help.
+`
+
+func init() {
+	var accessToken string
+	var inFile string
+	var maxActivities, pageSize int
+	var beforeStr, afterStr string
+
+	tuiCmd := &cobra.Command{
+		Use:   "tui",
+		Short: "Review and edit activities interactively",
+		Long: `Review and edit activities interactively.
+
+Lists activities, downloaded from Strava or read from a .csv written by
+download (--in), in a table that you can page through and filter. You can
+edit the Activity Type, Sport Type, Name, Workout Type, Gear ID, Commute?, and
+Trainer? of each activity; edits are checked the same way as for update, and
+kept as pending changes. Nothing is sent to Strava until you apply the
+pending changes and confirm.
+
+The choices for Gear ID are the gear used by the listed activities and the
+gear in the configuration file.
+
+` + tuiHelp,
+		Args: cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			if jsonOutput() {
+				return invalidInput(errors.New("tui is interactive, and doesn't support --output=json"))
+			}
+			if pageSize < 1 {
+				return invalidInput(fmt.Errorf("invalid --page_size %d (should be at least 1)", pageSize))
+			}
+			before, after, err := parseDayFlags(beforeStr, afterStr)
+			if err != nil {
+				return err
+			}
+			return doTUI(accessToken, inFile, maxActivities, pageSize, before, after)
+		},
+	}
+	tuiCmd.Flags().StringVarP(&accessToken, "access_token", "t", "", "Strava access token; use the auth command to get one")
+	tuiCmd.MarkFlagRequired("access_token")
+	tuiCmd.Flags().StringVar(&inFile, "in", "", ".csv file from download to list instead of downloading activities")
+	tuiCmd.Flags().IntVar(&maxActivities, "max", 0, "maximum # of activities to download (default 0 means no limit)")
+	tuiCmd.Flags().StringVar(&beforeStr, "before", "", "only download activities before this date (YYYY-MM-DD)")
+	tuiCmd.Flags().StringVar(&afterStr, "after", "", "only download activities after this date (YYYY-MM-DD)")
+	tuiCmd.Flags().IntVar(&pageSize, "page_size", 20, "# of activities to show per page")
+	rootCmd.AddCommand(tuiCmd)
+}
+
+func doTUI(accessToken, inFile string, maxActivities, pageSize int, before, after time.Time) error {
+	ctx, client := newClient(accessToken)
+	var activities []*bulk.Activity
+	var err error
+	if inFile != "" {
+		activities, err = loadUpdatableActivitiesFromCSV(inFile)
+	} else {
+		activities, err = client.Download(ctx, listOptions(before, after, maxActivities))
+	}
+	if err != nil {
+		return err
+	}
+	if len(activities) == 0 {
+		fmt.Println("No activities found.")
+		return nil
+	}
+	t := newTUI(readLines(os.Stdin), os.Stdout, activities, pageSize)
+	return t.run(func(orig, updated []*bulk.Activity) ([]*bulk.RowResult, error) {
+		return client.Update(ctx, orig, updated, &bulk.UpdateOptions{Progress: printRowResult})
+	})
+}
+
+// readLines returns a channel that receives the lines read from r, and is
+// closed at EOF. Reading happens in a goroutine so that prompts can stop
+// waiting when the command is interrupted.
+func readLines(r io.Reader) <-chan string {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	return lines
+}
+
+// prompt prints question to w and returns the next line from lines, with
+// surrounding spaces removed. It returns io.EOF at the end of the input, or
+// the context's error if the command is interrupted.
+func prompt(lines <-chan string, w io.Writer, question string) (string, error) {
+	fmt.Fprint(w, question)
+	select {
+	case line, ok := <-lines:
+		if !ok {
+			fmt.Fprintln(w)
+			return "", io.EOF
+		}
+		return strings.TrimSpace(line), nil
+	case <-cmdCtx.Done():
+		fmt.Fprintln(w)
+		return "", cmdCtx.Err()
+	}
+}
+
+// confirm asks question, returning true only if the answer is yes.
+func confirm(lines <-chan string, w io.Writer, question string) (bool, error) {
+	answer, err := prompt(lines, w, question+" [y/N] ")
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(answer) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
+
+// printChanges prints the changes to a, relative to prev, to w.
+func printChanges(w io.Writer, a, prev *bulk.Activity) {
+	for _, c := range a.Changes(prev) {
+		fmt.Fprintf(w, "    %s: %q -> %q\n", c.Column, c.Old, c.New)
+	}
+}
+
+// tui is the state of the tui command.
+type tui struct {
+	lines <-chan string
+	out   io.Writer
+	// orig holds the activities as they are on Strava, and edited holds
+	// them with the pending changes; the activity numbered N is at index
+	// N-1 in both.
+	orig, edited []*bulk.Activity
+	// gear maps the Gear IDs to choose from to their names, if known.
+	gear map[string]string
+	opts *bulk.ValidateOptions
+
+	filter   string
+	shown    []int // indexes of the activities that match filter
+	page     int
+	pageSize int
+}
+
+func newTUI(lines <-chan string, out io.Writer, activities []*bulk.Activity, pageSize int) *tui {
+	t := &tui{
+		lines:    lines,
+		out:      out,
+		gear:     map[string]string{},
+		opts:     &bulk.ValidateOptions{KnownGear: map[string]bool{}},
+		pageSize: pageSize,
+	}
+	for _, a := range activities {
+		edited := *a
+		t.orig = append(t.orig, a)
+		t.edited = append(t.edited, &edited)
+		if a.GearID != "" {
+			t.gear[a.GearID] = ""
+		}
+	}
+	if conf, err := loadConfig(); err == nil {
+		for id, gc := range conf.Gear {
+			t.gear[id] = gc.Name
+		}
+	}
+	for id := range t.gear {
+		t.opts.KnownGear[id] = true
+	}
+	t.setFilter("")
+	return t
+}
+
+// run runs the command loop until the user quits, using apply to send
+// changes to Strava.
+func (t *tui) run(apply func(orig, updated []*bulk.Activity) ([]*bulk.RowResult, error)) error {
+	t.printPage()
+	for {
+		line, err := prompt(t.lines, t.out, "> ")
+		if err == io.EOF {
+			line = "q"
+		} else if err != nil {
+			return t.stopped(err)
+		}
+		cmd, arg := line, ""
+		if strings.HasPrefix(line, "/") {
+			cmd, arg = "/", strings.TrimSpace(line[1:])
+		} else if i := strings.IndexByte(line, ' '); i >= 0 {
+			cmd, arg = line[:i], strings.TrimSpace(line[i+1:])
+		}
+		if _, err := strconv.Atoi(cmd); err == nil {
+			cmd, arg = "e", cmd
+		}
+		switch cmd {
+		case "":
+		case "n", "p":
+			delta := 1
+			if cmd == "p" {
+				delta = -1
+			}
+			if page := t.page + delta; page >= 0 && page < t.pages() {
+				t.page = page
+			}
+			t.printPage()
+		case "/":
+			t.setFilter(arg)
+			t.printPage()
+		case "e", "r":
+			i, err := t.activityIndex(arg)
+			if err != nil {
+				fmt.Fprintln(t.out, err)
+				continue
+			}
+			if cmd == "r" {
+				edited := *t.orig[i]
+				t.edited[i] = &edited
+				fmt.Fprintf(t.out, "Reverted #%d.\n", i+1)
+				continue
+			}
+			if err := t.edit(i); err != nil {
+				if err == io.EOF {
+					continue
+				}
+				return t.stopped(err)
+			}
+		case "d":
+			t.printPending()
+		case "a":
+			if err := t.apply(apply); err != nil {
+				return t.stopped(err)
+			}
+		case "q":
+			n := len(t.pending())
+			if n == 0 {
+				return nil
+			}
+			if err == io.EOF {
+				return fmt.Errorf("input ended with pending changes to %d activities; they were not applied", n)
+			}
+			ok, err := confirm(t.lines, t.out, fmt.Sprintf("Discard the pending changes to %d activities?", n))
+			if err != nil && err != io.EOF {
+				return t.stopped(err)
+			}
+			if ok {
+				return nil
+			}
+		case "h", "?":
+			fmt.Fprint(t.out, tuiHelp)
+		default:
+			fmt.Fprintf(t.out, "Unknown command %q; enter h for help.\n", cmd)
+		}
+	}
+}
+
+// stopped returns the error for stopping with err, mentioning any pending
+// changes.
+func (t *tui) stopped(err error) error {
+	if err == cmdCtx.Err() {
+		err = errors.New("interrupted")
+	}
+	if n := len(t.pending()); n > 0 {
+		return fmt.Errorf("%v; the pending changes to %d activities were not applied", err, n)
+	}
+	return err
+}
+
+// setFilter shows only the activities that match filter, starting from the
+// first page.
+func (t *tui) setFilter(filter string) {
+	t.filter = filter
+	t.shown = nil
+	t.page = 0
+	f := strings.ToLower(filter)
+	for i, a := range t.edited {
+		text := strings.ToLower(strings.Join([]string{a.Start.Format(dayFormat), a.ActivityType, a.SportType, a.Name, a.GearID, t.gear[a.GearID]}, "\x00"))
+		if strings.Contains(text, f) {
+			t.shown = append(t.shown, i)
+		}
+	}
+}
+
+// pages returns the number of pages of shown activities.
+func (t *tui) pages() int {
+	return (len(t.shown) + t.pageSize - 1) / t.pageSize
+}
+
+// pending returns the indexes of the activities with pending changes.
+func (t *tui) pending() []int {
+	var pending []int
+	for i, a := range t.edited {
+		if len(a.Changes(t.orig[i])) > 0 {
+			pending = append(pending, i)
+		}
+	}
+	return pending
+}
+
+// activityIndex returns the index for the activity number s.
+func (t *tui) activityIndex(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > len(t.edited) {
+		return 0, fmt.Errorf("Invalid activity number %q (should be from 1 to %d).", s, len(t.edited))
+	}
+	return n - 1, nil
+}
+
+// printPage prints the current page of shown activities.
+func (t *tui) printPage() {
+	if len(t.shown) == 0 {
+		fmt.Fprintf(t.out, "No activities match %q.\n", t.filter)
+		return
+	}
+	start := t.page * t.pageSize
+	end := start + t.pageSize
+	if end > len(t.shown) {
+		end = len(t.shown)
+	}
+	tw := tabwriter.NewWriter(t.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "#\tDATE\tTYPE\tNAME\tWORKOUT\tGEAR\tCOMMUTE\tTRAINER")
+	for _, i := range t.shown[start:end] {
+		a := t.edited[i]
+		num := strconv.Itoa(i + 1)
+		if len(a.Changes(t.orig[i])) > 0 {
+			num += "*"
+		}
+		activityType := a.ActivityType
+		if a.SportType != "" {
+			activityType = a.SportType
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", num, a.Start.Format(dayFormat), activityType, truncate(a.Name, 40), a.WorkoutType, t.gearName(a.GearID), yesOrBlank(a.Commute), yesOrBlank(a.Trainer))
+	}
+	tw.Flush()
+	matching := ""
+	if t.filter != "" {
+		matching = fmt.Sprintf(" matching %q", t.filter)
+	}
+	fmt.Fprintf(t.out, "Page %d of %d (%d of %d activities%s, %d with pending changes); enter h for help.\n", t.page+1, t.pages(), len(t.shown), len(t.edited), matching, len(t.pending()))
+}
+
+// gearName returns id with its name, if it's known.
+func (t *tui) gearName(id string) string {
+	if name := t.gear[id]; name != "" {
+		return fmt.Sprintf("%s (%s)", truncate(name, 20), id)
+	}
+	return id
+}
+
+// truncate returns s shortened to at most n runes.
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n-3]) + "..."
+}
+
+func yesOrBlank(b bool) string {
+	if b {
+		return "yes"
+	}
+	return ""
+}
+
+// printPending prints the pending changes.
+func (t *tui) printPending() {
+	pending := t.pending()
+	if len(pending) == 0 {
+		fmt.Fprintln(t.out, "No pending changes.")
+		return
+	}
+	for _, i := range pending {
+		fmt.Fprintf(t.out, "#%d %v\n", i+1, t.orig[i])
+		printChanges(t.out, t.edited[i], t.orig[i])
+	}
+}
+
+// tuiField is a field of an activity that can be edited.
+type tuiField struct {
+	name string
+	get  func(a *bulk.Activity) string
+	// set sets the field from s, returning an error if s isn't valid.
+	set func(a *bulk.Activity, s string) error
+	// choices, if not nil, returns the values to choose from.
+	choices func(a *bulk.Activity) []string
+	// clearable fields can be cleared with "-".
+	clearable bool
+}
+
+// fields returns the editable fields.
+func (t *tui) fields() []*tuiField {
+	return []*tuiField{
+		{
+			name: "Activity Type",
+			get:  func(a *bulk.Activity) string { return a.ActivityType },
+			set: func(a *bulk.Activity, s string) error {
+				activityType, sportType := bulk.ParseActivityTypeName(s)
+				if activityType == "" {
+					if sportType != "" {
+						return fmt.Errorf("%q is a Sport Type; set it as the Sport Type instead", sportType)
+					}
+					return fmt.Errorf("invalid Activity Type %q", s)
+				}
+				a.ActivityType = activityType
+				return nil
+			},
+			choices: func(*bulk.Activity) []string { return activityTypes() },
+		},
+		{
+			name: "Sport Type",
+			get:  func(a *bulk.Activity) string { return a.SportType },
+			set: func(a *bulk.Activity, s string) error {
+				if s == "" {
+					a.SportType = ""
+					return nil
+				}
+				activityType, sportType := bulk.ParseActivityTypeName(s)
+				if sportType == "" {
+					sportType = activityType
+				}
+				if sportType == "" {
+					return fmt.Errorf("invalid Sport Type %q", s)
+				}
+				a.SportType = sportType
+				return nil
+			},
+			choices: func(a *bulk.Activity) []string {
+				var choices []string
+				for sportType, activityType := range bulk.SportTypes() {
+					if activityType == a.ActivityType {
+						choices = append(choices, sportType)
+					}
+				}
+				sort.Strings(choices)
+				return choices
+			},
+			clearable: true,
+		},
+		{
+			name: "Name",
+			get:  func(a *bulk.Activity) string { return a.Name },
+			set: func(a *bulk.Activity, s string) error {
+				a.Name = s
+				return nil
+			},
+		},
+		{
+			name: "Workout Type",
+			get:  func(a *bulk.Activity) string { return string(a.WorkoutType) },
+			set: func(a *bulk.Activity, s string) error {
+				w := bulk.WorkoutType(s)
+				if _, err := w.Value(bulk.EffectiveActivityType(a.ActivityType, a.SportType)); err != nil {
+					return err
+				}
+				a.WorkoutType = w
+				return nil
+			},
+			choices: func(a *bulk.Activity) []string {
+				return bulk.WorkoutTypeNames(bulk.EffectiveActivityType(a.ActivityType, a.SportType))
+			},
+		},
+		{
+			name: "Gear ID",
+			get:  func(a *bulk.Activity) string { return a.GearID },
+			set: func(a *bulk.Activity, s string) error {
+				a.GearID = s
+				return nil
+			},
+			choices: func(*bulk.Activity) []string {
+				var choices []string
+				for id := range t.gear {
+					choices = append(choices, id)
+				}
+				sort.Strings(choices)
+				return choices
+			},
+			clearable: true,
+		},
+		{
+			name: "Commute?",
+			get:  func(a *bulk.Activity) string { return strconv.FormatBool(a.Commute) },
+			set:  func(a *bulk.Activity, s string) error { return parseYesNo(s, &a.Commute) },
+		},
+		{
+			name: "Trainer?",
+			get:  func(a *bulk.Activity) string { return strconv.FormatBool(a.Trainer) },
+			set:  func(a *bulk.Activity, s string) error { return parseYesNo(s, &a.Trainer) },
+		},
+	}
+}
+
+// activityTypes returns the valid Activity Types, sorted.
+func activityTypes() []string {
+	var types []string
+	for sportType, activityType := range bulk.SportTypes() {
+		if sportType == activityType {
+			types = append(types, activityType)
+		}
+	}
+	sort.Strings(types)
+	return types
+}
+
+// parseYesNo sets *b from s, which may be yes/no, y/n, or true/false.
+func parseYesNo(s string, b *bool) error {
+	switch strings.ToLower(s) {
+	case "y", "yes", "true":
+		*b = true
+	case "n", "no", "false":
+		*b = false
+	default:
+		return fmt.Errorf("invalid value %q (should be yes or no)", s)
+	}
+	return nil
+}
+
+// edit prompts for new values for the fields of the activity at index i,
+// and keeps them as pending changes if they are valid.
+func (t *tui) edit(i int) error {
+	prev := t.orig[i]
+	a := *t.edited[i]
+	fmt.Fprintf(t.out, "Editing #%d %v; press Enter to keep a value, or enter ? for choices.\n", i+1, prev)
+	for {
+		for _, f := range t.fields() {
+			if err := t.editField(&a, f); err != nil {
+				return err
+			}
+		}
+		problems := a.Problems(prev, t.opts)
+		for _, p := range problems {
+			kind := "Error"
+			if p.Warning {
+				kind = "Warning"
+			}
+			fmt.Fprintf(t.out, "  %s in %s: %v\n", kind, p.Column, p.Err)
+		}
+		if !bulk.HasErrors(problems) {
+			break
+		}
+		ok, err := confirm(t.lines, t.out, "Fix the errors?")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Fprintf(t.out, "Discarded the changes to #%d.\n", i+1)
+			return nil
+		}
+	}
+	t.edited[i] = &a
+	if changes := a.Changes(prev); len(changes) > 0 {
+		fmt.Fprintf(t.out, "#%d has %d pending changes; enter a to apply them.\n", i+1, len(changes))
+	}
+	return nil
+}
+
+// editField prompts for a new value for the field f of a until a valid
+// value is entered.
+func (t *tui) editField(a *bulk.Activity, f *tuiField) error {
+	for {
+		s, err := prompt(t.lines, t.out, fmt.Sprintf("  %s [%s]: ", f.name, f.get(a)))
+		if err != nil {
+			return err
+		}
+		var choices []string
+		if f.choices != nil {
+			choices = f.choices(a)
+		}
+		switch {
+		case s == "":
+			return nil
+		case s == "?":
+			if len(choices) == 0 {
+				fmt.Fprintln(t.out, "  There's nothing to choose from; enter a value.")
+				continue
+			}
+			t.printChoices(f, choices)
+			continue
+		case s == "-" && f.clearable:
+			s = ""
+		case strings.HasPrefix(s, "#") && len(choices) > 0:
+			// Choices are picked with "#N", so that plain numbers like
+			// Workout Type 1 keep their meaning.
+			n, err := strconv.Atoi(s[1:])
+			if err != nil || n < 1 || n > len(choices) {
+				fmt.Fprintf(t.out, "  There's no choice %s; enter ? to list them.\n", s)
+				continue
+			}
+			s = choices[n-1]
+		}
+		if err := f.set(a, s); err != nil {
+			fmt.Fprintf(t.out, "  %v\n", err)
+			continue
+		}
+		return nil
+	}
+}
+
+// printChoices prints the choices for f, in columns, numbered for "#N".
+func (t *tui) printChoices(f *tuiField, choices []string) {
+	const columns = 4
+	rows := (len(choices) + columns - 1) / columns
+	tw := tabwriter.NewWriter(t.out, 0, 0, 2, ' ', 0)
+	for row := 0; row < rows; row++ {
+		fmt.Fprint(tw, " ")
+		for n := row; n < len(choices); n += rows {
+			label := choices[n]
+			if f.name == "Gear ID" && t.gear[label] != "" {
+				label = fmt.Sprintf("%s (%s)", label, t.gear[label])
+			}
+			fmt.Fprintf(tw, "\t%4s %s", fmt.Sprintf("#%d", n+1), label)
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+}
+
+// apply sends the pending changes to Strava using apply, after confirming.
+// Changes that were applied are no longer pending. It only returns an error
+// if the command was interrupted.
+func (t *tui) apply(apply func(orig, updated []*bulk.Activity) ([]*bulk.RowResult, error)) error {
+	pending := t.pending()
+	if len(pending) == 0 {
+		fmt.Fprintln(t.out, "No pending changes.")
+		return nil
+	}
+	t.printPending()
+	ok, err := confirm(t.lines, t.out, fmt.Sprintf("Apply the changes to %d activities to Strava?", len(pending)))
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	var orig, updated []*bulk.Activity
+	index := map[*bulk.Activity]int{}
+	for _, i := range pending {
+		a := *t.edited[i]
+		orig = append(orig, t.orig[i])
+		updated = append(updated, &a)
+		index[&a] = i
+	}
+	results, err := apply(orig, updated)
+	n := 0
+	for _, r := range results {
+		if r.Status != bulk.StatusDone {
+			continue
+		}
+		i := index[r.Activity.(*bulk.Activity)]
+		applied := *t.edited[i]
+		t.orig[i] = &applied
+		n++
+	}
+	fmt.Fprintf(t.out, "Applied the changes to %d activities.\n", n)
+	if err != nil {
+		if interrupted() {
+			return err
+		}
+		fmt.Fprintf(t.out, "Failed to apply the remaining changes: %v\n", err)
+	}
+	return nil
+}
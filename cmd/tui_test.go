@@ -0,0 +1,66 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/vangent/stravacli/bulk"
+)
+
+func TestTUIEditWorkoutType(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bulk.WorkoutType
+	}{
+		// Plain numbers are Strava's numbers, not choices.
+		{"1", "1"},
+		{"3", "3"},
+		{"Race", "Race"},
+		// The choices for a Run are None, Race, LongRun, and Workout.
+		{"#2", "Race"},
+		{"#4", "Workout"},
+		// Invalid choices and values are reported, and prompted for again.
+		{"#5\n#0\nTempo\nLongRun", "LongRun"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		a := &bulk.Activity{ID: 1, ActivityType: "Run", Name: "Run"}
+		var out bytes.Buffer
+		tui := newTUI(readLines(strings.NewReader(test.input+"\n")), &out, []*bulk.Activity{a}, 10)
+		var field *tuiField
+		for _, f := range tui.fields() {
+			if f.name == "Workout Type" {
+				field = f
+			}
+		}
+		if err := tui.editField(a, field); err != nil {
+			t.Fatalf("%q: %v", test.input, err)
+		}
+		if a.WorkoutType != test.want {
+			t.Errorf("%q: got Workout Type %q, want %q\n%s", test.input, a.WorkoutType, test.want, out.String())
+		}
+	}
+}
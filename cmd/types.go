@@ -0,0 +1,67 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/vangent/stravacli/bulk"
+)
+
+func init() {
+	typesCmd := &cobra.Command{
+		Use:   "types",
+		Short: "List the valid Activity Types and Sport Types",
+		Long: `List the valid Activity Types and Sport Types.
+
+Sport Types are more specific than Activity Types; for example, "TrailRun" and
+"Run" are both Sport Types for the "Run" Activity Type. If both the Activity
+Type and Sport Type columns are set, they must match; if only Sport Type is
+set, the Activity Type is derived from it.
+`,
+		Args: cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			return doTypes()
+		},
+	}
+	rootCmd.AddCommand(typesCmd)
+}
+
+func doTypes() error {
+	sportTypes := bulk.SportTypes()
+	var names []string
+	for sportType := range sportTypes {
+		names = append(names, sportType)
+	}
+	sort.Strings(names)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SPORT TYPE\tACTIVITY TYPE")
+	for _, sportType := range names {
+		fmt.Fprintf(tw, "%s\t%s\n", sportType, sportTypes[sportType])
+	}
+	return tw.Flush()
+}
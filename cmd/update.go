@@ -25,14 +25,11 @@ package cmd
 import (
 	"context"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
 
-	"github.com/antihax/optional"
-	"github.com/gocarina/gocsv"
 	"github.com/spf13/cobra"
-	"github.com/vangent/strava"
+	"github.com/vangent/stravacli/bulk"
 )
 
 func init() {
@@ -50,7 +47,8 @@ func init() {
 See https://github.com/vangent/stravacli/#update-existing-activities
 for detailed instructions.
 
-See "stravacli help download" for info about the data columns.
+See "stravacli help download" for info about the data columns. Only the ID
+column is required in --updated; columns that are left out are unchanged.
 
 `,
 		Args: cobra.NoArgs,
@@ -69,100 +67,109 @@ See "stravacli help download" for info about the data columns.
 	rootCmd.AddCommand(updateCmd)
 }
 
-func loadUpdatableActivitiesFromCSV(filename string) ([]*updatableActivity, error) {
+func loadUpdatableActivitiesFromCSV(filename string) ([]*bulk.Activity, error) {
 	f, err := os.Open(filename)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open %q: %v", filename, err)
+		return nil, invalidInput(fmt.Errorf("failed to open %q: %v", filename, err))
 	}
 	defer f.Close()
-	var activities []*updatableActivity
-	if err := gocsv.UnmarshalFile(f, &activities); err != nil {
-		return nil, fmt.Errorf("failed to parse %q: %v", filename, err)
+	activities, err := bulk.ReadActivities(f, dialect)
+	if err != nil {
+		return nil, invalidInput(fmt.Errorf("failed to parse %q: %v", filename, err))
 	}
 	return activities, nil
 }
 
 func doUpdate(accessToken, origFile, updatedFile string, startRow int, dryRun bool) (int, error) {
-	activities, err := loadUpdatableActivitiesFromCSV(origFile)
+	orig, err := loadUpdatableActivitiesFromCSV(origFile)
 	if err != nil {
 		return 0, err
 	}
-	orig := map[int64]*updatableActivity{}
-	for _, a := range activities {
-		orig[a.ID] = a
+	if err := checkCSV(csvForUpdate, updatedFile, orig, nil, startRow); err != nil {
+		return 0, err
 	}
-
-	activities, err = loadUpdatableActivitiesFromCSV(updatedFile)
+	activities, err := loadUpdatableActivitiesFromCSV(updatedFile)
 	if err != nil {
 		return 0, err
 	}
-
 	if len(activities) != len(orig) {
-		return 0, fmt.Errorf("%q has %d activities, but %q has %d; for update, they should be the same", origFile, len(orig), updatedFile, len(activities))
+		return 0, invalidInput(fmt.Errorf("%q has %d activities, but %q has %d; for update, they should be the same", origFile, len(orig), updatedFile, len(activities)))
 	}
-	ctx := context.WithValue(context.Background(), strava.ContextAccessToken, accessToken)
-	apiSvc := strava.NewAPIClient(strava.NewConfiguration()).ActivitiesApi
+	ctx, client := newClient(accessToken)
 
-	fmt.Printf("Found %d activities%s....\n", len(activities), startRowMessage(len(activities), startRow))
+	printf("Found %d activities%s....\n", len(activities), startRowMessage(len(activities), startRow))
+	results, err := client.Update(ctx, orig, activities, &bulk.UpdateOptions{
+		StartRow: startRow,
+		DryRun:   dryRun,
+		Progress: printRowResult,
+	})
+	if err != nil {
+		return rowForError(err), err
+	}
 	n := 0
-	for i, a := range activities {
-		row := i + 1 // row 0 is the header row
-		if row < startRow {
-			continue
+	for _, r := range results {
+		if r.Status != bulk.StatusUnchanged {
+			n++
 		}
-		prev := orig[a.ID]
-		if prev == nil {
-			return row, fmt.Errorf("activity ID %d from %q not found in %q", a.ID, updatedFile, origFile)
-		}
-		if *prev == *a {
-			log.Printf("no change for ID %d", a.ID)
-			continue
-		}
-		if err := updateOne(ctx, apiSvc, a, prev, dryRun); err != nil {
-			return row, fmt.Errorf("failed to update activity %v: %v", a, err)
-		}
-		n++
 	}
 	if dryRun {
-		fmt.Printf("Found %d activities to be updated.\n", n)
+		printf("Found %d activities to be updated.\n", n)
 	} else {
-		fmt.Printf("Updated %d activities.\n", n)
+		printf("Updated %d activities.\n", n)
+		recordResult("updated", n)
 	}
 	return 0, nil
 }
 
-func updateOne(ctx context.Context, apiSvc *strava.ActivitiesApiService, a, prev *updatableActivity, dryRun bool) error {
-	if err := a.Verify(prev); err != nil {
-		return err
-	}
-	if dryRun {
-		fmt.Printf("  Would update %v...\n", a)
-		return nil
+// printRowResult reports progress for a row processed by a bulk operation.
+func printRowResult(r *bulk.RowResult) {
+	noteCompleted(r)
+	if jsonOutput() {
+		emit(rowEvent(r))
+		return
+	}
+	switch r.Status {
+	case bulk.StatusDryRun:
+		fmt.Printf("  Would %s %v%s...\n", r.Action, r.Activity, r.Details)
+	case bulk.StatusStarted:
+		verb := "Updating"
+		if r.Action == bulk.ActionUpload {
+			verb = "Uploading"
+		}
+		fmt.Printf("  %s %v%s...\n", verb, r.Activity, r.Details)
+	case bulk.StatusDone:
+		if r.URL != "" {
+			fmt.Printf("  --> %s\n", r.URL)
+		}
+	case bulk.StatusUnchanged:
+		log.Printf("no change for %v", r.Activity)
+	case bulk.StatusCanceled:
+		fmt.Printf("  Interrupted while processing %v; it may or may not have been applied\n", r.Activity)
 	}
-	fmt.Printf("  Updating %v...\n", a)
-	activityType := strava.ActivityType(a.ActivityType)
-	if !validActivityType[a.ActivityType] {
-		return fmt.Errorf("invalid Activity Type %q", a.ActivityType)
+}
+
+// interruptedError returns the error for a command that was interrupted
+// with err on row (0 if it wasn't processing rows), saying what was done
+// and how to resume. The details of err were already reported with the row.
+func interruptedError(row int, err error) error {
+	if row <= 0 {
+		return fmt.Errorf("interrupted: %v", err)
 	}
-	update := strava.UpdatableActivity{
-		Name:        a.Name,
-		Type_:       &activityType,
-		WorkoutType: a.WorkoutType,
-		GearId:      a.GearID,
-		Commute:     a.Commute,
-		Trainer:     a.Trainer,
+	done := completedRowsMessage()
+	if rerr, ok := err.(*bulk.RowError); ok && rerr.Err == context.Canceled {
+		// Interrupted between rows, so row wasn't started.
+		return &partialError{row: row, err: fmt.Errorf("interrupted before row %d (row 0 is the header row).\n\n%s\nRerun with '--start_row=%d' to continue", row, done, row)}
 	}
-	detailedActivity, resp, err := apiSvc.UpdateActivityById(ctx, a.ID, &strava.UpdateActivityByIdOpts{Body: optional.NewInterface(update)})
-	if err != nil {
-		var msg string
-		if resp != nil {
-			body, _ := ioutil.ReadAll(resp.Body)
-			msg = string(body)
-		}
-		return fmt.Errorf("%v %s", err, msg)
+	return &partialError{row: row, err: fmt.Errorf("interrupted while processing row %d (row 0 is the header row).\n\n%s Row %d may or may not have been processed.\nCheck it and rerun with '--start_row=%d' to retry it, or with '--start_row=%d' to skip it", row, done, row, row, row+1)}
+}
+
+// rowForError returns the row for err if it's a *bulk.RowError, for
+// checkPartialSuccess.
+func rowForError(err error) int {
+	if rerr, ok := err.(*bulk.RowError); ok {
+		return rerr.Row
 	}
-	fmt.Printf("  --> https://www.strava.com/activities/%d\n", detailedActivity.Id)
-	return nil
+	return 0
 }
 
 func startRowMessage(n, startRow int) string {
@@ -181,10 +188,13 @@ func checkPartialSuccess(row int, err error) error {
 		// Success! Nothing to do.
 		return nil
 	}
+	if interrupted() {
+		return interruptedError(row, err)
+	}
 	if row <= 1 {
 		// Failed, but failed on or before the first row; nothing to do.
 		return err
 	}
 	// Tell the user how to restart.
-	return fmt.Errorf("%v\n\nSome rows were successfully processed, but there was an error on row %d (row 0 is the header row).\nFix the error and rerun with '--start_row=%d' to retry, or rerun with '--start_row=%d' to skip over the bad row", err, row, row, row+1)
+	return &partialError{row: row, err: fmt.Errorf("%v\n\nSome rows were successfully processed, but there was an error on row %d (row 0 is the header row).\nFix the error and rerun with '--start_row=%d' to retry, or rerun with '--start_row=%d' to skip over the bad row", err, row, row, row+1)}
 }
@@ -23,25 +23,29 @@ THE SOFTWARE.
 package cmd
 
 import (
-	"context"
+	"bytes"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"reflect"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/antihax/optional"
-	"github.com/gocarina/gocsv"
 	"github.com/spf13/cobra"
-	"github.com/vangent/strava"
+	"github.com/vangent/stravacli/bulk"
 )
 
 func init() {
 	var accessToken string
 	var inFile string
-	var startRow int
-	var dryRun bool
+	var dir string
+	var recursive bool
+	var defaultType string
+	var emitCSV string
+	var opts uploadOptions
 
 	uploadCmd := &cobra.Command{
 		Use:   "upload",
@@ -49,212 +53,241 @@ func init() {
 		Long: `Upload new Strava activities.
 
 See https://github.com/vangent/stravacli#upload-activities
-for detailed instructions.`,
+for detailed instructions.
+
+Instead of --in, you can use --dir to upload all of the .fit, .tcx, and .gpx
+files (optionally gzipped) in a directory. The File Type is inferred from the
+file extension, and the Name and Activity Type from the file contents where
+possible (falling back to the filename and --type). Use --emit_csv to write
+the generated .csv for review instead of uploading; you can then edit it and
+upload it with --in.
+
+Each uploaded file is recorded in a local ledger (see --ledger), keyed by a
+hash of its contents, and files that were already uploaded are skipped, so
+it's safe to re-run an upload. If the External ID column is blank, an ID
+derived from the hash is used.
+
+All of the files are submitted first, and then Strava is polled until it
+finishes processing them (see --upload_timeout). Uploads that are still being
+processed are recorded in the ledger; use "stravacli uploads status" to check
+on them later.
+
+Use --compress to speed up uploads of large .fit, .tcx, and .gpx files; they
+are gzipped on the fly, and the File Type is adjusted automatically.`,
 		Args: cobra.NoArgs,
 		RunE: func(_ *cobra.Command, _ []string) error {
-			return checkPartialSuccess(doUpload(accessToken, inFile, startRow, dryRun))
+			if (inFile == "") == (dir == "") {
+				return invalidInput(errors.New("exactly one of --in or --dir is required"))
+			}
+			var activities []*bulk.UploadActivity
+			var err error
+			source := inFile
+			if dir != "" {
+				source = dir
+				activities, err = bulk.FindActivityFiles(dir, recursive, defaultType)
+			} else if err = checkCSV(csvForUpload, inFile, nil, nil, opts.startRow); err == nil {
+				activities, err = loadActivitiesFromCSV(inFile)
+			}
+			if err != nil {
+				return err
+			}
+			if emitCSV != "" {
+				return writeActivitiesCSV(emitCSV, activities)
+			}
+			switch bulk.OnDuplicate(opts.onDuplicate) {
+			case bulk.OnDuplicateSkip, bulk.OnDuplicateFail, bulk.OnDuplicateUpload:
+			default:
+				return invalidInput(fmt.Errorf("invalid --on_duplicate %q (should be skip, fail, or upload)", opts.onDuplicate))
+			}
+			return checkPartialSuccess(doUpload(accessToken, source, activities, &opts))
 		},
 	}
 	uploadCmd.Flags().StringVarP(&accessToken, "access_token", "t", "", "Strava access token; use the auth command to get one")
-	uploadCmd.MarkFlagRequired("access_token")
 	uploadCmd.Flags().StringVar(&inFile, "in", "", ".csv with activities to upload")
-	uploadCmd.MarkFlagRequired("in")
-	uploadCmd.Flags().IntVar(&startRow, "start_row", 1, "skip rows in the input up to this row (row 0 is the header row)")
-	uploadCmd.Flags().BoolVar(&dryRun, "dryrun", false, "do a dry run: print out proposed changes")
+	uploadCmd.Flags().StringVar(&dir, "dir", "", "directory with activity files to upload, instead of --in")
+	uploadCmd.Flags().BoolVar(&recursive, "recursive", false, "with --dir, also upload files in subdirectories")
+	uploadCmd.Flags().StringVar(&defaultType, "type", "", "with --dir, the Activity Type to use when it can't be inferred from the file")
+	uploadCmd.Flags().StringVar(&emitCSV, "emit_csv", "", "write the activities to upload to this .csv file (\"-\" for stdout) instead of uploading")
+	uploadCmd.Flags().IntVar(&opts.startRow, "start_row", 1, "skip rows in the input up to this row (row 0 is the header row)")
+	uploadCmd.Flags().BoolVar(&opts.dryRun, "dryrun", false, "do a dry run: print out proposed changes")
+	uploadCmd.Flags().StringVar(&opts.onDuplicate, "on_duplicate", string(bulk.OnDuplicateFail), "what to do with files that match an existing activity: skip, fail, or upload")
+	uploadCmd.Flags().DurationVar(&opts.timeout, "upload_timeout", 10*time.Minute, "how long to wait for Strava to process each upload; use \"stravacli uploads status\" to check on uploads that take longer")
+	uploadCmd.Flags().BoolVar(&opts.compress, "compress", false, "gzip uncompressed files of at least --compress_threshold bytes before uploading them")
+	uploadCmd.Flags().Int64Var(&opts.compressThreshold, "compress_threshold", 1<<20, "with --compress, the minimum size in bytes of files to compress")
+	uploadCmd.Flags().StringVar(&opts.ledgerFile, "ledger", defaultLedgerFile(), "file recording previous uploads, used to skip files that were already uploaded; empty to disable")
 	rootCmd.AddCommand(uploadCmd)
 }
 
-type uploadActivity struct {
-	ExternalID   string `csv:"External ID"`
-	ActivityType string `csv:"Activity Type"`
-	Name         string `csv:"Name"`
-	Description  string `csv:"Description"`
-	WorkoutType  int    `csv:"Workout Type"`
-	GearID       string `csv:"Gear ID"`
-	Commute      bool   `csv:"Commute?"`
-	Trainer      bool   `csv:"Trainer?"`
-	FileType     string `csv:"File Type"`
-	Filename     string `csv:"Filename"`
-}
-
-func (a *uploadActivity) String() string {
-	return fmt.Sprintf("[%s from %s]", a.Name, a.Filename)
-}
+// uploadOptions holds options for doUpload.
+type uploadOptions struct {
+	startRow    int
+	dryRun      bool
+	onDuplicate string // a bulk.OnDuplicate
+	ledgerFile  string // "" to disable the ledger
+	timeout     time.Duration
 
-var validActivityType = map[string]bool{
-	"AlpineSki":       true,
-	"BackcountrySki":  true,
-	"Canoeing":        true,
-	"Crossfit":        true,
-	"EBikeRide":       true,
-	"Elliptical":      true,
-	"Golf":            true,
-	"Handcycle":       true,
-	"Hike":            true,
-	"IceSkate":        true,
-	"InlineSkate":     true,
-	"Kayaking":        true,
-	"Kitesurf":        true,
-	"NordicSki":       true,
-	"Ride":            true,
-	"RockClimbing":    true,
-	"RollerSki":       true,
-	"Rowing":          true,
-	"Run":             true,
-	"Sail":            true,
-	"Skateboard":      true,
-	"Snowboard":       true,
-	"Snowshoe":        true,
-	"Soccer":          true,
-	"StairStepper":    true,
-	"StandUpPaddling": true,
-	"Surfing":         true,
-	"Swim":            true,
-	"Velomobile":      true,
-	"VirtualRide":     true,
-	"VirtualRun":      true,
-	"Walk":            true,
-	"WeightTraining":  true,
-	"Wheelchair":      true,
-	"Windsurf":        true,
-	"Workout":         true,
-	"Yoga":            true,
+	// compress gzips uncompressed files of at least compressThreshold bytes
+	// before uploading them.
+	compress          bool
+	compressThreshold int64
 }
 
-var validFileType = map[string]bool{
-	"fit":    true,
-	"fit.gz": true,
-	"tcx":    true,
-	"tcx.gz": true,
-	"gpx":    true,
-	"gpx.gz": true,
-}
+func doUpload(accessToken, source string, activities []*bulk.UploadActivity, opts *uploadOptions) (int, error) {
+	if accessToken == "" {
+		return 0, invalidInput(errors.New("required flag \"access_token\" not set"))
+	}
+	ctx, client := newClient(accessToken)
 
-// Verify checks to see that a looks like it can be uploaded.
-func (a *uploadActivity) Verify() error {
-	if a.ActivityType == "" {
-		return errors.New("missing Activity Type")
+	printf("Found %d activities in %q to upload%s....\n", len(activities), source, startRowMessage(len(activities), opts.startRow))
+	led, err := bulk.LoadLedger(opts.ledgerFile)
+	if err != nil {
+		return 0, err
 	}
-	if !validActivityType[a.ActivityType] {
-		return fmt.Errorf("invalid Activity Type %q", a.ActivityType)
+	rep := newUploadReporter(bulk.OnDuplicate(opts.onDuplicate))
+	results, err := client.Upload(ctx, activities, &bulk.UploadOptions{
+		StartRow:          opts.startRow,
+		DryRun:            opts.dryRun,
+		Compress:          opts.compress,
+		CompressThreshold: opts.compressThreshold,
+		Ledger:            led,
+		OnDuplicate:       bulk.OnDuplicate(opts.onDuplicate),
+		Timeout:           opts.timeout,
+		Progress:          rep.report,
+	})
+	if len(rep.dupRows) > 0 {
+		printf("Found %d possible duplicates of existing activities:\n%s\n", len(rep.dupRows), strings.Join(rep.dupRows, "\n"))
 	}
-	if a.Name == "" {
-		return errors.New("missing Name")
+	var uploaded int
+	var failed, stillProcessing []string
+	for _, r := range results {
+		switch r.Status {
+		case bulk.StatusDone:
+			uploaded++
+		case bulk.StatusFailed:
+			failed = append(failed, strconv.Itoa(r.Row))
+		case bulk.StatusSubmitted:
+			stillProcessing = append(stillProcessing, strconv.Itoa(r.Row))
+		}
 	}
-	if a.FileType == "" {
-		return errors.New("missing File Type")
+	stillProcessingMsg := fmt.Sprintf("%d uploads were still being processed (on rows %s); use \"stravacli uploads status\" to check on them", len(stillProcessing), strings.Join(stillProcessing, ", "))
+	if rerr, ok := err.(*bulk.RowError); ok {
+		if len(stillProcessing) > 0 {
+			printf("%d uploads were submitted but not checked on (on rows %s); use \"stravacli uploads status\" to check on them.\n", len(stillProcessing), strings.Join(stillProcessing, ", "))
+		}
+		// If ctx was canceled before the first row, results is empty.
+		if n := len(results); n > 0 {
+			if r := results[n-1]; r.Row == rerr.Row && r.Status == bulk.StatusFailed && len(r.Duplicates) > 0 && bulk.OnDuplicate(opts.onDuplicate) == bulk.OnDuplicateFail {
+				return rerr.Row, fmt.Errorf("%v; use --on_duplicate to skip or upload anyway", err)
+			}
+		}
+		return rerr.Row, err
 	}
-	if !validFileType[a.FileType] {
-		return fmt.Errorf("invalid File Type %q", a.FileType)
+	if err != nil && (ctx.Err() == nil || len(stillProcessing) == 0) {
+		return 0, err
 	}
-	if a.Filename == "" {
-		return errors.New("missing Filename")
+	if opts.dryRun {
+		return 0, nil
 	}
-	if _, err := os.Stat(a.Filename); os.IsNotExist(err) {
-		return fmt.Errorf("Filename %q not found", a.Filename)
+	printf("Uploaded %d activities.\n", uploaded)
+	recordResult("uploaded", uploaded)
+	if err != nil {
+		// Interrupted while waiting for Strava.
+		return 0, errors.New(stillProcessingMsg)
 	}
-	return nil
+	var msgs []string
+	if len(failed) > 0 {
+		msgs = append(msgs, fmt.Sprintf("%d uploads failed (on rows %s); fix them and rerun to retry, files that were uploaded successfully will be skipped", len(failed), strings.Join(failed, ", ")))
+	}
+	if len(stillProcessing) > 0 {
+		msgs = append(msgs, stillProcessingMsg)
+	}
+	if len(msgs) > 0 {
+		return 0, errors.New(strings.Join(msgs, "\n"))
+	}
+	return 0, nil
 }
 
-func doUpload(accessToken, inFile string, startRow int, dryRun bool) (int, error) {
-	activities, err := loadActivitiesFromCSV(inFile)
-	if err != nil {
-		return 0, err
-	}
+// uploadReporter reports the progress of bulk.Client.Upload.
+type uploadReporter struct {
+	onDuplicate bulk.OnDuplicate
+	last        map[int]bulk.Status // the last status reported for each row
+	dupRows     []string            // descriptions of the duplicates found
+}
 
-	ctx := context.WithValue(context.Background(), strava.ContextAccessToken, accessToken)
-	uploadSvc := strava.NewAPIClient(strava.NewConfiguration()).UploadsApi
+func newUploadReporter(onDuplicate bulk.OnDuplicate) *uploadReporter {
+	return &uploadReporter{onDuplicate: onDuplicate, last: map[int]bulk.Status{}}
+}
 
-	fmt.Printf("Found %d activities in %q to upload%s....\n", len(activities), inFile, startRowMessage(len(activities), startRow))
-	n := 0
-	for i, a := range activities {
-		row := i + 1 // row 0 is the header row
-		if row < startRow {
-			continue
-		}
-		if err := uploadOne(ctx, uploadSvc, a, dryRun); err != nil {
-			return row, fmt.Errorf("failed to upload activity %v: %v", a, err)
+// report reports r; it's a bulk.UploadOptions.Progress.
+func (u *uploadReporter) report(r *bulk.RowResult) {
+	last := u.last[r.Row]
+	u.last[r.Row] = r.Status
+	if last == "" && len(r.Duplicates) > 0 {
+		var urls []string
+		for _, id := range r.Duplicates {
+			urls = append(urls, activityURL(id))
 		}
-		n++
+		u.dupRows = append(u.dupRows, fmt.Sprintf("  row %d: %v matches %s", r.Row, r.Activity, strings.Join(urls, ", ")))
 	}
-	if !dryRun {
-		fmt.Printf("Uploaded %d activities.\n", n)
+	switch {
+	case r.Status == bulk.StatusSkipped && len(r.Duplicates) > 0:
+		reportRow(r, "  Skipping duplicate %v...\n", r.Activity)
+	case r.Status == bulk.StatusSkipped:
+		reportRow(r, "  Skipping %v, already uploaded as %s...\n", r.Activity, r.URL)
+	case r.Status == bulk.StatusDryRun && len(r.Duplicates) > 0 && u.onDuplicate == bulk.OnDuplicateFail:
+		reportRow(r, "  Would fail on duplicate %v...\n", r.Activity)
+	case r.Status == bulk.StatusStarted && len(r.Duplicates) > 0:
+		printf("  %v is a duplicate; uploading it anyway...\n", r.Activity)
+		printRowResult(r)
+	case r.Status == bulk.StatusSubmitted && last == bulk.StatusStarted:
+		log.Printf("submitted as upload %d", r.UploadID)
+		reportRow(r, "")
+	case r.Status == bulk.StatusSubmitted && last == bulk.StatusSubmitted:
+		reportRow(r, "  Gave up waiting for %v (upload %d)\n", r.Activity, r.UploadID)
+	case r.Status == bulk.StatusSubmitted:
+		reportRow(r, "  %v was already submitted as upload %d; checking on it...\n", r.Activity, r.UploadID)
+	case r.Status == bulk.StatusDone:
+		reportRow(r, "  %v --> %s\n", r.Activity, r.URL)
+		if r.Err != nil {
+			printf("  Uploaded %v, but %v\n", r.Activity, r.Err)
+		}
+	case r.Status == bulk.StatusFailed && last == bulk.StatusSubmitted:
+		reportRow(r, "  Failed to upload %v: %v\n", r.Activity, r.Err)
+	case r.Status == bulk.StatusFailed:
+		// The error is returned by bulk.Client.Upload.
+		reportRow(r, "")
+	default:
+		printRowResult(r)
 	}
-	return 0, nil
 }
 
-func loadActivitiesFromCSV(filename string) ([]*uploadActivity, error) {
+func loadActivitiesFromCSV(filename string) ([]*bulk.UploadActivity, error) {
 	f, err := os.Open(filename)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open %q: %v", filename, err)
+		return nil, invalidInput(fmt.Errorf("failed to open %q: %v", filename, err))
 	}
 	defer f.Close()
-	var activities []*uploadActivity
-	if err := gocsv.UnmarshalFile(f, &activities); err != nil {
-		return nil, fmt.Errorf("failed to parse %q: %v", filename, err)
+	activities, err := bulk.ReadUploadActivities(f, dialect)
+	if err != nil {
+		return nil, invalidInput(fmt.Errorf("failed to parse %q: %v", filename, err))
 	}
 	return activities, nil
 }
 
-func uploadOne(ctx context.Context, uploadSvc *strava.UploadsApiService, a *uploadActivity, dryRun bool) error {
-	if err := a.Verify(); err != nil {
-		return err
+// writeActivitiesCSV writes activities, a slice of activities, to filename as
+// a .csv; "-" means stdout.
+func writeActivitiesCSV(filename string, activities interface{}) error {
+	var buf bytes.Buffer
+	if err := bulk.WriteCSV(&buf, activities, dialect); err != nil {
+		return fmt.Errorf("failed to generate .csv: %v", err)
 	}
-	f, err := os.Open(a.Filename)
-	if err != nil {
-		return fmt.Errorf("failed to open %q: %v", a.Filename, err)
-	}
-	if dryRun {
-		fmt.Printf("  Would upload %v...\n", a)
+	if filename == "-" {
+		fmt.Print(buf.String())
 		return nil
 	}
-	fmt.Printf("  Uploading %v...\n", a)
-
-	opts := strava.CreateUploadOpts{
-		Name:     optional.NewString(a.Name),
-		Type:     optional.NewString(a.ActivityType),
-		DataType: optional.NewString(a.FileType),
-		File:     optional.NewInterface(f),
-	}
-	defer f.Close()
-	if a.ExternalID != "" {
-		opts.ExternalId = optional.NewString(a.ExternalID)
-	}
-	if a.Description != "" {
-		opts.Description = optional.NewString(a.Description)
-	}
-	if a.WorkoutType != 0 {
-		opts.WorkoutType = optional.NewInt32(int32(a.WorkoutType))
-	}
-	if a.GearID != "" {
-		opts.GearId = optional.NewString(a.GearID)
-	}
-	if a.Trainer {
-		opts.Trainer = optional.NewInt32(1)
-	}
-	if a.Commute {
-		opts.Commute = optional.NewInt32(1)
-	}
-	upload, resp, err := uploadSvc.CreateUpload(ctx, &opts)
-	for {
-		if err != nil {
-			var msg string
-			if resp != nil {
-				body, _ := ioutil.ReadAll(resp.Body)
-				msg = string(body)
-			}
-			return fmt.Errorf("%v %s", err, msg)
-		}
-		if upload.Error_ != "" {
-			return fmt.Errorf("upload failed: %s", upload.Error_)
-		}
-		if upload.ActivityId != 0 {
-			break
-		}
-		time.Sleep(1 * time.Second)
-		log.Printf("    checking on status...")
-		upload, resp, err = uploadSvc.GetUploadById(ctx, upload.Id)
+	if err := ioutil.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write %q: %v", filename, err)
 	}
-	fmt.Printf("  --> https://www.strava.com/activities/%d\n", upload.ActivityId)
+	printf("Wrote %d activities to %q.\n", reflect.ValueOf(activities).Len(), filename)
 	return nil
 }
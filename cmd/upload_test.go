@@ -0,0 +1,74 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vangent/stravacli/bulk"
+	"github.com/vangent/stravacli/fakestrava"
+)
+
+// cancelingTransport cancels a context after a request whose path has the
+// suffix suffix.
+type cancelingTransport struct {
+	suffix string
+	cancel func()
+}
+
+func (t cancelingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if strings.HasSuffix(req.URL.Path, t.suffix) {
+		t.cancel()
+	}
+	return resp, err
+}
+
+func TestUploadCanceledBeforeFirstRow(t *testing.T) {
+	_, flags, done := newFakeStrava(0)
+	defer done()
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	// Cancel once the duplicate check has listed the existing activities.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func(prevBase string, prevTransport http.RoundTripper, prevCtx context.Context) {
+		apiBase, httpTransport, cmdCtx = prevBase, prevTransport, prevCtx
+	}(apiBase, httpTransport, cmdCtx)
+	apiBase, httpTransport, cmdCtx = flags[1], cancelingTransport{"/athlete/activities", cancel}, ctx
+
+	path := writeFile(t, dir, "ride.gpx", testGPX)
+	activities := []*bulk.UploadActivity{bulk.NewUploadActivityForFile(path, "gpx", "Ride")}
+	row, err := doUpload(fakestrava.AccessToken, dir, activities, &uploadOptions{
+		startRow:    1,
+		onDuplicate: string(bulk.OnDuplicateFail),
+		ledgerFile:  filepath.Join(dir, "ledger.json"),
+	})
+	if rerr, ok := err.(*bulk.RowError); !ok || rerr.Err != context.Canceled || row != 1 {
+		t.Errorf("got row %d and error %v, want row 1 canceled", row, err)
+	}
+}
@@ -24,9 +24,10 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 
-	"github.com/gocarina/gocsv"
 	"github.com/spf13/cobra"
+	"github.com/vangent/stravacli/bulk"
 )
 
 func init() {
@@ -36,15 +37,16 @@ func init() {
 		Long: `Print out the required header for the .csv file for upload.
 
 Data Columns:
-External ID: An external ID for the activity; OK to leave blank.
-Activity Type: The activity type. Required. See the available list here: https://developers.strava.com/docs/reference/#api-models-ActivityType.
+External ID: An external ID for the activity; OK to leave blank, in which case an ID derived from a hash of the file contents is used.
+Activity Type: The activity type. Use "stravacli types" to see the available list. If blank, it is derived from Sport Type, or from the sport recorded in the file.
+Sport Type: The more specific sport type, like "TrailRun" or "MountainBikeRide"; OK to leave blank. Use "stravacli types" to see the available list; it must match the Activity Type.
 Name: The activity name. Required. If you leave it blank, Strava will pick one for you, like "Lunch Ride".
 Description: Description of the activity.
-Workout Type: The type of workout. 0=default/none. For Ride: 11=Race, 12=Workout; for Run: 1=Race, 2=Long Run, 3=Workout. You can figure out other values by setting the field to what you want in Strava, then using "download" to view it.
+Workout Type: The type of workout. "None" (or blank) for the default. For Run: "Race", "LongRun", or "Workout"; for Ride: "Race" or "Workout". Other Activity Types only support "None" by name. Strava numbers are also accepted, and passed through as-is (for Run: 1=Race, 2=Long Run, 3=Workout; for Ride: 10=None, 11=Race, 12=Workout), except that 1-3 are only allowed for Run and 10-12 only for Ride.
 Gear ID: The ID for the gear used. The ID is not shown on the UI; you can figure out what the ID for a specific bike or pair of shoes by using "download" to view an activity that uses them. The ID looks something like "g3880367".
 Commute?: "false" or "true", depending on whether this activity was for a commute. Defaults to "false".
 Trainer?: "false" or "true", depending on whether this activity used a trainer. Defaults to "false".
-File Type: The type of data file being uploaded; one of "fit", "tcx", or "gpx"; may be suffixed with ".gz" (e.g., "gpx.gz") if the file is gzipped.
+File Type: The type of data file being uploaded; one of "fit", "tcx", or "gpx"; may be suffixed with ".gz" (e.g., "gpx.gz") if the file is gzipped. It must match the contents of the file.
 Filename: Relative path to the data file.
 `,
 		Args: cobra.NoArgs,
@@ -56,11 +58,9 @@ Filename: Relative path to the data file.
 }
 
 func doUploadHeader() error {
-	var noActivities []*uploadActivity
-	csv, err := gocsv.MarshalString(noActivities)
-	if err != nil {
+	var noActivities []*bulk.UploadActivity
+	if err := bulk.WriteCSV(os.Stdout, noActivities, dialect); err != nil {
 		return fmt.Errorf("failed to generate .csv: %v", err)
 	}
-	fmt.Printf(csv)
 	return nil
 }
@@ -23,22 +23,17 @@ THE SOFTWARE.
 package cmd
 
 import (
-	"context"
-	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"time"
 
-	"github.com/antihax/optional"
-	"github.com/gocarina/gocsv"
 	"github.com/spf13/cobra"
-	"github.com/vangent/strava"
+	"github.com/vangent/stravacli/bulk"
 )
 
 func init() {
 	var accessToken string
 	var inFile string
+	var tz string
 	var startRow int
 	var dryRun bool
 
@@ -47,135 +42,72 @@ func init() {
 		Short: "Upload new manual Strava activities",
 		Long: `Upload new manual Strava activities.
 
+Start times without a UTC offset are interpreted in the --tz time zone.
+
 See https://github.com/vangent/stravacli#upload-manual-activities
 for detailed instructions.`,
 		Args: cobra.NoArgs,
 		RunE: func(_ *cobra.Command, _ []string) error {
-			return checkPartialSuccess(doUploadManual(accessToken, inFile, startRow, dryRun))
+			return checkPartialSuccess(doUploadManual(accessToken, inFile, tz, startRow, dryRun))
 		},
 	}
 	uploadManualCmd.Flags().StringVarP(&accessToken, "access_token", "t", "", "Strava access token; use the auth command to get one")
 	uploadManualCmd.MarkFlagRequired("access_token")
 	uploadManualCmd.Flags().StringVar(&inFile, "in", "", ".csv with activities to upload")
 	uploadManualCmd.MarkFlagRequired("in")
+	uploadManualCmd.Flags().StringVar(&tz, "tz", "", "time zone for Start times without a UTC offset (e.g., \"America/Los_Angeles\"); defaults to the local time zone")
 	uploadManualCmd.Flags().IntVar(&startRow, "start_row", 1, "skip rows in the input up to this row (row 0 is the header row)")
 	uploadManualCmd.Flags().BoolVar(&dryRun, "dryrun", false, "do a dry run: print out proposed changes")
 	rootCmd.AddCommand(uploadManualCmd)
 }
 
-type manualActivity struct {
-	Start        time.Time `csv:"Start"`
-	ActivityType string    `csv:"Activity Type"`
-	Name         string    `csv:"Name"`
-	Description  string    `csv:"Description"`
-	WorkoutType  int       `csv:"Workout Type"`
-	GearID       string    `csv:"Gear ID"`
-	Duration     int32     `csv:"Duration"`
-	Distance     float32   `csv:"Distance"`
-	Commute      bool      `csv:"Commute?"`
-	Trainer      bool      `csv:"Trainer?"`
-}
-
-func (a *manualActivity) String() string {
-	return fmt.Sprintf("[%s on %s]", a.Name, a.Start.Format(dayFormat))
-}
-
-// Verify checks to see that a looks like it can be uploaded.
-func (a *manualActivity) Verify() error {
-	if a.Start.IsZero() {
-		return errors.New("missing Start")
-	}
-	if a.ActivityType == "" {
-		return errors.New("missing Activity Type")
-	}
-	if !validActivityType[a.ActivityType] {
-		return fmt.Errorf("invalid Activity Type %q", a.ActivityType)
+func doUploadManual(accessToken, inFile, tz string, startRow int, dryRun bool) (int, error) {
+	loc, err := loadLocation(tz)
+	if err != nil {
+		return 0, err
 	}
-	if a.Name == "" {
-		return errors.New("missing Name")
+	if err := checkCSV(csvForUploadManual, inFile, nil, loc, startRow); err != nil {
+		return 0, err
 	}
-	return nil
-}
-
-func doUploadManual(accessToken, inFile string, startRow int, dryRun bool) (int, error) {
 	activities, err := loadManualActivitiesFromCSV(inFile)
 	if err != nil {
 		return 0, err
 	}
+	for _, a := range activities {
+		a.Location = loc
+	}
+	return uploadManualActivities(accessToken, inFile, activities, startRow, dryRun)
+}
 
-	ctx := context.WithValue(context.Background(), strava.ContextAccessToken, accessToken)
-	apiSvc := strava.NewAPIClient(strava.NewConfiguration()).ActivitiesApi
+// uploadManualActivities uploads activities, which came from source.
+func uploadManualActivities(accessToken, source string, activities []*bulk.ManualActivity, startRow int, dryRun bool) (int, error) {
+	ctx, client := newClient(accessToken)
 
-	fmt.Printf("Found %d manual activities in %q to upload%s....\n", len(activities), inFile, startRowMessage(len(activities), startRow))
-	n := 0
-	for i, a := range activities {
-		row := i + 1 // row 0 is the header row
-		if row < startRow {
-			continue
-		}
-		if err := uploadManualOne(ctx, apiSvc, a, dryRun); err != nil {
-			return row, fmt.Errorf("failed to upload manual activity %v: %v", a, err)
-		}
-		n++
+	printf("Found %d manual activities in %q to upload%s....\n", len(activities), source, startRowMessage(len(activities), startRow))
+	results, err := client.UploadManual(ctx, activities, &bulk.UploadManualOptions{
+		StartRow: startRow,
+		DryRun:   dryRun,
+		Progress: printRowResult,
+	})
+	if err != nil {
+		return rowForError(err), err
 	}
 	if !dryRun {
-		fmt.Printf("Uploaded %d manual activities.\n", n)
+		printf("Uploaded %d manual activities.\n", len(results))
+		recordResult("uploaded", len(results))
 	}
 	return 0, nil
 }
 
-func loadManualActivitiesFromCSV(filename string) ([]*manualActivity, error) {
+func loadManualActivitiesFromCSV(filename string) ([]*bulk.ManualActivity, error) {
 	f, err := os.Open(filename)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open %q: %v", filename, err)
+		return nil, invalidInput(fmt.Errorf("failed to open %q: %v", filename, err))
 	}
 	defer f.Close()
-	var activities []*manualActivity
-	if err := gocsv.UnmarshalFile(f, &activities); err != nil {
-		return nil, fmt.Errorf("failed to parse %q: %v", filename, err)
-	}
-	return activities, nil
-}
-
-func uploadManualOne(ctx context.Context, apiSvc *strava.ActivitiesApiService, a *manualActivity, dryRun bool) error {
-	if err := a.Verify(); err != nil {
-		return err
-	}
-	if dryRun {
-		fmt.Printf("  Would upload %v...\n", a)
-		return nil
-	}
-	fmt.Printf("  Uploading %v...\n", a)
-	opts := strava.CreateActivityOpts{}
-	if a.Description != "" {
-		opts.Description = optional.NewString(a.Description)
-	}
-	if a.Distance != 0 {
-		opts.Distance = optional.NewFloat32(a.Distance)
-	}
-	if a.Trainer {
-		opts.Trainer = optional.NewInt32(1)
-	}
-	if a.Commute {
-		opts.Commute = optional.NewInt32(1)
-	}
-	if a.WorkoutType != 0 {
-		opts.WorkoutType = optional.NewInt32(int32(a.WorkoutType))
-	}
-	if a.GearID != "" {
-		opts.GearId = optional.NewString(a.GearID)
-	}
-	detailedActivity, resp, err := apiSvc.CreateActivity(ctx, a.Name, a.ActivityType, a.Start, a.Duration, &opts)
+	activities, err := bulk.ReadManualActivities(f, dialect)
 	if err != nil {
-		var msg string
-		if resp != nil {
-			body, _ := ioutil.ReadAll(resp.Body)
-			msg = string(body)
-		}
-		return fmt.Errorf("%v %s", err, msg)
-	}
-	if detailedActivity.Id != 0 {
-		fmt.Printf("  --> https://www.strava.com/activities/%d\n", detailedActivity.Id)
+		return nil, invalidInput(fmt.Errorf("failed to parse %q: %v", filename, err))
 	}
-	return nil
+	return activities, nil
 }
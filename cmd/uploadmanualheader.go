@@ -24,9 +24,10 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 
-	"github.com/gocarina/gocsv"
 	"github.com/spf13/cobra"
+	"github.com/vangent/stravacli/bulk"
 )
 
 func init() {
@@ -36,14 +37,15 @@ func init() {
 		Long: `Print out the required header for the .csv file for uploadmanual.
 
 Data Columns:
-Start: The start time. Required. The time format looks like YYYY-MM-DDTHH:mm:ssZ; for example, 2019-02-22T18:53:46Z".
-Activity Type: The activity type. Required. See the available list here: https://developers.strava.com/docs/reference/#api-models-ActivityType.
+Start: The start time. Required. Either an RFC 3339 time like "2019-02-22T18:53:46Z", or a local date-time like "2019-02-22 18:53" or "2019-02-22 18:53:46", optionally followed by a UTC offset like "-08:00". Times without an offset are in the uploadmanual --tz time zone.
+Activity Type: The activity type. Required unless Sport Type is set. Use "stravacli types" to see the available list.
+Sport Type: The more specific sport type, like "TrailRun" or "MountainBikeRide"; OK to leave blank. Use "stravacli types" to see the available list; it must match the Activity Type.
 Name: The activity name. Required. If you leave it blank, Strava will pick one for you, like "Lunch Ride".
 Description: Description of the activity.
-Workout Type: The type of workout. 0=default/none. For Ride: 11=Race, 12=Workout; for Run: 1=Race, 2=Long Run, 3=Workout. You can figure out other values by setting the field to what you want in Strava, then using "download" to view it.
+Workout Type: The type of workout. "None" (or blank) for the default. For Run: "Race", "LongRun", or "Workout"; for Ride: "Race" or "Workout". Other Activity Types only support "None" by name. Strava numbers are also accepted, and passed through as-is (for Run: 1=Race, 2=Long Run, 3=Workout; for Ride: 10=None, 11=Race, 12=Workout), except that 1-3 are only allowed for Run and 10-12 only for Ride.
 Gear ID: The ID for the gear used. The ID is not shown on the UI; you can figure out what the ID for a specific bike or pair of shoes by using "download" to view an activity that uses them. The ID looks something like "g3880367".
-Duration: The elapsed time, in seconds.
-Distance: The distance, in meters.
+Duration: The elapsed time; either a number of seconds, or a duration like "1:23:45", "45:00", "45m", or "1h30m".
+Distance: The distance; either a number of meters, or a distance with units like "10km", "6.2mi", or "400yd".
 Commute?: "false" or "true", depending on whether this activity was for a commute. Defaults to "false".
 Trainer?: "false" or "true", depending on whether this activity used a trainer. Defaults to "false".
 `,
@@ -56,11 +58,9 @@ Trainer?: "false" or "true", depending on whether this activity used a trainer.
 }
 
 func doUploadManualHeader() error {
-	var noActivities []*manualActivity
-	csv, err := gocsv.MarshalString(noActivities)
-	if err != nil {
+	var noActivities []*bulk.ManualActivity
+	if err := bulk.WriteCSV(os.Stdout, noActivities, dialect); err != nil {
 		return fmt.Errorf("failed to generate .csv: %v", err)
 	}
-	fmt.Printf(csv)
 	return nil
 }
@@ -0,0 +1,139 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/vangent/stravacli/bulk"
+)
+
+func init() {
+	var accessToken string
+	var ledgerFile string
+
+	uploadsCmd := &cobra.Command{
+		Use:   "uploads",
+		Short: "Work with previously submitted uploads",
+		Long:  `Work with previously submitted uploads.`,
+	}
+
+	uploadsStatusCmd := &cobra.Command{
+		Use:   "status [UPLOAD_ID...]",
+		Short: "Check on previously submitted uploads",
+		Long: `Check on previously submitted uploads.
+
+Reports the activity URL or error for each upload ID. If no upload IDs are
+given, checks all of the uploads in the ledger (see --ledger) that were still
+being processed when "upload" finished. The ledger is updated with the
+results.`,
+		RunE: func(_ *cobra.Command, args []string) error {
+			var ids []int64
+			for _, arg := range args {
+				id, err := strconv.ParseInt(arg, 10, 64)
+				if err != nil {
+					return fmt.Errorf("invalid upload ID %q: %v", arg, err)
+				}
+				ids = append(ids, id)
+			}
+			return doUploadsStatus(accessToken, ledgerFile, ids)
+		},
+	}
+	uploadsStatusCmd.Flags().StringVarP(&accessToken, "access_token", "t", "", "Strava access token; use the auth command to get one")
+	uploadsStatusCmd.MarkFlagRequired("access_token")
+	uploadsStatusCmd.Flags().StringVar(&ledgerFile, "ledger", defaultLedgerFile(), "file recording previous uploads")
+	uploadsCmd.AddCommand(uploadsStatusCmd)
+	rootCmd.AddCommand(uploadsCmd)
+}
+
+func doUploadsStatus(accessToken, ledgerFile string, ids []int64) error {
+	led, err := bulk.LoadLedger(ledgerFile)
+	if err != nil {
+		return err
+	}
+	var entries []*bulk.LedgerEntry
+	if len(ids) == 0 {
+		entries = led.Pending()
+		if len(entries) == 0 {
+			printf("No pending uploads found in the ledger.\n")
+			return nil
+		}
+	} else {
+		for _, id := range ids {
+			e := led.ByUploadID(id)
+			if e == nil {
+				// Not in the ledger; check it anyway, but don't record it.
+				e = &bulk.LedgerEntry{UploadID: id}
+			}
+			entries = append(entries, e)
+		}
+	}
+
+	ctx, client := newClient(accessToken)
+	for _, e := range entries {
+		label := fmt.Sprintf("upload %d", e.UploadID)
+		if e.Filename != "" {
+			label += fmt.Sprintf(" (%s)", e.Filename)
+		}
+		done, err := client.CheckLedgerEntry(ctx, e)
+		r := &bulk.RowResult{Action: bulk.ActionUpload, Details: e.Filename, UploadID: e.UploadID}
+		switch {
+		case err != nil && !done:
+			r.Status, r.Err = bulk.StatusSubmitted, err
+			reportRow(r, "  %s: failed to check status: %v\n", label, err)
+			continue
+		case !done:
+			r.Status = bulk.StatusSubmitted
+			reportRow(r, "  %s: still processing\n", label)
+		case e.Error != "":
+			r.Status, r.Err = bulk.StatusFailed, &bulk.UploadError{Msg: e.Error}
+			reportRow(r, "  %s: failed: %s\n", label, e.Error)
+		default:
+			r.Status, r.ActivityID, r.URL, r.Err = bulk.StatusDone, e.ActivityID, activityURL(e.ActivityID), err
+			reportRow(r, "  %s --> %s\n", label, r.URL)
+			if err != nil {
+				printf("  Uploaded %s, but %v\n", label, err)
+			}
+		}
+		if e.Hash != "" {
+			if err := led.Record(e); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// defaultLedgerFile returns the default path for the ledger, next to the
+// default configuration file.
+func defaultLedgerFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "stravacli", "ledger.json")
+}
@@ -0,0 +1,207 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vangent/stravacli/bulk"
+)
+
+// Kinds of .csv files for validate's --for.
+const (
+	csvForUpdate       = "update"
+	csvForUpload       = "upload"
+	csvForUploadManual = "uploadmanual"
+)
+
+func init() {
+	var inFile, origFile, csvFor, tz string
+
+	validateCmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Check a .csv for update, upload, or uploadmanual",
+		Long: `Check a .csv for update, upload, or uploadmanual.
+
+Checks every row of the .csv without contacting Strava, and reports all of
+the problems found, with their row and column, instead of stopping at the
+first one. Errors are values that the command would reject; warnings are
+values that are suspicious, like a Start time in the future, or a Gear ID
+that isn't used in --orig or listed in the configuration file.
+
+The same checks are run automatically before update, upload --in, and
+uploadmanual change anything; they stop if there are any errors.
+
+The kind of .csv is guessed from its header row; use --for to override it.
+Checking a .csv for update requires --orig.`,
+		Args: cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			return doValidate(inFile, origFile, csvFor, tz)
+		},
+	}
+	validateCmd.Flags().StringVar(&inFile, "in", "", ".csv file to check")
+	validateCmd.MarkFlagRequired("in")
+	validateCmd.Flags().StringVar(&origFile, "orig", "", "for update, the original .csv file from download")
+	validateCmd.Flags().StringVar(&csvFor, "for", "", "the command the .csv is for: update, upload, or uploadmanual; guessed from the header row by default")
+	validateCmd.Flags().StringVar(&tz, "tz", "", "for uploadmanual, the time zone for Start times without a UTC offset; defaults to the local time zone")
+	rootCmd.AddCommand(validateCmd)
+}
+
+func doValidate(inFile, origFile, csvFor, tz string) error {
+	b, err := ioutil.ReadFile(inFile)
+	if err != nil {
+		return invalidInput(fmt.Errorf("failed to open %q: %v", inFile, err))
+	}
+	if csvFor == "" {
+		if csvFor, err = guessCSVFor(b); err != nil {
+			return invalidInput(fmt.Errorf("%q: %v; use --for", inFile, err))
+		}
+	}
+	var orig []*bulk.Activity
+	switch csvFor {
+	case csvForUpdate:
+		if origFile == "" {
+			return invalidInput(errors.New("checking a .csv for update requires --orig"))
+		}
+		if orig, err = loadUpdatableActivitiesFromCSV(origFile); err != nil {
+			return err
+		}
+	case csvForUpload, csvForUploadManual:
+	default:
+		return invalidInput(fmt.Errorf("invalid --for %q (should be update, upload, or uploadmanual)", csvFor))
+	}
+	loc, err := loadLocation(tz)
+	if err != nil {
+		return err
+	}
+	errs, warnings, err := validateCSV(csvFor, inFile, b, orig, loc, 1)
+	if err != nil {
+		return err
+	}
+	printf("Found %d errors and %d warnings in %q.\n", errs, warnings, inFile)
+	recordResult("errors", errs)
+	recordResult("warnings", warnings)
+	if errs > 0 {
+		return invalidInput(fmt.Errorf("%q has %d errors", inFile, errs))
+	}
+	return nil
+}
+
+// guessCSVFor returns the command that the .csv b is for, based on its
+// header row.
+func guessCSVFor(b []byte) (string, error) {
+	header, err := dialect.Header(bytes.NewReader(b))
+	if err != nil {
+		return "", fmt.Errorf("failed to read header row: %v", err)
+	}
+	columns := map[string]bool{}
+	for _, column := range header {
+		columns[column] = true
+	}
+	switch {
+	case columns["ID"]:
+		return csvForUpdate, nil
+	case columns["Filename"]:
+		return csvForUpload, nil
+	case columns["Duration"] || columns["Distance"]:
+		return csvForUploadManual, nil
+	}
+	return "", errors.New("can't tell what kind of .csv it is from the header row")
+}
+
+// loadLocation returns the time zone for --tz; empty means the local time
+// zone.
+func loadLocation(tz string) (*time.Location, error) {
+	if tz == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, invalidInput(fmt.Errorf("invalid --tz %q: %v", tz, err))
+	}
+	return loc, nil
+}
+
+// validateCSV checks b, the contents of the .csv filename for the command
+// csvFor, reporting each problem. It returns the number of errors and
+// warnings found.
+func validateCSV(csvFor, filename string, b []byte, orig []*bulk.Activity, loc *time.Location, startRow int) (errs, warnings int, err error) {
+	opts := &bulk.ValidateOptions{Location: loc, KnownGear: knownGear(), StartRow: startRow, Dialect: dialect}
+	var problems []*bulk.Problem
+	switch csvFor {
+	case csvForUpdate:
+		problems, err = bulk.ValidateActivities(bytes.NewReader(b), orig, opts)
+	case csvForUpload:
+		problems, err = bulk.ValidateUploadActivities(bytes.NewReader(b), opts)
+	case csvForUploadManual:
+		problems, err = bulk.ValidateManualActivities(bytes.NewReader(b), opts)
+	}
+	if err != nil {
+		return 0, 0, invalidInput(fmt.Errorf("failed to parse %q: %v", filename, err))
+	}
+	for _, p := range problems {
+		if p.Warning {
+			warnings++
+		} else {
+			errs++
+		}
+		reportProblem(p)
+	}
+	return errs, warnings, nil
+}
+
+// knownGear returns the Gear IDs listed in the configuration file, or nil
+// if there aren't any.
+func knownGear() map[string]bool {
+	conf, err := loadConfig()
+	if err != nil || len(conf.Gear) == 0 {
+		return nil
+	}
+	known := map[string]bool{}
+	for id := range conf.Gear {
+		known[id] = true
+	}
+	return known
+}
+
+// checkCSV runs validateCSV on filename before a bulk command uses it,
+// returning an error if there are any errors.
+func checkCSV(csvFor, filename string, orig []*bulk.Activity, loc *time.Location, startRow int) error {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return invalidInput(fmt.Errorf("failed to open %q: %v", filename, err))
+	}
+	errs, _, err := validateCSV(csvFor, filename, b, orig, loc, startRow)
+	if err != nil {
+		return err
+	}
+	if errs > 0 {
+		return invalidInput(fmt.Errorf("found %d errors in %q; nothing was changed (see \"stravacli validate\")", errs, filename))
+	}
+	return nil
+}
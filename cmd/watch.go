@@ -0,0 +1,290 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vangent/stravacli/bulk"
+)
+
+const (
+	watchDoneDir   = "done"
+	watchFailedDir = "failed"
+)
+
+func init() {
+	var accessToken string
+	var dir string
+	var interval time.Duration
+	var settle time.Duration
+	var once bool
+	var opts uploadOptions
+
+	watchCmd := &cobra.Command{
+		Use:   "watch",
+		Short: "Watch a directory and upload new activity files",
+		Long: `Watch a directory and upload new activity files.
+
+Every --interval, the directory is scanned for .fit, .tcx, and .gpx files
+(optionally gzipped). Once a file has stopped changing for at least --settle,
+it is uploaded, and then moved into the "done" subdirectory if Strava accepts
+it, or the "failed" subdirectory (along with a .error.txt file explaining why)
+if the file is invalid or Strava rejects it. Files that couldn't be uploaded
+for other reasons, like network problems or Strava's rate limit, are left
+where they are and retried in the next scan; if Strava rejects the access
+token, watch stops.
+
+The Name and Activity Type are inferred from the file contents where
+possible. Defaults for the Activity Type, Gear ID, and Commute? come from the
+"watch" section of the configuration file; see
+https://github.com/vangent/stravacli#watch-a-directory for details.
+
+Uploads are recorded in the same ledger as the upload command, so it's safe
+to restart watch at any time; files that were already uploaded aren't
+uploaded again.`,
+		Args: cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			return doWatch(accessToken, dir, interval, settle, once, &opts)
+		},
+	}
+	watchCmd.Flags().StringVarP(&accessToken, "access_token", "t", "", "Strava access token; use the auth command to get one")
+	watchCmd.MarkFlagRequired("access_token")
+	watchCmd.Flags().StringVar(&dir, "dir", "", "directory to watch")
+	watchCmd.MarkFlagRequired("dir")
+	watchCmd.Flags().DurationVar(&interval, "interval", 30*time.Second, "how often to scan the directory")
+	watchCmd.Flags().DurationVar(&settle, "settle", 10*time.Second, "how long a file must be unchanged before it is uploaded")
+	watchCmd.Flags().BoolVar(&once, "once", false, "scan the directory once, upload what's there, and exit")
+	watchCmd.Flags().DurationVar(&opts.timeout, "upload_timeout", 10*time.Minute, "how long to wait for Strava to process each upload; files still being processed are checked on again in the next scan")
+	watchCmd.Flags().BoolVar(&opts.compress, "compress", false, "gzip uncompressed files of at least --compress_threshold bytes before uploading them")
+	watchCmd.Flags().Int64Var(&opts.compressThreshold, "compress_threshold", 1<<20, "with --compress, the minimum size in bytes of files to compress")
+	watchCmd.Flags().StringVar(&opts.ledgerFile, "ledger", defaultLedgerFile(), "file recording previous uploads, used to skip files that were already uploaded")
+	rootCmd.AddCommand(watchCmd)
+}
+
+// watchedFile is the state of a file as of the last scan.
+type watchedFile struct {
+	size    int64
+	modTime time.Time
+}
+
+func doWatch(accessToken, dir string, interval, settle time.Duration, once bool, opts *uploadOptions) error {
+	if opts.ledgerFile == "" {
+		return invalidInput(errors.New("watch requires a --ledger"))
+	}
+	conf, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	wc := conf.Watch
+	if wc == nil {
+		wc = &watchConfig{}
+	}
+	for _, r := range wc.Commute {
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("invalid watch configuration in %q: %v", configFile, err)
+		}
+	}
+	led, err := bulk.LoadLedger(opts.ledgerFile)
+	if err != nil {
+		return err
+	}
+
+	ctx, client := newClient(accessToken)
+
+	printf("Watching %q for new activity files...\n", dir)
+	seen := map[string]*watchedFile{}
+	for {
+		ready, err := scanWatchDir(dir, seen, settle, once)
+		if err != nil {
+			return err
+		}
+		for _, path := range ready {
+			if err := watchUploadOne(ctx, client, dir, path, wc, led, opts); err != nil {
+				return err
+			}
+		}
+		if once {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			// Interrupted between scans; that's how watch is meant to stop.
+			printf("Stopped watching %q.\n", dir)
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// scanWatchDir returns the activity files in dir that are ready to upload;
+// that is, files that haven't changed since the previous scan (recorded in
+// seen) and weren't modified in the last settle. If once is true, there is
+// no previous scan, so only settle is checked.
+func scanWatchDir(dir string, seen map[string]*watchedFile, settle time.Duration, once bool) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %q: %v", dir, err)
+	}
+	var ready []string
+	present := map[string]bool{}
+	for _, info := range infos {
+		path := filepath.Join(dir, info.Name())
+		if info.IsDir() || bulk.FileTypeForPath(path) == "" {
+			continue
+		}
+		present[path] = true
+		prev := seen[path]
+		seen[path] = &watchedFile{size: info.Size(), modTime: info.ModTime()}
+		if time.Since(info.ModTime()) < settle {
+			continue
+		}
+		if !once && (prev == nil || prev.size != info.Size() || !prev.modTime.Equal(info.ModTime())) {
+			continue
+		}
+		ready = append(ready, path)
+	}
+	for path := range seen {
+		if !present[path] {
+			delete(seen, path)
+		}
+	}
+	return ready, nil
+}
+
+// watchUploadOne uploads the activity file at path, and moves it into the
+// done or failed subdirectory of dir depending on the outcome. Problems with
+// the file itself are reported and the file is moved to failed; other
+// failures, like hitting the rate limit, leave the file to be retried. The
+// returned error is only for problems that should stop watching, like an
+// expired access token.
+func watchUploadOne(ctx context.Context, client *bulk.Client, dir, path string, wc *watchConfig, led *bulk.Ledger, opts *uploadOptions) error {
+	a := bulk.NewUploadActivityForFile(path, bulk.FileTypeForPath(path), wc.ActivityType)
+	if err := wc.apply(a); err != nil {
+		return err
+	}
+	rep := newUploadReporter("")
+	results, err := client.Upload(ctx, []*bulk.UploadActivity{a}, &bulk.UploadOptions{
+		Compress:          opts.compress,
+		CompressThreshold: opts.compressThreshold,
+		Ledger:            led,
+		Timeout:           opts.timeout,
+		Progress: func(r *bulk.RowResult) {
+			// There are no input rows to report.
+			noRow := *r
+			noRow.Row = 0
+			rep.report(&noRow)
+		},
+	})
+	if err != nil && ctx.Err() != nil {
+		// Leave the file where it is, so it's retried next time.
+		if len(results) > 0 && results[0].Status == bulk.StatusSubmitted {
+			return fmt.Errorf("interrupted while waiting for Strava to process %v (upload %d); it will be checked on the next time watch runs", a, results[0].UploadID)
+		}
+		return fmt.Errorf("interrupted while uploading %v: %v", a, err)
+	}
+	if rerr, ok := err.(*bulk.RowError); ok {
+		switch rerr.Err.(type) {
+		case *bulk.ValidationError, *bulk.UploadError:
+			// A problem with the file itself.
+			printf("  Failed to upload %v: %v\n", a, rerr.Err)
+			return watchMove(dir, watchFailedDir, path, rerr.Err)
+		}
+		if sawHTTPStatus(http.StatusUnauthorized, http.StatusForbidden) {
+			// Every upload will fail the same way.
+			return err
+		}
+		// Probably a rate limit or network problem; leave the file where it
+		// is, so it's retried in the next scan.
+		printf("  Failed to upload %v, will retry: %v\n", a, rerr.Err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	switch r := results[0]; r.Status {
+	case bulk.StatusSubmitted:
+		// Leave the file where it is; the upload is checked on again in the
+		// next scan.
+		return nil
+	case bulk.StatusFailed:
+		return watchMove(dir, watchFailedDir, path, r.Err)
+	}
+	return watchMove(dir, watchDoneDir, path, nil)
+}
+
+// watchMove moves the file at path into the subdir subdirectory of dir. If
+// uploadErr is not nil, it is written next to the moved file in a .error.txt
+// file.
+func watchMove(dir, subdir, path string, uploadErr error) error {
+	destDir := filepath.Join(dir, subdir)
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return fmt.Errorf("failed to create %q: %v", destDir, err)
+	}
+	dest := filepath.Join(destDir, filepath.Base(path))
+	if _, err := os.Stat(dest); err == nil {
+		// Don't overwrite an earlier file with the same name.
+		dest = filepath.Join(destDir, time.Now().Format("20060102-150405-")+filepath.Base(path))
+	}
+	if err := os.Rename(path, dest); err != nil {
+		return fmt.Errorf("failed to move %q to %q: %v", path, destDir, err)
+	}
+	if uploadErr != nil {
+		if err := ioutil.WriteFile(dest+".error.txt", []byte(uploadErr.Error()+"\n"), 0644); err != nil {
+			return fmt.Errorf("failed to write %q: %v", dest+".error.txt", err)
+		}
+	}
+	return nil
+}
+
+// apply fills in a's Gear ID and Commute? using c.
+func (c *watchConfig) apply(a *bulk.UploadActivity) error {
+	activityType := bulk.EffectiveActivityType(a.ActivityType, a.SportType)
+	if gearID := c.Gear[activityType]; gearID != "" {
+		a.GearID = gearID
+	}
+	summary, err := a.Summarize()
+	if err != nil {
+		// Reported when the activity is verified.
+		return nil
+	}
+	for _, r := range c.Commute {
+		match, err := r.matches(activityType, summary.Start)
+		if err != nil {
+			return err
+		}
+		if match {
+			a.Commute = true
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,326 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vangent/stravacli/bulk"
+)
+
+// The edit command shows activities as a YAML document, using the small
+// subset of YAML that's needed for a list of flat mappings:
+//
+//   - id: 1234567
+//     start: 2019-02-22T18:53:46Z
+//     name: "Evening Run"
+//
+// Values may be plain, "double-quoted" (with backslash escapes), or
+// 'single-quoted'. Comments start with "#".
+
+// yamlField is a key in the YAML document for an activity.
+type yamlField struct {
+	key    string
+	column string // the .csv column for the field, as used in bulk.Change
+	get    func(a *bulk.Activity) string
+	// set sets the field from s; it's nil for read-only fields.
+	set func(a *bulk.Activity, s string) error
+}
+
+var yamlFields = []*yamlField{
+	{
+		key:    "id",
+		column: "ID",
+		get:    func(a *bulk.Activity) string { return strconv.FormatInt(a.ID, 10) },
+	},
+	{
+		key:    "start",
+		column: "Start",
+		get:    func(a *bulk.Activity) string { return a.Start.Format(time.RFC3339) },
+		set: func(a *bulk.Activity, s string) error {
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return fmt.Errorf("%q is not an RFC 3339 time like \"2019-02-22T18:53:00Z\"", s)
+			}
+			a.Start = t
+			return nil
+		},
+	},
+	{
+		key:    "activity_type",
+		column: "Activity Type",
+		get:    func(a *bulk.Activity) string { return a.ActivityType },
+		set: func(a *bulk.Activity, s string) error {
+			a.ActivityType = s
+			return nil
+		},
+	},
+	{
+		key:    "sport_type",
+		column: "Sport Type",
+		get:    func(a *bulk.Activity) string { return a.SportType },
+		set: func(a *bulk.Activity, s string) error {
+			a.SportType = s
+			return nil
+		},
+	},
+	{
+		key:    "name",
+		column: "Name",
+		get:    func(a *bulk.Activity) string { return a.Name },
+		set: func(a *bulk.Activity, s string) error {
+			a.Name = s
+			return nil
+		},
+	},
+	{
+		key:    "workout_type",
+		column: "Workout Type",
+		get:    func(a *bulk.Activity) string { return string(a.WorkoutType) },
+		set: func(a *bulk.Activity, s string) error {
+			a.WorkoutType = bulk.WorkoutType(s)
+			return nil
+		},
+	},
+	{
+		key:    "gear_id",
+		column: "Gear ID",
+		get:    func(a *bulk.Activity) string { return a.GearID },
+		set: func(a *bulk.Activity, s string) error {
+			a.GearID = s
+			return nil
+		},
+	},
+	{
+		key:    "commute",
+		column: "Commute?",
+		get:    func(a *bulk.Activity) string { return strconv.FormatBool(a.Commute) },
+		set:    func(a *bulk.Activity, s string) error { return parseYAMLBool(s, &a.Commute) },
+	},
+	{
+		key:    "trainer",
+		column: "Trainer?",
+		get:    func(a *bulk.Activity) string { return strconv.FormatBool(a.Trainer) },
+		set:    func(a *bulk.Activity, s string) error { return parseYAMLBool(s, &a.Trainer) },
+	},
+}
+
+// yamlFieldFor returns the field for key, or nil if there isn't one.
+func yamlFieldFor(key string) *yamlField {
+	for _, f := range yamlFields {
+		if f.key == key {
+			return f
+		}
+	}
+	return nil
+}
+
+// parseYAMLBool sets *b from s, which may be true/false or yes/no.
+func parseYAMLBool(s string, b *bool) error {
+	switch strings.ToLower(s) {
+	case "true", "yes", "on":
+		*b = true
+	case "false", "no", "off":
+		*b = false
+	default:
+		return fmt.Errorf("%q is not true or false", s)
+	}
+	return nil
+}
+
+// plainYAML matches values that can be written without quotes.
+var plainYAML = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.:+-]*$`)
+
+// yamlValue returns s as a YAML value, quoting it if needed. Names are
+// always quoted, so that they read the same way.
+func yamlValue(f *yamlField, s string) string {
+	if f.key != "name" && plainYAML.MatchString(s) {
+		return s
+	}
+	// Go's escapes are a subset of YAML's for double-quoted strings.
+	return strconv.Quote(s)
+}
+
+// marshalActivitiesYAML writes activities as a YAML document, after header,
+// which is written as comments.
+func marshalActivitiesYAML(activities []*bulk.Activity, header string) []byte {
+	var buf bytes.Buffer
+	for _, line := range strings.Split(strings.TrimSpace(header), "\n") {
+		fmt.Fprintln(&buf, strings.TrimSpace("# "+line))
+	}
+	for _, a := range activities {
+		buf.WriteString("\n")
+		for i, f := range yamlFields {
+			indent := "  "
+			if i == 0 {
+				indent = "- "
+			}
+			fmt.Fprintf(&buf, "%s%s: %s\n", indent, f.key, yamlValue(f, f.get(a)))
+		}
+	}
+	return buf.Bytes()
+}
+
+// yamlItem is an item in the list in a YAML document for edit.
+type yamlItem struct {
+	line   int // the line that the item starts on
+	values map[string]*yamlScalar
+}
+
+// yamlScalar is a value in a yamlItem.
+type yamlScalar struct {
+	line  int
+	value string
+}
+
+// yamlError is an error on a line of a YAML document.
+type yamlError struct {
+	line int
+	err  error
+}
+
+func (e *yamlError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.line, e.err)
+}
+
+func yamlErrorf(line int, format string, args ...interface{}) error {
+	return &yamlError{line, fmt.Errorf(format, args...)}
+}
+
+// parseYAMLItems parses b, a YAML document written by marshalActivitiesYAML
+// and then edited, into its items. It returns an error with the line number
+// for anything outside of the supported subset of YAML, as a *yamlError.
+func parseYAMLItems(b []byte) ([]*yamlItem, error) {
+	var items []*yamlItem
+	for i, line := range strings.Split(string(b), "\n") {
+		lineNum := i + 1
+		line = strings.TrimRight(line, " \t\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		var rest string
+		switch {
+		case strings.HasPrefix(line, "- "):
+			items = append(items, &yamlItem{line: lineNum, values: map[string]*yamlScalar{}})
+			rest = strings.TrimSpace(line[2:])
+		case len(items) > 0 && (line[0] == ' ' || line[0] == '\t'):
+			rest = trimmed
+		default:
+			return nil, yamlErrorf(lineNum, "expected \"- key: value\" to start an activity, or an indented \"key: value\"")
+		}
+		colon := strings.Index(rest, ":")
+		if colon < 0 || (colon+1 < len(rest) && rest[colon+1] != ' ') {
+			return nil, yamlErrorf(lineNum, "expected \"key: value\"")
+		}
+		key := strings.TrimSpace(rest[:colon])
+		if yamlFieldFor(key) == nil {
+			return nil, yamlErrorf(lineNum, "unknown key %q", key)
+		}
+		item := items[len(items)-1]
+		if _, ok := item.values[key]; ok {
+			return nil, yamlErrorf(lineNum, "duplicate key %q", key)
+		}
+		value, err := parseYAMLScalar(strings.TrimSpace(rest[colon+1:]))
+		if err != nil {
+			return nil, &yamlError{lineNum, err}
+		}
+		item.values[key] = &yamlScalar{line: lineNum, value: value}
+	}
+	return items, nil
+}
+
+// parseYAMLScalar parses s, a plain, double-quoted, or single-quoted value,
+// possibly followed by a comment.
+func parseYAMLScalar(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		end := closingQuote(s)
+		if end < 0 {
+			return "", errors.New("missing closing \" for a double-quoted value")
+		}
+		if err := checkTrailing(s[end+1:]); err != nil {
+			return "", err
+		}
+		v, err := strconv.Unquote(s[:end+1])
+		if err != nil {
+			return "", fmt.Errorf("invalid double-quoted value %s", s[:end+1])
+		}
+		return v, nil
+	case strings.HasPrefix(s, "'"):
+		var v strings.Builder
+		for i := 1; i < len(s); i++ {
+			if s[i] != '\'' {
+				v.WriteByte(s[i])
+				continue
+			}
+			if i+1 < len(s) && s[i+1] == '\'' {
+				v.WriteByte('\'')
+				i++
+				continue
+			}
+			if err := checkTrailing(s[i+1:]); err != nil {
+				return "", err
+			}
+			return v.String(), nil
+		}
+		return "", errors.New("missing closing ' for a single-quoted value")
+	}
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = strings.TrimSpace(s[:i])
+	}
+	if s == "~" || s == "null" {
+		return "", nil
+	}
+	return s, nil
+}
+
+// closingQuote returns the index of the " that ends the double-quoted
+// value at the start of s, or -1 if there isn't one.
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// checkTrailing returns an error if s, what follows a quoted value, isn't
+// empty or a comment.
+func checkTrailing(s string) error {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.HasPrefix(s, "#") {
+		return nil
+	}
+	return fmt.Errorf("unexpected %q after a quoted value", s)
+}
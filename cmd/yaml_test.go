@@ -0,0 +1,144 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vangent/stravacli/bulk"
+)
+
+func TestYAMLRoundTrip(t *testing.T) {
+	activities := []*bulk.Activity{
+		{ID: 1, Start: time.Date(2019, 2, 22, 18, 53, 46, 0, time.UTC), ActivityType: "Run", Name: `Say "hi" # not a comment`, WorkoutType: "LongRun", GearID: "g1", Commute: true},
+		{ID: 2, Start: time.Date(2019, 2, 23, 8, 0, 0, 0, time.UTC), ActivityType: "Ride", SportType: "GravelRide", Name: "Café\tride", WorkoutType: "0"},
+	}
+	b := marshalActivitiesYAML(activities, "Edit these.\n\nThen save.")
+	if !strings.HasPrefix(string(b), "# Edit these.\n#\n# Then save.\n") {
+		t.Errorf("got header in:\n%s", b)
+	}
+	items, err := parseYAMLItems(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != len(activities) {
+		t.Fatalf("got %d items, want %d", len(items), len(activities))
+	}
+	for i, item := range items {
+		for _, f := range yamlFields {
+			want := f.get(activities[i])
+			if v := item.values[f.key]; v == nil || v.value != want {
+				t.Errorf("item %d, %s: got %+v, want %q", i, f.key, v, want)
+			}
+		}
+	}
+}
+
+func TestParseYAMLScalar(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"Evening Run", "Evening Run", false},
+		{"Evening Run # a comment", "Evening Run", false},
+		{"Run#1", "Run#1", false},
+		{"~", "", false},
+		{"null", "", false},
+		{"", "", false},
+		{`"Say \"hi\""`, `Say "hi"`, false},
+		{`"a # b" # a comment`, "a # b", false},
+		{`"é"`, "é", false},
+		{`'it''s'`, "it's", false},
+		{`'a "b"' # c`, `a "b"`, false},
+		{`"unterminated`, "", true},
+		{`'unterminated`, "", true},
+		{`"a" b`, "", true},
+		{`'a' b`, "", true},
+		{`"\q"`, "", true},
+	}
+	for _, test := range tests {
+		got, err := parseYAMLScalar(test.in)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got error %v, want error %v", test.in, err, test.wantErr)
+		} else if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestParseYAMLItemsErrors(t *testing.T) {
+	tests := []struct {
+		doc  string
+		line int
+		want string
+	}{
+		{"# comment\nid: 1\n", 2, "expected \"- key: value\""},
+		{"- id: 1\n  name:Run\n", 2, "expected \"key: value\""},
+		{"- id: 1\n  name Run\n", 2, "expected \"key: value\""},
+		{"- id: 1\n\n  distance: 5\n", 3, "unknown key \"distance\""},
+		{"- id: 1\n  name: a\n  name: b\n", 3, "duplicate key \"name\""},
+		{"- id: 1\n  name: \"a\n", 2, "missing closing \""},
+	}
+	for _, test := range tests {
+		_, err := parseYAMLItems([]byte(test.doc))
+		yerr, ok := err.(*yamlError)
+		if !ok {
+			t.Errorf("%q: got error %v, want a *yamlError", test.doc, err)
+			continue
+		}
+		if yerr.line != test.line || !strings.Contains(yerr.err.Error(), test.want) {
+			t.Errorf("%q: got %v, want line %d: %s", test.doc, err, test.line, test.want)
+		}
+	}
+}
+
+func TestEditedActivities(t *testing.T) {
+	orig := []*bulk.Activity{
+		{ID: 1, Start: time.Date(2019, 2, 22, 18, 53, 46, 0, time.UTC), ActivityType: "Run", Name: "Evening Run", WorkoutType: "None", GearID: "g1"},
+		{ID: 2, Start: time.Date(2019, 2, 23, 8, 0, 0, 0, time.UTC), ActivityType: "Ride", Name: "Morning Ride", WorkoutType: "None", GearID: "b1"},
+		{ID: 3, Start: time.Date(2019, 2, 24, 8, 0, 0, 0, time.UTC), ActivityType: "Ride", Name: "Unchanged", WorkoutType: "None", GearID: "b1"},
+	}
+	doc := string(marshalActivitiesYAML(orig, "header"))
+	doc = strings.Replace(doc, `"Evening Run"`, `"Tempo Run"`, 1)
+	doc = strings.Replace(doc, "workout_type: None\n  gear_id: b1", "workout_type: LongRun\n  gear_id: b1", 1)
+	prevs, updated, problems := editedActivities([]byte(doc), orig)
+	if len(updated) != 2 || updated[0].Name != "Tempo Run" || prevs[1] != orig[1] {
+		t.Errorf("got updated %v, want the first two activities", updated)
+	}
+	if len(problems) != 1 {
+		t.Fatalf("got problems %v, want one for the Ride's workout_type", problems)
+	}
+	lines := strings.Split(doc, "\n")
+	if line := lines[problems[0].line-1]; line != "  workout_type: LongRun" || problems[0].warning {
+		t.Errorf("got problem %v on line %q", problems[0], line)
+	}
+
+	// An activity that wasn't being edited.
+	_, _, problems = editedActivities([]byte("- id: 4\n  name: x\n"), orig)
+	if len(problems) != 1 || problems[0].line != 1 || !strings.Contains(problems[0].err.Error(), "wasn't one of") {
+		t.Errorf("got problems %v", problems)
+	}
+}
@@ -0,0 +1,443 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package fakestrava
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vangent/strava"
+	"github.com/vangent/stravacli/activityfile"
+)
+
+// apiPrefix is the path prefix for the API, as in the production base
+// path (https://www.strava.com/api/v3).
+const apiPrefix = "/api/v3/"
+
+// Default and maximum page sizes for listing activities.
+const (
+	defaultPerPage = 30
+	maxPerPage     = 200
+)
+
+// validDataTypes are the valid data types for uploads.
+var validDataTypes = map[string]bool{
+	"fit": true, "fit.gz": true,
+	"gpx": true, "gpx.gz": true,
+	"tcx": true, "tcx.gz": true,
+}
+
+// serveAPI serves the API request r for path, relative to apiPrefix. s.mu
+// must be held.
+func (s *Server) serveAPI(w http.ResponseWriter, r *http.Request, path string) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	route := r.Method + " " + parts[0]
+	var id int64
+	if len(parts) > 1 {
+		var err error
+		id, err = strconv.ParseInt(parts[1], 10, 64)
+		if err != nil && parts[0] != "gear" && parts[0] != "athlete" {
+			writeFault(w, http.StatusNotFound, "Record Not Found", "Path", "id", "invalid")
+			return
+		}
+		route += "/{id}"
+		if parts[0] == "athlete" {
+			route = r.Method + " " + strings.Join(parts, "/")
+		}
+	}
+	if len(parts) > 2 {
+		route += "/" + strings.Join(parts[2:], "/")
+	}
+	switch route {
+	case "GET athlete":
+		athlete := s.athlete
+		athlete.Bikes = s.summaryGear(athlete.Bikes)
+		athlete.Shoes = s.summaryGear(athlete.Shoes)
+		writeJSON(w, http.StatusOK, athlete)
+	case "GET athlete/activities":
+		s.listActivities(w, r)
+	case "POST activities":
+		s.createActivity(w, r)
+	case "GET activities/{id}":
+		if a := s.findActivity(w, id); a != nil {
+			writeJSON(w, http.StatusOK, a.toJSON())
+		}
+	case "PUT activities/{id}":
+		if a := s.findActivity(w, id); a != nil {
+			s.updateActivity(w, r, a)
+		}
+	case "GET activities/{id}/streams":
+		if a := s.findActivity(w, id); a != nil {
+			s.getStreams(w, r, a)
+		}
+	case "POST uploads":
+		s.createUpload(w, r)
+	case "GET uploads/{id}":
+		u := s.uploads[id]
+		if u == nil {
+			writeFault(w, http.StatusNotFound, "Record Not Found", "Upload", "id", "invalid")
+			return
+		}
+		writeJSON(w, http.StatusOK, u.Upload)
+	case "GET gear/{id}":
+		g := s.gear[parts[1]]
+		if g == nil {
+			writeFault(w, http.StatusNotFound, "Record Not Found", "Gear", "id", "invalid")
+			return
+		}
+		gear := *g
+		gear.Distance = s.gearDistance(gear.Id)
+		writeJSON(w, http.StatusOK, gear)
+	default:
+		writeFault(w, http.StatusNotFound, "Record Not Found", "Path", "path", "invalid")
+	}
+}
+
+// gearDistance returns the total distance of the activities using the gear
+// id, like Strava does.
+func (s *Server) gearDistance(id string) float32 {
+	var total float32
+	for _, a := range s.activities {
+		if a.GearId == id {
+			total += a.Distance
+		}
+	}
+	return total
+}
+
+// summaryGear returns a copy of gear with up-to-date distances.
+func (s *Server) summaryGear(gear []strava.SummaryGear) []strava.SummaryGear {
+	var updated []strava.SummaryGear
+	for _, g := range gear {
+		g.Distance = s.gearDistance(g.Id)
+		updated = append(updated, g)
+	}
+	return updated
+}
+
+// findActivity returns the activity with the given id, or writes an error
+// and returns nil.
+func (s *Server) findActivity(w http.ResponseWriter, id int64) *activity {
+	a := s.activities[id]
+	if a == nil {
+		writeFault(w, http.StatusNotFound, "Record Not Found", "Activity", "id", "invalid")
+	}
+	return a
+}
+
+// toJSON returns a as a JSON object, including sport_type.
+func (a *activity) toJSON() map[string]interface{} {
+	b, _ := json.Marshal(a.DetailedActivity)
+	m := map[string]interface{}{}
+	json.Unmarshal(b, &m)
+	if a.sportType != "" {
+		m["sport_type"] = a.sportType
+	}
+	return m
+}
+
+func (s *Server) listActivities(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	before, _ := strconv.ParseInt(q.Get("before"), 10, 64)
+	after, _ := strconv.ParseInt(q.Get("after"), 10, 64)
+	page, _ := strconv.Atoi(q.Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	perPage, _ := strconv.Atoi(q.Get("per_page"))
+	if perPage < 1 {
+		perPage = defaultPerPage
+	}
+	if perPage > maxPerPage {
+		perPage = maxPerPage
+	}
+	var matches []*activity
+	for _, a := range s.activities {
+		start := a.StartDate.Unix()
+		if (before != 0 && start >= before) || (after != 0 && start <= after) {
+			continue
+		}
+		matches = append(matches, a)
+	}
+	// Like Strava, list oldest first if after is set, newest first
+	// otherwise.
+	sort.Slice(matches, func(i, j int) bool {
+		if after != 0 {
+			return matches[i].StartDate.Before(matches[j].StartDate)
+		}
+		return matches[i].StartDate.After(matches[j].StartDate)
+	})
+	results := []map[string]interface{}{}
+	for i := (page - 1) * perPage; i < len(matches) && i < page*perPage; i++ {
+		results = append(results, matches[i].toJSON())
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+// parseStartDateLocal parses start_date_local as sent by the client
+// library, which formats it with fmt, or as RFC 3339.
+func parseStartDateLocal(s string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02 15:04:05 -0700 MST", "2006-01-02 15:04:05.999999999 -0700 MST", "2006-01-02T15:04:05"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// parseBool parses a boolean parameter, which may be "1" or "true".
+func parseBool(s string) bool {
+	b, _ := strconv.ParseBool(s)
+	return b
+}
+
+func (s *Server) createActivity(w http.ResponseWriter, r *http.Request) {
+	for _, name := range []string{"name", "type", "start_date_local", "elapsed_time"} {
+		if r.FormValue(name) == "" {
+			writeFault(w, http.StatusBadRequest, "Bad Request", "Activity", name, "missing")
+			return
+		}
+	}
+	start, err := parseStartDateLocal(r.FormValue("start_date_local"))
+	if err != nil {
+		writeFault(w, http.StatusBadRequest, "Bad Request", "Activity", "start_date_local", "invalid")
+		return
+	}
+	elapsed, err := strconv.Atoi(r.FormValue("elapsed_time"))
+	if err != nil {
+		writeFault(w, http.StatusBadRequest, "Bad Request", "Activity", "elapsed_time", "invalid")
+		return
+	}
+	t := strava.ActivityType(r.FormValue("type"))
+	a := strava.DetailedActivity{
+		Name:        r.FormValue("name"),
+		Type_:       &t,
+		StartDate:   start.UTC(),
+		ElapsedTime: int32(elapsed),
+		MovingTime:  int32(elapsed),
+		Description: r.FormValue("description"),
+		Trainer:     parseBool(r.FormValue("trainer")),
+		Commute:     parseBool(r.FormValue("commute")),
+		GearId:      r.FormValue("gear_id"),
+		Manual:      true,
+	}
+	if d, err := strconv.ParseFloat(r.FormValue("distance"), 32); err == nil {
+		a.Distance = float32(d)
+	}
+	if wt, err := strconv.Atoi(r.FormValue("workout_type")); err == nil {
+		a.WorkoutType = int32(wt)
+	}
+	id := s.addActivity(a, r.FormValue("sport_type"))
+	writeJSON(w, http.StatusCreated, s.activities[id].toJSON())
+}
+
+func (s *Server) updateActivity(w http.ResponseWriter, r *http.Request, a *activity) {
+	body, err := ioutil.ReadAll(r.Body)
+	fields := map[string]json.RawMessage{}
+	if err == nil {
+		err = json.Unmarshal(body, &fields)
+	}
+	if err != nil {
+		writeFault(w, http.StatusBadRequest, "Bad Request", "Activity", "body", "invalid")
+		return
+	}
+	var sportTypeSet bool
+	for name, raw := range fields {
+		var err error
+		switch name {
+		case "name":
+			err = json.Unmarshal(raw, &a.Name)
+		case "description":
+			err = json.Unmarshal(raw, &a.Description)
+		case "commute":
+			err = json.Unmarshal(raw, &a.Commute)
+		case "trainer":
+			err = json.Unmarshal(raw, &a.Trainer)
+		case "workout_type":
+			err = json.Unmarshal(raw, &a.WorkoutType)
+		case "gear_id":
+			if err = json.Unmarshal(raw, &a.GearId); a.GearId == "none" {
+				a.GearId = ""
+			}
+		case "type":
+			var t strava.ActivityType
+			if err = json.Unmarshal(raw, &t); err == nil && t != "" {
+				a.Type_ = &t
+				if !sportTypeSet {
+					a.sportType = string(t)
+				}
+			}
+		case "sport_type":
+			err = json.Unmarshal(raw, &a.sportType)
+			sportTypeSet = true
+		}
+		if err != nil {
+			writeFault(w, http.StatusBadRequest, "Bad Request", "Activity", name, "invalid")
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, a.toJSON())
+}
+
+func (s *Server) getStreams(w http.ResponseWriter, r *http.Request, a *activity) {
+	q := r.URL.Query()
+	streams := map[string]interface{}{}
+	for _, key := range strings.Split(q.Get("keys"), ",") {
+		switch key {
+		case "time":
+			streams[key] = strava.TimeStream{OriginalSize: 2, Resolution: "high", SeriesType: "distance", Data: []int32{0, a.ElapsedTime}}
+		case "distance":
+			streams[key] = strava.DistanceStream{OriginalSize: 2, Resolution: "high", SeriesType: "distance", Data: []float32{0, a.Distance}}
+		}
+	}
+	if parseBool(q.Get("key_by_type")) {
+		writeJSON(w, http.StatusOK, streams)
+		return
+	}
+	var list []map[string]interface{}
+	for key, stream := range streams {
+		b, _ := json.Marshal(stream)
+		m := map[string]interface{}{}
+		json.Unmarshal(b, &m)
+		m["type"] = key
+		list = append(list, m)
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) createUpload(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		writeFault(w, http.StatusBadRequest, "Bad Request", "Upload", "file", "invalid")
+		return
+	}
+	f, header, err := r.FormFile("file")
+	if err != nil {
+		writeFault(w, http.StatusBadRequest, "Bad Request", "Upload", "file", "missing")
+		return
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		writeFault(w, http.StatusBadRequest, "Bad Request", "Upload", "file", "invalid")
+		return
+	}
+	dataType := r.FormValue("data_type")
+	if !validDataTypes[dataType] {
+		writeFault(w, http.StatusBadRequest, "Bad Request", "Upload", "data_type", "invalid")
+		return
+	}
+	u := &upload{
+		data:     data,
+		dataType: dataType,
+		params:   map[string]string{"filename": header.Filename},
+		created:  s.Now(),
+	}
+	for _, name := range []string{"name", "description", "activity_type", "sport_type", "trainer", "commute", "gear_id", "workout_type"} {
+		u.params[name] = r.FormValue(name)
+	}
+	u.Id = s.newID()
+	u.ExternalId = r.FormValue("external_id")
+	if u.ExternalId == "" {
+		u.ExternalId = header.Filename
+	}
+	u.Status = uploadStatusProcessing
+	s.uploads[u.Id] = u
+	writeJSON(w, http.StatusCreated, u.Upload)
+}
+
+// processUploads finishes processing the uploads that have been pending for
+// at least ProcessingDelay. s.mu must be held.
+func (s *Server) processUploads() {
+	var ids []int64
+	for id, u := range s.uploads {
+		if u.Status == uploadStatusProcessing && s.Now().Sub(u.created) >= s.ProcessingDelay {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		s.processUpload(s.uploads[id])
+	}
+}
+
+// processUpload parses u's file and creates an activity for it, or records
+// an error. s.mu must be held.
+func (s *Server) processUpload(u *upload) {
+	fail := func(msg string) {
+		u.Status = uploadStatusError
+		u.Error_ = msg
+	}
+	summary, err := activityfile.Parse(bytes.NewReader(u.data))
+	if err != nil {
+		fail(u.params["filename"] + ": Improperly formatted data.")
+		return
+	}
+	if dataType := summary.FileType(); dataType != u.dataType {
+		fail(u.params["filename"] + ": data_type " + u.dataType + " doesn't match the file (" + dataType + ").")
+		return
+	}
+	start := summary.Start.UTC().Truncate(time.Second)
+	for _, a := range s.activities {
+		if (a.ExternalId != "" && a.ExternalId == u.ExternalId) || a.StartDate.Equal(start) {
+			fail(u.params["filename"] + " duplicate of activity " + strconv.FormatInt(a.Id, 10))
+			return
+		}
+	}
+	t := strava.ActivityType(u.params["activity_type"])
+	if t == "" {
+		t = strava.WORKOUT_ActivityType
+	}
+	name := u.params["name"]
+	if name == "" {
+		name = summary.Name
+	}
+	if name == "" {
+		name = string(t)
+	}
+	a := strava.DetailedActivity{
+		ExternalId:  u.ExternalId,
+		UploadId:    u.Id,
+		Name:        name,
+		Type_:       &t,
+		StartDate:   start,
+		ElapsedTime: int32(summary.Duration / time.Second),
+		MovingTime:  int32(summary.Duration / time.Second),
+		Distance:    float32(summary.Distance),
+		Description: u.params["description"],
+		Trainer:     parseBool(u.params["trainer"]),
+		Commute:     parseBool(u.params["commute"]),
+		GearId:      u.params["gear_id"],
+		DeviceName:  summary.Device,
+	}
+	if wt, err := strconv.Atoi(u.params["workout_type"]); err == nil {
+		a.WorkoutType = int32(wt)
+	}
+	u.ActivityId = s.addActivity(a, u.params["sport_type"])
+	u.Status = uploadStatusReady
+}
@@ -0,0 +1,311 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+// Package fakestrava is an in-memory fake of the parts of the Strava API
+// that stravacli uses: activities, uploads (processed asynchronously),
+// the athlete, gear, streams, and the OAuth endpoints. It also reports and
+// enforces rate limits.
+//
+// A Server is an http.Handler; serve it with net/http/httptest for tests:
+//
+//	fake := fakestrava.New()
+//	ts := httptest.NewServer(fake)
+//	defer ts.Close()
+//	// Use ts.URL as stravacli's --api_base, and fakestrava.AccessToken as
+//	// the access token.
+package fakestrava
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vangent/strava"
+)
+
+// AccessToken is an access token that a new Server accepts. Tokens issued
+// by the fake OAuth endpoints are accepted too.
+const AccessToken = "fake-access-token"
+
+// AthleteID is the ID of the fake athlete.
+const AthleteID = 1
+
+// Default rate limits, per 15 minutes and per day.
+const (
+	DefaultShortLimit = 600
+	DefaultDailyLimit = 30000
+)
+
+// Upload statuses, as reported by Strava.
+const (
+	uploadStatusProcessing = "Your activity is still being processed."
+	uploadStatusError      = "There was an error processing your activity."
+	uploadStatusReady      = "Your activity is ready."
+)
+
+// Server is a fake Strava API server. Its exported fields may be changed
+// before it starts serving.
+type Server struct {
+	// ProcessingDelay is how long uploads take to process.
+	ProcessingDelay time.Duration
+	// ShortLimit and DailyLimit are the number of requests allowed per 15
+	// minutes and per day; requests beyond them fail with 429 Too Many
+	// Requests.
+	ShortLimit, DailyLimit int
+	// Now returns the current time; it may be replaced to control time in
+	// tests.
+	Now func() time.Time
+
+	mu          sync.Mutex
+	athlete     strava.DetailedAthlete
+	activities  map[int64]*activity
+	gear        map[string]*strava.DetailedGear
+	uploads     map[int64]*upload
+	tokens      map[string]bool // valid access tokens
+	codes       map[string]bool // unused OAuth authorization codes
+	refresh     map[string]bool // valid refresh tokens
+	nextID      int64
+	shortStart  time.Time // start of the current 15 minute window
+	shortUsage  int
+	dailyStart  time.Time // start of the current day
+	dailyUsage  int
+	numRequests int
+}
+
+// activity is an activity stored by the Server. The client library's
+// DetailedActivity doesn't have sport_type, so it's stored separately.
+type activity struct {
+	strava.DetailedActivity
+	sportType string
+}
+
+// upload is an upload stored by the Server.
+type upload struct {
+	strava.Upload
+	data     []byte
+	dataType string
+	params   map[string]string
+	created  time.Time
+}
+
+// New returns a new Server with a fake athlete, no activities or gear, and
+// the default rate limits.
+func New() *Server {
+	return &Server{
+		ShortLimit: DefaultShortLimit,
+		DailyLimit: DefaultDailyLimit,
+		Now:        time.Now,
+		athlete: strava.DetailedAthlete{
+			Id:            AthleteID,
+			ResourceState: 3,
+			Firstname:     "Fake",
+			Lastname:      "Athlete",
+		},
+		activities: map[int64]*activity{},
+		gear:       map[string]*strava.DetailedGear{},
+		uploads:    map[int64]*upload{},
+		tokens:     map[string]bool{AccessToken: true},
+		codes:      map[string]bool{},
+		refresh:    map[string]bool{},
+		nextID:     1000,
+	}
+}
+
+// newID returns a new unique ID. s.mu must be held.
+func (s *Server) newID() int64 {
+	s.nextID++
+	return s.nextID
+}
+
+// AddActivity adds a to the fake athlete's activities, and returns its ID.
+// If a.Id is 0, a new ID is assigned. If sportType is empty, it defaults
+// to a's type.
+func (s *Server) AddActivity(a strava.DetailedActivity, sportType string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addActivity(a, sportType)
+}
+
+// addActivity is AddActivity with s.mu held.
+func (s *Server) addActivity(a strava.DetailedActivity, sportType string) int64 {
+	if a.Id == 0 {
+		a.Id = s.newID()
+	}
+	a.Athlete = &strava.MetaAthlete{Id: AthleteID}
+	if a.StartDateLocal.IsZero() {
+		a.StartDateLocal = a.StartDate
+	}
+	if sportType == "" && a.Type_ != nil {
+		sportType = string(*a.Type_)
+	}
+	s.activities[a.Id] = &activity{DetailedActivity: a, sportType: sportType}
+	return a.Id
+}
+
+// Activity returns the activity with the given ID, and its sport type.
+func (s *Server) Activity(id int64) (a strava.DetailedActivity, sportType string, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.processUploads()
+	if act := s.activities[id]; act != nil {
+		return act.DetailedActivity, act.sportType, true
+	}
+	return a, "", false
+}
+
+// Activities returns all of the fake athlete's activities, ordered by ID.
+func (s *Server) Activities() []strava.DetailedActivity {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.processUploads()
+	var activities []strava.DetailedActivity
+	for _, a := range s.activities {
+		activities = append(activities, a.DetailedActivity)
+	}
+	sort.Slice(activities, func(i, j int) bool { return activities[i].Id < activities[j].Id })
+	return activities
+}
+
+// AddGear adds g to the fake athlete's bikes (if bike is true) or shoes.
+func (s *Server) AddGear(g strava.DetailedGear, bike bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g.ResourceState = 3
+	s.gear[g.Id] = &g
+	summary := strava.SummaryGear{Id: g.Id, ResourceState: 2, Primary: g.Primary, Name: g.Name, Distance: g.Distance}
+	if bike {
+		s.athlete.Bikes = append(s.athlete.Bikes, summary)
+	} else {
+		s.athlete.Shoes = append(s.athlete.Shoes, summary)
+	}
+}
+
+// Upload returns the upload with the given ID.
+func (s *Server) Upload(id int64) (strava.Upload, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.processUploads()
+	if u := s.uploads[id]; u != nil {
+		return u.Upload, true
+	}
+	return strava.Upload{}, false
+}
+
+// NumRequests returns the number of API requests the Server has received,
+// including ones that failed.
+func (s *Server) NumRequests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.numRequests
+}
+
+// Seed adds sample gear (a bike "b1" and shoes "g1") and n sample
+// activities, one per day ending the day before end, alternating between
+// rides and runs.
+func (s *Server) Seed(n int, end time.Time) {
+	s.AddGear(strava.DetailedGear{Id: "b1", Name: "Road Bike", BrandName: "Fake", ModelName: "Roadster", Primary: true}, true)
+	s.AddGear(strava.DetailedGear{Id: "g1", Name: "Trainers", BrandName: "Fake", ModelName: "Runner", Primary: true}, false)
+	day := time.Date(end.Year(), end.Month(), end.Day(), 7, 30, 0, 0, time.UTC)
+	for i := 1; i <= n; i++ {
+		start := day.AddDate(0, 0, -i)
+		a := strava.DetailedActivity{
+			StartDate: start,
+			Timezone:  "(GMT+00:00) UTC",
+		}
+		t := strava.RIDE_ActivityType
+		a.Name, a.GearId, a.ElapsedTime, a.Distance = "Morning Ride", "b1", 5400, 40000
+		if i%2 == 0 {
+			t = strava.RUN_ActivityType
+			a.Name, a.GearId, a.ElapsedTime, a.Distance = "Morning Run", "g1", 2700, 8000
+		}
+		a.Type_ = &t
+		a.MovingTime = a.ElapsedTime
+		s.AddActivity(a, "")
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.numRequests++
+	s.processUploads()
+
+	if strings.HasPrefix(r.URL.Path, "/oauth/") {
+		s.serveOAuth(w, r)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, apiPrefix) {
+		writeFault(w, http.StatusNotFound, "Record Not Found", "Path", "path", "invalid")
+		return
+	}
+	if !s.checkRateLimit(w) {
+		return
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !s.tokens[token] {
+		writeFault(w, http.StatusUnauthorized, "Authorization Error", "Athlete", "access_token", "invalid")
+		return
+	}
+	s.serveAPI(w, r, strings.TrimPrefix(r.URL.Path, apiPrefix))
+}
+
+// checkRateLimit counts a request against the rate limits, and sets the
+// rate limit headers. If a limit has been exceeded, it writes an error and
+// returns false. s.mu must be held.
+func (s *Server) checkRateLimit(w http.ResponseWriter) bool {
+	now := s.Now().UTC()
+	if start := now.Truncate(15 * time.Minute); !start.Equal(s.shortStart) {
+		s.shortStart, s.shortUsage = start, 0
+	}
+	if start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC); !start.Equal(s.dailyStart) {
+		s.dailyStart, s.dailyUsage = start, 0
+	}
+	s.shortUsage++
+	s.dailyUsage++
+	w.Header().Set("X-RateLimit-Limit", fmt.Sprintf("%d,%d", s.ShortLimit, s.DailyLimit))
+	w.Header().Set("X-RateLimit-Usage", fmt.Sprintf("%d,%d", s.shortUsage, s.dailyUsage))
+	if s.shortUsage > s.ShortLimit || s.dailyUsage > s.DailyLimit {
+		writeFault(w, http.StatusTooManyRequests, "Rate Limit Exceeded", "Application", "rate limit", "exceeded")
+		return false
+	}
+	return true
+}
+
+// writeJSON writes v as the response, with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeFault writes a Strava-style error response.
+func writeFault(w http.ResponseWriter, status int, message, resource, field, code string) {
+	writeJSON(w, status, strava.Fault{
+		Message: message,
+		Errors:  []strava.ModelError{{Resource: resource, Field: field, Code: code}},
+	})
+}
@@ -0,0 +1,267 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package fakestrava
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testGPX is a short ride.
+const testGPX = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1">
+<trk><name>Evening Ride</name><trkseg>
+<trkpt lat="47.60" lon="-122.30"><time>2019-02-27T18:00:00Z</time></trkpt>
+<trkpt lat="47.61" lon="-122.30"><time>2019-02-27T18:10:00Z</time></trkpt>
+</trkseg></trk>
+</gpx>
+`
+
+// testClock is a fake clock for Server.Now.
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time { return c.now }
+
+// newTestServer returns a Server using a fake clock, and an httptest.Server
+// serving it.
+func newTestServer() (*Server, *testClock, *httptest.Server) {
+	clock := &testClock{time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)}
+	s := New()
+	s.Now = clock.Now
+	return s, clock, httptest.NewServer(s)
+}
+
+// do sends an API request with token, and decodes the JSON response into v
+// if it's not nil. It returns the response, with its body closed.
+func do(t *testing.T, req *http.Request, token string, v interface{}) *http.Response {
+	t.Helper()
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp
+}
+
+func get(t *testing.T, u, token string, v interface{}) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return do(t, req, token, v)
+}
+
+// postUpload uploads data as a file of dataType, and returns the decoded
+// response.
+func postUpload(t *testing.T, u, dataType, data string) map[string]interface{} {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("data_type", dataType)
+	fw, err := mw.CreateFormFile("file", "ride."+dataType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write([]byte(data))
+	mw.Close()
+	req, err := http.NewRequest("POST", u+"/api/v3/uploads", &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	var m map[string]interface{}
+	if resp := do(t, req, AccessToken, &m); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("upload: got status %d: %v", resp.StatusCode, m)
+	}
+	return m
+}
+
+func TestAccessToken(t *testing.T) {
+	_, _, ts := newTestServer()
+	defer ts.Close()
+
+	if resp := get(t, ts.URL+"/api/v3/athlete", AccessToken, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("got status %d, want 200", resp.StatusCode)
+	}
+	if resp := get(t, ts.URL+"/api/v3/athlete", "bad-token", nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("bad token: got status %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	s, clock, ts := newTestServer()
+	defer ts.Close()
+	s.ShortLimit = 2
+
+	var statuses []int
+	for i := 0; i < 3; i++ {
+		statuses = append(statuses, get(t, ts.URL+"/api/v3/athlete", AccessToken, nil).StatusCode)
+	}
+	if want := []int{200, 200, 429}; !equalInts(statuses, want) {
+		t.Errorf("got statuses %v, want %v", statuses, want)
+	}
+	// The limit resets in the next 15 minute window, and the usage is
+	// reported in the headers.
+	clock.now = clock.now.Add(15 * time.Minute)
+	resp := get(t, ts.URL+"/api/v3/athlete", AccessToken, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("next window: got status %d, want 200", resp.StatusCode)
+	}
+	if got := resp.Header.Get("X-RateLimit-Usage"); got != "1,4" {
+		t.Errorf("got X-RateLimit-Usage %q, want %q", got, "1,4")
+	}
+	if got := resp.Header.Get("X-RateLimit-Limit"); got != "2,30000" {
+		t.Errorf("got X-RateLimit-Limit %q, want %q", got, "2,30000")
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestUploadProcessing(t *testing.T) {
+	s, clock, ts := newTestServer()
+	defer ts.Close()
+	s.ProcessingDelay = time.Minute
+
+	id := int64(postUpload(t, ts.URL, "gpx", testGPX)["id"].(float64))
+	uploadURL := ts.URL + "/api/v3/uploads/" + strconv.FormatInt(id, 10)
+	var u map[string]interface{}
+	get(t, uploadURL, AccessToken, &u)
+	if u["status"] != uploadStatusProcessing || u["activity_id"] != nil {
+		t.Errorf("before ProcessingDelay: got %v, want it still processing", u)
+	}
+
+	clock.now = clock.now.Add(time.Minute)
+	u = nil
+	get(t, uploadURL, AccessToken, &u)
+	if u["status"] != uploadStatusReady || u["activity_id"] == nil {
+		t.Fatalf("after ProcessingDelay: got %v, want it ready", u)
+	}
+	a, _, ok := s.Activity(int64(u["activity_id"].(float64)))
+	if !ok || a.Name != "Evening Ride" || !a.StartDate.Equal(time.Date(2019, 2, 27, 18, 0, 0, 0, time.UTC)) {
+		t.Errorf("got activity %+v", a)
+	}
+
+	// The same file again is a duplicate, and a bad file fails.
+	for _, test := range []struct{ dataType, data, wantErr string }{
+		{"gpx", testGPX, "duplicate of activity"},
+		{"gpx", "not a gpx file", "Improperly formatted data"},
+		{"tcx", testGPX, "doesn't match the file"},
+	} {
+		id := int64(postUpload(t, ts.URL, test.dataType, test.data)["id"].(float64))
+		clock.now = clock.now.Add(time.Minute)
+		// Uploads are processed when a request is served.
+		get(t, ts.URL+"/api/v3/athlete", AccessToken, nil)
+		up, _ := s.Upload(id)
+		if up.Status != uploadStatusError || !strings.Contains(up.Error_, test.wantErr) {
+			t.Errorf("%s: got status %q and error %q, want error containing %q", test.data, up.Status, up.Error_, test.wantErr)
+		}
+	}
+}
+
+func TestOAuth(t *testing.T) {
+	_, _, ts := newTestServer()
+	defer ts.Close()
+
+	// The authorize endpoint redirects back with a code right away.
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(ts.URL + "/oauth/authorize?client_id=1&state=xyz&redirect_uri=" + url.QueryEscape("http://127.0.0.1:8080/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := loc.Query().Get("code")
+	if resp.StatusCode != http.StatusFound || loc.Host != "127.0.0.1:8080" || code == "" || loc.Query().Get("state") != "xyz" {
+		t.Fatalf("got status %d and redirect to %q", resp.StatusCode, loc)
+	}
+
+	token := func(form url.Values) (int, map[string]interface{}) {
+		t.Helper()
+		form.Set("client_id", "1")
+		form.Set("client_secret", "secret")
+		resp, err := http.PostForm(ts.URL+"/oauth/token", form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		m := map[string]interface{}{}
+		if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, m
+	}
+	status, m := token(url.Values{"code": {code}})
+	if status != http.StatusOK {
+		t.Fatalf("got status %d: %v", status, m)
+	}
+	access, _ := m["access_token"].(string)
+	if resp := get(t, ts.URL+"/api/v3/athlete", access, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("issued access token: got status %d, want 200", resp.StatusCode)
+	}
+	if athlete, _ := m["athlete"].(map[string]interface{}); athlete["firstname"] != "Fake" {
+		t.Errorf("got athlete %v", m["athlete"])
+	}
+	if status, _ := token(url.Values{"code": {code}}); status != http.StatusBadRequest {
+		t.Errorf("reused code: got status %d, want 400", status)
+	}
+
+	// Refresh tokens can be used once, and issue a new access token.
+	refresh := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {m["refresh_token"].(string)}}
+	status, m = token(refresh)
+	if status != http.StatusOK || m["access_token"] == access {
+		t.Errorf("refresh: got status %d and %v", status, m)
+	}
+	if status, _ := token(refresh); status != http.StatusBadRequest {
+		t.Errorf("reused refresh token: got status %d, want 400", status)
+	}
+}
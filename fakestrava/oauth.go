@@ -0,0 +1,107 @@
+/*
+Copyright © 2019 Robert van Gent (vangent@gmail.com)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package fakestrava
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// tokenLifetime is how long issued access tokens are said to be valid for;
+// the Server doesn't actually expire them.
+const tokenLifetime = 6 * 60 * 60
+
+// serveOAuth serves the OAuth endpoints. The authorize endpoint approves
+// every request immediately. s.mu must be held.
+func (s *Server) serveOAuth(w http.ResponseWriter, r *http.Request) {
+	switch r.Method + " " + r.URL.Path {
+	case "GET /oauth/authorize":
+		redirect, err := url.Parse(r.FormValue("redirect_uri"))
+		if err != nil || r.FormValue("redirect_uri") == "" || r.FormValue("client_id") == "" {
+			writeFault(w, http.StatusBadRequest, "Bad Request", "Application", "redirect_uri", "invalid")
+			return
+		}
+		code := fmt.Sprintf("fake-code-%d", s.newID())
+		s.codes[code] = true
+		q := redirect.Query()
+		q.Set("code", code)
+		q.Set("scope", r.FormValue("scope"))
+		if state := r.FormValue("state"); state != "" {
+			q.Set("state", state)
+		}
+		redirect.RawQuery = q.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	case "POST /oauth/token":
+		s.issueToken(w, r)
+	default:
+		writeFault(w, http.StatusNotFound, "Record Not Found", "Path", "path", "invalid")
+	}
+}
+
+func (s *Server) issueToken(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("client_id") == "" || r.FormValue("client_secret") == "" {
+		writeFault(w, http.StatusBadRequest, "Bad Request", "Application", "client_id", "invalid")
+		return
+	}
+	grantType := r.FormValue("grant_type")
+	if grantType == "" {
+		grantType = "authorization_code"
+	}
+	resp := map[string]interface{}{}
+	switch grantType {
+	case "authorization_code":
+		code := r.FormValue("code")
+		if !s.codes[code] {
+			writeFault(w, http.StatusBadRequest, "Bad Request", "AuthorizationCode", "code", "invalid")
+			return
+		}
+		delete(s.codes, code)
+		resp["athlete"] = map[string]interface{}{
+			"id":        s.athlete.Id,
+			"firstname": s.athlete.Firstname,
+			"lastname":  s.athlete.Lastname,
+		}
+	case "refresh_token":
+		refresh := r.FormValue("refresh_token")
+		if !s.refresh[refresh] {
+			writeFault(w, http.StatusBadRequest, "Bad Request", "RefreshToken", "refresh_token", "invalid")
+			return
+		}
+		delete(s.refresh, refresh)
+	default:
+		writeFault(w, http.StatusBadRequest, "Bad Request", "Application", "grant_type", "invalid")
+		return
+	}
+	id := s.newID()
+	access := fmt.Sprintf("fake-access-token-%d", id)
+	refresh := fmt.Sprintf("fake-refresh-token-%d", id)
+	s.tokens[access] = true
+	s.refresh[refresh] = true
+	resp["token_type"] = "Bearer"
+	resp["access_token"] = access
+	resp["refresh_token"] = refresh
+	resp["expires_in"] = tokenLifetime
+	resp["expires_at"] = s.Now().Unix() + tokenLifetime
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -1,11 +1,12 @@
 module github.com/vangent/stravacli
 
-go 1.12
+go 1.13
 
 require (
 	github.com/antihax/optional v0.0.0-20180407024304-ca021399b1a6
 	github.com/gocarina/gocsv v0.0.0-20190802110148-150c53a64ab6
 	github.com/skratchdot/open-golang v0.0.0-20190402232053-79abb63cd66e
 	github.com/spf13/cobra v0.0.5
+	github.com/spf13/pflag v1.0.3
 	github.com/vangent/strava v0.0.0-20190829211933-3ae918a9fdfc
 )